@@ -1,13 +1,56 @@
 package main
 
 import (
-	"github.com/XxRoloxX/dns/pkg/dns_message"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/XxRoloxX/dns/pkg/dns_server"
 )
 
 func main() {
 
-	srv := server.NewServer()
+	options, err := server.ParseOptions(os.Args[0], os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	config, err := options.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	if options.CheckConfig {
+		fmt.Println("Configuration is valid")
+		return
+	}
+
+	level, _ := config.LogLevel()
+	slog.SetLogLoggerLevel(level)
+
+	srv, err := server.NewServer(config)
+	if err != nil {
+		slog.Error("Failed to start DNS server", "err", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if err := srv.Serve(ctx); err != nil {
+		slog.Error("Failed to shut down DNS server gracefully", "err", err)
+		os.Exit(1)
+	}
 
-	srv.Listen(make(chan message.Message))
+	slog.Info("DNS server stopped")
 }
@@ -0,0 +1,109 @@
+package dnsaddress
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Default port of DNS over UDP and TCP
+	DNS_PORT = "53"
+
+	// Default port of DNS-over-TLS and DNS-over-QUIC (RFC 7858 §3.1, RFC 9250 §4.1.1)
+	DOT_PORT = "853"
+
+	// Default port of DNS-over-HTTPS
+	DOH_PORT = "443"
+)
+
+// Transports of upstreams, selected by the scheme of their address
+const (
+	SCHEME_UDP   = "udp"
+	SCHEME_TCP   = "tcp"
+	SCHEME_TLS   = "tls"
+	SCHEME_QUIC  = "quic"
+	SCHEME_HTTPS = "https"
+)
+
+// Parsed address of a server queries are forwarded to
+type Upstream struct {
+	// Address the upstream was parsed from, with the UDP scheme added when it had none
+	Address string
+
+	Scheme string
+
+	// Host and port of the server, with the default port of the transport when the address has none
+	HostPort string
+
+	// Name verified against the certificate of the server
+	ServerName string
+
+	// URL queries are sent to over HTTPS, without the server name
+	URL string
+}
+
+// Parses address of the upstream: "1.1.1.1" or "udp://1.1.1.1:53" for UDP, "tcp://1.1.1.1",
+// "tls://1.1.1.1#cloudflare-dns.com", "quic://dns.adguard-dns.com" or "https://dns.google/dns-query".
+// Name after "#" is verified against the certificate of the server, its host is verified when it's missing
+func ParseUpstream(address string) (*Upstream, error) {
+
+	if !strings.Contains(address, "://") {
+		address = SCHEME_UDP + "://" + address
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid upstream: %s", address))
+	}
+
+	upstream := &Upstream{
+		Address:    address,
+		Scheme:     parsed.Scheme,
+		ServerName: parsed.Fragment,
+	}
+
+	if upstream.ServerName == "" {
+		upstream.ServerName = parsed.Hostname()
+	}
+
+	port := ""
+	switch parsed.Scheme {
+	case SCHEME_UDP, SCHEME_TCP:
+		port = DNS_PORT
+	case SCHEME_TLS, SCHEME_QUIC:
+		port = DOT_PORT
+	case SCHEME_HTTPS:
+		port = DOH_PORT
+
+		parsed.Fragment = ""
+		upstream.URL = parsed.String()
+	default:
+		return nil, errors.New(fmt.Sprintf("Invalid upstream: %s, expected udp, tcp, tls, quic or https scheme", address))
+	}
+
+	upstream.HostPort = parsed.Host
+	if parsed.Port() == "" {
+		upstream.HostPort = net.JoinHostPort(parsed.Hostname(), port)
+	}
+
+	return upstream, nil
+}
+
+// Returns address of the name server with the default port added when it has none
+func ServerAddress(value string) (string, error) {
+
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return net.JoinHostPort(addr.String(), DNS_PORT), nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(value)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Invalid name server address: %s, expected IP address with optional port", value))
+	}
+
+	return addrPort.String(), nil
+}
@@ -0,0 +1,101 @@
+package dnsaddress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUpstream(t *testing.T) {
+	testCases := []struct {
+		name        string
+		address     string
+		expected    *Upstream
+		expectedErr bool
+	}{
+		{
+			name:     "Address without scheme should use UDP on the DNS port",
+			address:  "192.0.2.1",
+			expected: &Upstream{Address: "udp://192.0.2.1", Scheme: SCHEME_UDP, HostPort: "192.0.2.1:53", ServerName: "192.0.2.1"},
+		},
+		{
+			name:     "TLS upstream should default to port 853 and verify the name after #",
+			address:  "tls://192.0.2.1#dns.example.com",
+			expected: &Upstream{Address: "tls://192.0.2.1#dns.example.com", Scheme: SCHEME_TLS, HostPort: "192.0.2.1:853", ServerName: "dns.example.com"},
+		},
+		{
+			name:    "HTTPS upstream should keep its path in the URL",
+			address: "https://dns.google/dns-query",
+			expected: &Upstream{
+				Address:    "https://dns.google/dns-query",
+				Scheme:     SCHEME_HTTPS,
+				HostPort:   "dns.google:443",
+				ServerName: "dns.google",
+				URL:        "https://dns.google/dns-query",
+			},
+		},
+		{
+			name:        "Unknown scheme should be rejected",
+			address:     "ftp://192.0.2.1",
+			expectedErr: true,
+		},
+		{
+			name:        "Address without host should be rejected",
+			address:     "https://",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, err := ParseUpstream(tc.address)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, upstream)
+		})
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "Address without port should get the DNS port",
+			value:    "2001:db8::1",
+			expected: "[2001:db8::1]:53",
+		},
+		{
+			name:     "Address with port should keep it",
+			value:    "192.0.2.1:5353",
+			expected: "192.0.2.1:5353",
+		},
+		{
+			name:        "Host name should be rejected",
+			value:       "a.root-servers.net",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, err := ServerAddress(tc.value)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, addr)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+const (
+	// Zones whose name servers are remembered between resolutions
+	MAX_CACHED_DELEGATIONS = 10000
+
+	// Longest time name servers of a zone are remembered, regardless of the TTL of their NS records
+	MAX_DELEGATION_TTL = 24 * time.Hour
+)
+
+// Name servers of a zone learned from a referral
+type delegation struct {
+	zone    []string
+	servers []string
+	expires time.Time
+}
+
+// Remembers name servers of zones learned from referrals, so resolutions of names in the same zones
+// start at their servers instead of the root
+type delegationCache struct {
+	lock        sync.Mutex
+	delegations map[string]*delegation
+	maxSize     int
+	now         func() time.Time
+}
+
+func newDelegationCache(maxSize int) *delegationCache {
+	return &delegationCache{
+		delegations: make(map[string]*delegation),
+		maxSize:     maxSize,
+		now:         time.Now,
+	}
+}
+
+func delegationKey(zone []string) string {
+	return strings.ToLower(strings.Join(zone, "."))
+}
+
+// Returns the deepest zone enclosing the name with name servers still remembered
+func (c *delegationCache) closest(name []string) (*delegation, bool) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+
+	for i := 0; i < len(name); i++ {
+		cached, ok := c.delegations[delegationKey(name[i:])]
+		if !ok {
+			continue
+		}
+
+		if now.After(cached.expires) {
+			delete(c.delegations, delegationKey(name[i:]))
+			continue
+		}
+
+		return cached, true
+	}
+
+	return nil, false
+}
+
+// Remembers name servers of the zone for the TTL. Nothing is remembered when the cache is full
+// of delegations which haven't expired yet
+func (c *delegationCache) store(zone []string, servers []string, ttl time.Duration) {
+
+	if ttl <= 0 || len(servers) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	key := delegationKey(zone)
+
+	if _, ok := c.delegations[key]; !ok && len(c.delegations) >= c.maxSize {
+		for key, cached := range c.delegations {
+			if now.After(cached.expires) {
+				delete(c.delegations, key)
+			}
+		}
+
+		if len(c.delegations) >= c.maxSize {
+			return
+		}
+	}
+
+	c.delegations[key] = &delegation{
+		zone:    zone,
+		servers: servers,
+		expires: now.Add(min(ttl, MAX_DELEGATION_TTL)),
+	}
+}
+
+// Forgets name servers of the zone, after none of them could be reached
+func (c *delegationCache) remove(zone []string) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.delegations, delegationKey(zone))
+}
+
+// Returns the lowest TTL of NS records delegating the zone in the referral
+func referralTtl(referral *message.Message, zone []string) time.Duration {
+
+	var ttl uint32
+	found := false
+
+	for _, answer := range referral.Body.Authorative {
+		if answer.ResourceRecordType != record.ResourceRecordType__NS || !sameName(answer.Name, zone) {
+			continue
+		}
+
+		if !found || answer.Ttl < ttl {
+			ttl = answer.Ttl
+			found = true
+		}
+	}
+
+	return time.Duration(ttl) * time.Second
+}
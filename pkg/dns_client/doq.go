@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"sync"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	"github.com/quic-go/quic-go"
+)
+
+// ALPN protocol identifier of DNS-over-QUIC (RFC 9250 §4.1)
+const DOQ_ALPN = "doq"
+
+// DNS-over-QUIC transport (RFC 9250). Queries share single connection, each one sent on its own stream
+type DoQClient struct {
+	addr      string
+	tlsConfig *tls.Config
+	lock      sync.Mutex
+	conn      quic.EarlyConnection
+}
+
+func NewDoQClient(addr string, tlsConfig *tls.Config) *DoQClient {
+
+	config := tlsConfig.Clone()
+	config.NextProtos = []string{DOQ_ALPN}
+
+	// Resumed sessions allow idempotent queries to be sent in 0-RTT data
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	return &DoQClient{
+		addr:      addr,
+		tlsConfig: config,
+	}
+}
+
+// Returns open connection to the server, dialing a new one when there is none
+func (c *DoQClient) connection(ctx context.Context) (quic.EarlyConnection, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, nil
+	}
+
+	conn, err := quic.DialAddrEarly(ctx, c.addr, c.tlsConfig, &quic.Config{})
+	if err != nil {
+		slog.Error("Failed to connect to DoQ server", "addr", c.addr, "err", err)
+		return nil, err
+	}
+
+	c.conn = conn
+
+	return conn, nil
+}
+
+// Sends the message and waits for the response
+func (c *DoQClient) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0-RTT data can be replayed, so other operations wait for the handshake to complete (RFC 9250 §4.5)
+	if msg.Header.Flags.OperationCode != message.OpCode__Query {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	// Stream identifies the query, so the message ID is always 0 (RFC 9250 §4.2.1)
+	query := *msg
+	query.Header.TransactionId = 0
+
+	encoded := message.NewEncoder().Encode(&query)
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, len(encoded)+2), uint16(len(encoded)))
+
+	_, err = stream.Write(append(framed, encoded...))
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the sending side marks the end of the query
+	err = stream.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, err
+	}
+
+	var response message.Message
+
+	err = message.NewDecoder(buf).Decode(&response)
+	if err != nil {
+		slog.Error("Failed to decode message from DoQ server", "err", err)
+		return nil, err
+	}
+
+	response.Header.TransactionId = msg.Header.TransactionId
+
+	return &response, nil
+}
+
+func (c *DoQClient) Close() error {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.CloseWithError(0, "")
+}
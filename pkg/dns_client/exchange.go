@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+// UDP payload size advertised in queries, avoiding IP fragmentation
+const EDNS_UDP_PAYLOAD_SIZE = 1232
+
+// Largest DNS message, limited by 2 byte length prefix over TCP
+const MAX_MESSAGE_SIZE = 65535
+
+// Sends the query to the server over UDP, retrying over TCP when the response is truncated
+func Exchange(ctx context.Context, addr string, msg *message.Message) (*message.Message, error) {
+
+	response, err := ExchangeUDP(ctx, addr, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Header.Flags.Truncation {
+		return ExchangeTCP(ctx, addr, msg)
+	}
+
+	return response, nil
+}
+
+// Sends the query in single UDP datagram. Responses with other ID or question are ignored,
+// so they can't be spoofed without guessing both the ID and the source port
+func ExchangeUDP(ctx context.Context, addr string, msg *message.Message) (*message.Message, error) {
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Closing the connection unblocks the read when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	query := withRandomID(msg)
+
+	if _, err := conn.Write(message.NewEncoder().Encode(query)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, MAX_MESSAGE_SIZE)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		response, err := decodeResponse(buf[:n], query)
+		if err != nil {
+			continue
+		}
+
+		response.Header.TransactionId = msg.Header.TransactionId
+		return response, nil
+	}
+}
+
+// Sends the query over TCP, each message prefixed with its length (RFC 1035 §4.2.2)
+func ExchangeTCP(ctx context.Context, addr string, msg *message.Message) (*message.Message, error) {
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return exchangeStream(ctx, conn, msg)
+}
+
+// Exchanges the query over connection oriented transport, TCP or TLS
+func exchangeStream(ctx context.Context, conn net.Conn, msg *message.Message) (*message.Message, error) {
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	query := withRandomID(msg)
+
+	encoded := message.NewEncoder().Encode(query)
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, len(encoded)+2), uint16(len(encoded)))
+
+	if _, err := conn.Write(append(framed, encoded...)); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	response, err := decodeResponse(buf, query)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Header.TransactionId = msg.Header.TransactionId
+	return response, nil
+}
+
+// Returns error of the context when it caused the failure, which tells apart timeouts from broken connections
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Copies the query, giving it an unpredictable ID
+func withRandomID(msg *message.Message) *message.Message {
+	query := *msg
+	query.Header.TransactionId = uint16(rand.UintN(1 << 16))
+	return &query
+}
+
+// Decodes the response, checking that it answers the query
+func decodeResponse(buf []byte, query *message.Message) (*message.Message, error) {
+
+	var response message.Message
+
+	if err := message.NewDecoder(buf).Decode(&response); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to decode response: %s", err))
+	}
+
+	if response.Header.Flags.Query || response.Header.TransactionId != query.Header.TransactionId {
+		return nil, errors.New("Response doesn't match the query")
+	}
+
+	// Truncated responses and some errors may come without the question
+	if len(response.Body.Queries) == 0 {
+		return &response, nil
+	}
+
+	if len(response.Body.Queries) != len(query.Body.Queries) {
+		return nil, errors.New("Response doesn't match the query")
+	}
+
+	for i, question := range response.Body.Queries {
+		asked := query.Body.Queries[i]
+		if question.ResourceRecordType != asked.ResourceRecordType ||
+			question.ResourceRecordClass != asked.ResourceRecordClass ||
+			!strings.EqualFold(strings.Join(question.Name, "."), strings.Join(asked.Name, ".")) {
+			return nil, errors.New("Response doesn't match the query")
+		}
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,537 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	dnsaddress "github.com/XxRoloxX/dns/pkg/dns_address"
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+// Addresses of the root name servers a to m (https://www.iana.org/domains/root/servers)
+var ROOT_HINTS = []string{
+	"198.41.0.4", "2001:503:ba3e::2:30",
+	"170.247.170.2", "2801:1b8:10::b",
+	"192.33.4.12", "2001:500:2::c",
+	"199.7.91.13", "2001:500:2d::d",
+	"192.203.230.10", "2001:500:a8::e",
+	"192.5.5.241", "2001:500:2f::f",
+	"192.112.36.4", "2001:500:12::d0d",
+	"198.97.190.53", "2001:500:1::53",
+	"192.36.148.17", "2001:7fe::53",
+	"192.58.128.30", "2001:503:c27::2:30",
+	"193.0.14.129", "2001:7fd::1",
+	"199.7.83.42", "2001:500:9f::42",
+	"202.12.27.33", "2001:dc3::35",
+}
+
+const (
+	// Referrals followed while resolving single name
+	DEFAULT_MAX_REFERRALS = 16
+
+	// CNAMEs followed while answering single query
+	DEFAULT_MAX_CNAME_CHAIN = 8
+
+	// Nested resolutions of name servers given in referrals without glue
+	DEFAULT_MAX_DEPTH = 4
+
+	// Time given to single name server to answer
+	DEFAULT_QUERY_TIMEOUT = 2 * time.Second
+)
+
+type ResolverConfig struct {
+	// Addresses of the root name servers, with optional port
+	RootHints []string
+
+	MaxReferrals  int
+	MaxCNAMEChain int
+	MaxDepth      int
+	QueryTimeout  time.Duration
+}
+
+// Sends the query to the name server at the address
+type ExchangeFunc func(ctx context.Context, addr string, msg *message.Message) (*message.Message, error)
+
+// Iterative resolver, answering queries by following referrals from the root name servers
+type Resolver struct {
+	rootServers   []string
+	maxReferrals  int
+	maxCNAMEChain int
+	maxDepth      int
+	queryTimeout  time.Duration
+	exchange      ExchangeFunc
+
+	// Name servers of zones learned from referrals of previous resolutions
+	delegations *delegationCache
+}
+
+func NewResolver(config ResolverConfig) (*Resolver, error) {
+
+	hints := config.RootHints
+	if len(hints) == 0 {
+		hints = ROOT_HINTS
+	}
+
+	rootServers := make([]string, 0, len(hints))
+	for _, hint := range hints {
+		addr, err := dnsaddress.ServerAddress(hint)
+		if err != nil {
+			return nil, err
+		}
+		rootServers = append(rootServers, addr)
+	}
+
+	resolver := &Resolver{
+		rootServers:   rootServers,
+		maxReferrals:  config.MaxReferrals,
+		maxCNAMEChain: config.MaxCNAMEChain,
+		maxDepth:      config.MaxDepth,
+		queryTimeout:  config.QueryTimeout,
+		exchange:      Exchange,
+		delegations:   newDelegationCache(MAX_CACHED_DELEGATIONS),
+	}
+
+	if resolver.maxReferrals <= 0 {
+		resolver.maxReferrals = DEFAULT_MAX_REFERRALS
+	}
+	if resolver.maxCNAMEChain <= 0 {
+		resolver.maxCNAMEChain = DEFAULT_MAX_CNAME_CHAIN
+	}
+	if resolver.maxDepth <= 0 {
+		resolver.maxDepth = DEFAULT_MAX_DEPTH
+	}
+	if resolver.queryTimeout <= 0 {
+		resolver.queryTimeout = DEFAULT_QUERY_TIMEOUT
+	}
+
+	return resolver, nil
+}
+
+// Replaces the function queries are sent with
+func (r *Resolver) SetExchange(exchange ExchangeFunc) {
+	r.exchange = exchange
+}
+
+// Outcome of resolving single query
+type resolution struct {
+	answers      []message.Answer
+	authority    []message.Answer
+	additional   []message.Answer
+	responseCode message.ResponseCode
+}
+
+// Resolves the query starting from the root name servers. The response carries the answers
+// together with CNAMEs leading to them and additional records sent along with the last of them,
+// or the SOA record of the zone denying the name
+func (r *Resolver) Resolve(ctx context.Context, query message.Query) (*message.Message, error) {
+
+	result, err := r.resolve(ctx, query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &message.Message{
+		Header: message.Header{
+			Flags: message.HeaderFlags{
+				OperationCode:      message.OpCode__Query,
+				RecursionDesired:   true,
+				RecursionAvailable: true,
+				ResponseCode:       result.responseCode,
+			},
+		},
+		Body: message.MessageBody{
+			Queries:     []message.Query{query},
+			Answers:     result.answers,
+			Authorative: result.authority,
+			Additional:  result.additional,
+		},
+	}
+	response.Header.NumberOfQuestions = 1
+	response.UpdateRRNumbers()
+
+	return response, nil
+}
+
+func (r *Resolver) resolve(ctx context.Context, query message.Query, depth int) (*resolution, error) {
+
+	result := &resolution{responseCode: message.ResponseCode__NoError}
+	name := query.Name
+	chainLength := 0
+
+	for {
+		response, zone, err := r.iterate(ctx, message.Query{
+			Name:                name,
+			ResourceRecordType:  query.ResourceRecordType,
+			ResourceRecordClass: query.ResourceRecordClass,
+		}, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		// Aliases within the zone of the answering server are followed in its response
+		for {
+			answers, target := matchAnswers(response.Body.Answers, name, query)
+			result.answers = append(result.answers, answers...)
+
+			if target == nil {
+				result.additional = additionalRecords(response.Body.Additional, zone)
+
+				if len(answers) == 0 {
+					// NXDOMAIN or NODATA of the server is about the target of the last alias followed, so it is passed on with its SOA
+					result.responseCode = response.Header.Flags.ResponseCode
+					result.authority = filterType(response.Body.Authorative, record.ResourceRecordType__SOA)
+				}
+				return result, nil
+			}
+
+			chainLength++
+			if chainLength > r.maxCNAMEChain {
+				return nil, errors.New(fmt.Sprintf("CNAME chain of %s exceeds %d names", joinName(query.Name), r.maxCNAMEChain))
+			}
+
+			name = target
+
+			if !record.IsSubdomain(name, zone) || !hasOwner(response.Body.Answers, name) {
+				break
+			}
+		}
+	}
+}
+
+// Follows referrals from the root name servers, or from the closest zone with remembered name servers,
+// down to the servers answering the query. Returns their response together with the zone they were referred to for
+func (r *Resolver) iterate(ctx context.Context, query message.Query, depth int) (*message.Message, []string, error) {
+
+	servers := r.rootServers
+	zone := []string{}
+
+	cached, fromCache := r.delegations.closest(query.Name)
+	if fromCache {
+		servers = cached.servers
+		zone = cached.zone
+	}
+
+	for referrals := 0; ; referrals++ {
+		response, err := r.queryServers(ctx, servers, query)
+		if err != nil && fromCache && ctx.Err() == nil {
+
+			// Remembered servers may be gone, the zone is looked up again from the root
+			slog.Debug("Remembered name servers failed, starting from the root", "zone", joinName(zone), "err", err)
+			r.delegations.remove(zone)
+
+			servers = r.rootServers
+			zone = []string{}
+			fromCache = false
+
+			response, err = r.queryServers(ctx, servers, query)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		fromCache = false
+
+		flags := response.Header.Flags
+		if flags.AuthorativeAnswer || flags.ResponseCode == message.ResponseCode__NxDomain || len(response.Body.Answers) > 0 {
+			return response, zone, nil
+		}
+
+		cut, nameServers, err := findReferral(response, query.Name, zone)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Neither an answer nor a referral, no data for the name
+		if cut == nil {
+			return response, zone, nil
+		}
+
+		if referrals >= r.maxReferrals {
+			return nil, nil, errors.New(fmt.Sprintf("Resolving %s exceeded %d referrals", joinName(query.Name), r.maxReferrals))
+		}
+
+		slog.Debug("Following referral", "name", joinName(query.Name), "zone", joinName(cut), "servers", len(nameServers))
+
+		servers, err = r.nameServerAddresses(ctx, response, zone, nameServers, depth)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		zone = cut
+		r.delegations.store(zone, servers, referralTtl(response, zone))
+	}
+}
+
+// Sends the query to the servers in random order until one of them answers it
+func (r *Resolver) queryServers(ctx context.Context, servers []string, query message.Query) (*message.Message, error) {
+
+	msg := &message.Message{
+		Header: message.Header{
+			Flags: message.HeaderFlags{
+				Query:         true,
+				OperationCode: message.OpCode__Query,
+			},
+		},
+	}
+	msg.AddQuery(query)
+	msg.SetEDNS(&message.EDNS{UDPPayloadSize: EDNS_UDP_PAYLOAD_SIZE})
+
+	var lastErr error
+
+	for _, i := range rand.Perm(len(servers)) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		queryCtx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+		response, err := r.exchange(queryCtx, servers[i], msg)
+		cancel()
+
+		if err != nil {
+			lastErr = errors.New(fmt.Sprintf("Query to %s failed: %s", servers[i], err))
+			continue
+		}
+
+		code := response.Header.Flags.ResponseCode
+		if code != message.ResponseCode__NoError && code != message.ResponseCode__NxDomain {
+			lastErr = errors.New(fmt.Sprintf("Query to %s failed with response code %d", servers[i], code))
+			continue
+		}
+
+		return response, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("No name servers to query")
+	}
+
+	return nil, errors.New(fmt.Sprintf("No name server answered %s: %s", joinName(query.Name), lastErr))
+}
+
+// Returns the zone the response delegates the name to and its name servers. The zone has to be
+// below the zone of the server which sent the referral, so referrals always lead closer to the name
+func findReferral(response *message.Message, name []string, zone []string) ([]string, [][]string, error) {
+
+	var cut []string
+	nameServers := make([][]string, 0)
+
+	for _, answer := range response.Body.Authorative {
+		if answer.ResourceRecordType != record.ResourceRecordType__NS {
+			continue
+		}
+
+		if !record.IsSubdomain(name, answer.Name) || !record.IsSubdomain(answer.Name, zone) || len(answer.Name) <= len(zone) {
+			return nil, nil, errors.New(fmt.Sprintf("Invalid referral to %s while resolving %s", joinName(answer.Name), joinName(name)))
+		}
+
+		if cut == nil {
+			cut = answer.Name
+		} else if !strings.EqualFold(joinName(cut), joinName(answer.Name)) {
+			continue
+		}
+
+		nameServer, err := parseName(answer.RData)
+		if err != nil {
+			return nil, nil, err
+		}
+		nameServers = append(nameServers, nameServer)
+	}
+
+	return cut, nameServers, nil
+}
+
+// Returns addresses of the name servers, from glue records of the referral or by resolving their names
+func (r *Resolver) nameServerAddresses(ctx context.Context, referral *message.Message, zone []string, nameServers [][]string, depth int) ([]string, error) {
+
+	addresses := make([]string, 0)
+
+	// Glue is trusted only for names the referring server is authoritative for
+	for _, answer := range referral.Body.Additional {
+		if !record.IsSubdomain(answer.Name, zone) || !containsName(nameServers, answer.Name) {
+			continue
+		}
+
+		if addr, ok := answerAddress(answer); ok {
+			addresses = append(addresses, net.JoinHostPort(addr.String(), dnsaddress.DNS_PORT))
+		}
+	}
+
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+
+	if depth >= r.maxDepth {
+		return nil, errors.New(fmt.Sprintf("Resolving name servers exceeded depth of %d", r.maxDepth))
+	}
+
+	// Glueless delegation, names of the servers are resolved like any other name
+	var lastErr error
+	for _, i := range rand.Perm(len(nameServers)) {
+		result, err := r.resolve(ctx, message.Query{
+			Name:                nameServers[i],
+			ResourceRecordType:  record.ResourceRecordType__A,
+			ResourceRecordClass: record.ResourceRecordClass__In,
+		}, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, answer := range result.answers {
+			if addr, ok := answerAddress(answer); ok {
+				addresses = append(addresses, net.JoinHostPort(addr.String(), dnsaddress.DNS_PORT))
+			}
+		}
+
+		if len(addresses) > 0 {
+			return addresses, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("No addresses found")
+	}
+
+	return nil, errors.New(fmt.Sprintf("Failed to resolve name servers: %s", lastErr))
+}
+
+// Returns records of the name answering the query, and the target of the CNAME the name is an alias for
+func matchAnswers(answers []message.Answer, name []string, query message.Query) ([]message.Answer, []string) {
+
+	matched := make([]message.Answer, 0)
+	var target []string
+
+	for _, answer := range answers {
+		if !sameName(answer.Name, name) || answer.ResourceRecordClass != query.ResourceRecordClass {
+			continue
+		}
+
+		if answer.ResourceRecordType == query.ResourceRecordType || query.ResourceRecordType == record.ResourceRecordType__ANY {
+			matched = append(matched, answer)
+			continue
+		}
+
+		if answer.ResourceRecordType == record.ResourceRecordType__CNAME && target == nil {
+			parsed, err := parseName(answer.RData)
+			if err != nil {
+				continue
+			}
+			matched = append(matched, answer)
+			target = parsed
+		}
+	}
+
+	// Records of the requested type answer the query even when an alias was sent along
+	if target != nil {
+		for _, answer := range matched {
+			if answer.ResourceRecordType != record.ResourceRecordType__CNAME {
+				return filterOut(matched, record.ResourceRecordType__CNAME), nil
+			}
+		}
+	}
+
+	return matched, target
+}
+
+// Returns additional records owned by names within the zone of the answering server, which isn't
+// trusted with data of other zones. OPT is left out, as it describes the response of the server
+func additionalRecords(section []message.Answer, zone []string) []message.Answer {
+
+	additional := make([]message.Answer, 0)
+	for _, answer := range section {
+		if answer.ResourceRecordType != record.ResourceRecordType__OPT && record.IsSubdomain(answer.Name, zone) {
+			additional = append(additional, answer)
+		}
+	}
+	return additional
+}
+
+func filterType(section []message.Answer, t record.ResourceRecordType) []message.Answer {
+
+	filtered := make([]message.Answer, 0)
+	for _, answer := range section {
+		if answer.ResourceRecordType == t {
+			filtered = append(filtered, answer)
+		}
+	}
+	return filtered
+}
+
+func filterOut(section []message.Answer, t record.ResourceRecordType) []message.Answer {
+
+	filtered := make([]message.Answer, 0)
+	for _, answer := range section {
+		if answer.ResourceRecordType != t {
+			filtered = append(filtered, answer)
+		}
+	}
+	return filtered
+}
+
+// Returns address carried by A or AAAA record
+func answerAddress(answer message.Answer) (netip.Addr, bool) {
+
+	if answer.ResourceRecordType != record.ResourceRecordType__A && answer.ResourceRecordType != record.ResourceRecordType__AAAA {
+		return netip.Addr{}, false
+	}
+
+	return netip.AddrFromSlice(answer.RData)
+}
+
+func hasOwner(section []message.Answer, name []string) bool {
+	for _, answer := range section {
+		if sameName(answer.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsName(names [][]string, name []string) bool {
+	for _, candidate := range names {
+		if sameName(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodes uncompressed domain name from the start of RDATA
+func parseName(data []byte) ([]string, error) {
+
+	name := make([]string, 0)
+
+	for i := 0; i < len(data); {
+		length := int(data[i])
+		if length == 0 {
+			return name, nil
+		}
+
+		if length > 63 || i+1+length > len(data) {
+			return nil, errors.New("Invalid domain name in RDATA")
+		}
+
+		name = append(name, string(data[i+1:i+1+length]))
+		i += 1 + length
+	}
+
+	return nil, errors.New("Domain name in RDATA is not terminated")
+}
+
+func sameName(a []string, b []string) bool {
+	return len(a) == len(b) && record.IsSubdomain(a, b)
+}
+
+func joinName(name []string) string {
+	if len(name) == 0 {
+		return "."
+	}
+	return strings.Join(name, ".")
+}
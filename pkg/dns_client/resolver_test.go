@@ -0,0 +1,348 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+// Name server answering queries received over UDP with the handler
+type stubServer struct {
+	conn    *net.UDPConn
+	queries atomic.Int32
+}
+
+func startStubServer(t *testing.T, addr string, handle func(query message.Query, response *message.Message)) *stubServer {
+	t.Helper()
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	assert.NoError(t, err)
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	stub := &stubServer{conn: conn}
+
+	go func() {
+		buf := make([]byte, MAX_MESSAGE_SIZE)
+		for {
+			n, remote, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			stub.queries.Add(1)
+
+			var msg message.Message
+			if err := message.NewDecoder(buf[:n]).Decode(&msg); err != nil {
+				continue
+			}
+
+			msg.ResetRRs()
+			msg.SetAsResponse()
+			handle(msg.Body.Queries[0], &msg)
+			msg.UpdateRRNumbers()
+
+			conn.WriteToUDP(message.NewEncoder().Encode(&msg), remote)
+		}
+	}()
+
+	return stub
+}
+
+func name(value string) []string {
+	value = strings.Trim(value, ".")
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ".")
+}
+
+func refer(response *message.Message, zone string, nameServer string, glue net.IP) {
+	response.AddAuthorative(record.NewNSRecord(name(zone), record.ResourceRecordClass__In, name(nameServer)))
+	if glue != nil {
+		response.AddAdditional(record.NewARecord(name(nameServer), record.ResourceRecordClass__In, glue))
+	}
+}
+
+func deny(response *message.Message, zone string, code message.ResponseCode) {
+	response.SetAuthorative(true)
+	response.SetResponseCode(code)
+	response.AddAuthorative(record.NewSOARecord(name(zone), record.ResourceRecordClass__In, name("ns1."+zone), name("admin."+zone), 1, 7200, 3600, 1209600, 300))
+}
+
+// Starts hierarchy of name servers on loopback addresses sharing one port:
+// the root, "com", "example.com" and "org", which is delegated to a name server without glue
+func startTestHierarchy(t *testing.T) (*Resolver, map[string]*stubServer) {
+	t.Helper()
+
+	root := startStubServer(t, "127.0.0.1:0", func(query message.Query, response *message.Message) {
+		switch query.Name[len(query.Name)-1] {
+		case "com":
+			refer(response, "com", "a.gtld.com", net.IPv4(127, 0, 0, 2))
+		case "org":
+			refer(response, "org", "ns.example.com", nil)
+		case "loop":
+			refer(response, "loop", "ns.loop", nil)
+		default:
+			deny(response, "", message.ResponseCode__NxDomain)
+		}
+	})
+
+	port := strconv.Itoa(root.conn.LocalAddr().(*net.UDPAddr).Port)
+
+	servers := map[string]*stubServer{"root": root}
+
+	servers["com"] = startStubServer(t, net.JoinHostPort("127.0.0.2", port), func(query message.Query, response *message.Message) {
+		switch {
+		case record.IsSubdomain(query.Name, name("example.com")):
+			refer(response, "example.com", "ns1.example.com", net.IPv4(127, 0, 0, 3))
+		case record.IsSubdomain(query.Name, name("upward.com")):
+			refer(response, "com", "a.gtld.com", net.IPv4(127, 0, 0, 2))
+		default:
+			deny(response, "com", message.ResponseCode__NxDomain)
+		}
+	})
+
+	servers["example.com"] = startStubServer(t, net.JoinHostPort("127.0.0.3", port), func(query message.Query, response *message.Message) {
+		response.SetAuthorative(true)
+		var in record.ResourceRecordClass = record.ResourceRecordClass__In
+
+		switch strings.Join(query.Name, ".") {
+		case "www.example.com":
+			if query.ResourceRecordType == record.ResourceRecordType__A {
+				response.AddAnswer(record.NewARecord(query.Name, in, net.IPv4(192, 0, 2, 1)))
+			} else {
+				deny(response, "example.com", message.ResponseCode__NoError)
+			}
+		case "alias.example.com":
+			response.AddAnswer(record.NewCNAMERecord(query.Name, in, name("www.example.com")))
+			response.AddAnswer(record.NewARecord(name("www.example.com"), in, net.IPv4(192, 0, 2, 1)))
+		case "external.example.com":
+			response.AddAnswer(record.NewCNAMERecord(query.Name, in, name("www.example.org")))
+		case "loop1.example.com":
+			response.AddAnswer(record.NewCNAMERecord(query.Name, in, name("loop2.example.com")))
+		case "loop2.example.com":
+			response.AddAnswer(record.NewCNAMERecord(query.Name, in, name("loop1.example.com")))
+		case "ns.example.com":
+			response.AddAnswer(record.NewARecord(query.Name, in, net.IPv4(127, 0, 0, 4)))
+		case "example.com":
+			response.AddAnswer(record.NewMXRecord(query.Name, in, 10, name("mail.example.com")))
+			response.AddAdditional(record.NewARecord(name("mail.example.com"), in, net.IPv4(192, 0, 2, 3)))
+			response.AddAdditional(record.NewARecord(name("mail.example.org"), in, net.IPv4(192, 0, 2, 4)))
+			response.SetEDNS(&message.EDNS{UDPPayloadSize: EDNS_UDP_PAYLOAD_SIZE})
+		default:
+			deny(response, "example.com", message.ResponseCode__NxDomain)
+		}
+	})
+
+	servers["org"] = startStubServer(t, net.JoinHostPort("127.0.0.4", port), func(query message.Query, response *message.Message) {
+		response.SetAuthorative(true)
+		if strings.Join(query.Name, ".") == "www.example.org" {
+			response.AddAnswer(record.NewARecord(query.Name, record.ResourceRecordClass__In, net.IPv4(192, 0, 2, 2)))
+			return
+		}
+		deny(response, "org", message.ResponseCode__NxDomain)
+	})
+
+	resolver, err := NewResolver(ResolverConfig{
+		RootHints:    []string{root.conn.LocalAddr().String()},
+		QueryTimeout: 500 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	// Name servers learned from referrals listen on the port of the stubs
+	resolver.SetExchange(func(ctx context.Context, addr string, msg *message.Message) (*message.Message, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		return Exchange(ctx, net.JoinHostPort(host, port), msg)
+	})
+
+	return resolver, servers
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver, _ := startTestHierarchy(t)
+
+	testCases := []struct {
+		name               string
+		query              string
+		queryType          record.ResourceRecordType
+		expectedCode       message.ResponseCode
+		expectedAnswers    []record.ResourceRecordType
+		expectedAdditional []record.ResourceRecordType
+		expectedSOA        bool
+		expectedErr        string
+	}{
+		{
+			name:            "Name should be resolved following referrals with glue",
+			query:           "www.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:            "Alias within the zone of the answering server should be followed in its response",
+			query:           "alias.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME, record.ResourceRecordType__A},
+		},
+		{
+			name:            "Alias into another zone delegated without glue should be resolved",
+			query:           "external.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME, record.ResourceRecordType__A},
+		},
+		{
+			name:               "Additional records within the zone of the answering server should be kept, without OPT",
+			query:              "example.com",
+			queryType:          record.ResourceRecordType__MX,
+			expectedAnswers:    []record.ResourceRecordType{record.ResourceRecordType__MX},
+			expectedAdditional: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:         "Missing name should get NXDOMAIN with SOA",
+			query:        "missing.example.com",
+			queryType:    record.ResourceRecordType__A,
+			expectedCode: message.ResponseCode__NxDomain,
+			expectedSOA:  true,
+		},
+		{
+			name:        "Name without records of the type should get NODATA with SOA",
+			query:       "www.example.com",
+			queryType:   record.ResourceRecordType__TXT,
+			expectedSOA: true,
+		},
+		{
+			name:        "CNAME loop should exceed the chain limit",
+			query:       "loop1.example.com",
+			queryType:   record.ResourceRecordType__A,
+			expectedErr: "CNAME chain",
+		},
+		{
+			name:        "Name server which can be resolved only through itself should exceed the depth limit",
+			query:       "www.loop",
+			queryType:   record.ResourceRecordType__A,
+			expectedErr: "depth",
+		},
+		{
+			name:        "Referral which doesn't lead closer to the name should be rejected",
+			query:       "www.upward.com",
+			queryType:   record.ResourceRecordType__A,
+			expectedErr: "Invalid referral",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			response, err := resolver.Resolve(ctx, message.Query{
+				Name:                name(tc.query),
+				ResourceRecordType:  tc.queryType,
+				ResourceRecordClass: record.ResourceRecordClass__In,
+			})
+
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			assert.True(t, response.Header.Flags.RecursionAvailable)
+
+			types := make([]record.ResourceRecordType, 0)
+			for _, answer := range response.Body.Answers {
+				types = append(types, answer.ResourceRecordType)
+			}
+			assert.Equal(t, tc.expectedAnswers, nilIfEmpty(types))
+
+			additional := make([]record.ResourceRecordType, 0)
+			for _, answer := range response.Body.Additional {
+				additional = append(additional, answer.ResourceRecordType)
+			}
+			assert.Equal(t, tc.expectedAdditional, nilIfEmpty(additional))
+
+			if tc.expectedSOA {
+				assert.Len(t, response.Body.Authorative, 1)
+				assert.Equal(t, record.ResourceRecordType(record.ResourceRecordType__SOA), response.Body.Authorative[0].ResourceRecordType)
+			}
+		})
+	}
+}
+
+func TestResolver_referralLimit(t *testing.T) {
+	resolver, servers := startTestHierarchy(t)
+	resolver.maxReferrals = 1
+
+	_, err := resolver.Resolve(context.Background(), message.Query{
+		Name:                name("www.example.com"),
+		ResourceRecordType:  record.ResourceRecordType__A,
+		ResourceRecordClass: record.ResourceRecordClass__In,
+	})
+
+	assert.ErrorContains(t, err, "referrals")
+	assert.Zero(t, servers["example.com"].queries.Load())
+}
+
+func nilIfEmpty(types []record.ResourceRecordType) []record.ResourceRecordType {
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
+
+func TestResolver_delegationCache(t *testing.T) {
+	resolver, servers := startTestHierarchy(t)
+
+	resolve := func(query string) {
+		_, err := resolver.Resolve(context.Background(), message.Query{
+			Name:                name(query),
+			ResourceRecordType:  record.ResourceRecordType__A,
+			ResourceRecordClass: record.ResourceRecordClass__In,
+		})
+		assert.NoError(t, err)
+	}
+
+	resolve("www.example.com")
+	resolve("alias.example.com")
+
+	// Second name of the zone should be asked straight at its name servers
+	assert.Equal(t, int32(1), servers["root"].queries.Load())
+	assert.Equal(t, int32(1), servers["com"].queries.Load())
+	assert.Equal(t, int32(2), servers["example.com"].queries.Load())
+
+	// Name servers should be forgotten once the TTL of their NS records passes
+	resolver.delegations.now = func() time.Time { return time.Now().Add(2 * MAX_DELEGATION_TTL) }
+	resolve("www.example.com")
+
+	assert.Equal(t, int32(2), servers["root"].queries.Load())
+}
+
+func TestResolver_delegationCache_unreachableServers(t *testing.T) {
+	resolver, servers := startTestHierarchy(t)
+
+	// Remembered name servers which don't answer should be replaced by ones learned from the root
+	resolver.delegations.store(name("example.com"), []string{"127.0.0.9:53"}, time.Hour)
+
+	_, err := resolver.Resolve(context.Background(), message.Query{
+		Name:                name("www.example.com"),
+		ResourceRecordType:  record.ResourceRecordType__A,
+		ResourceRecordClass: record.ResourceRecordClass__In,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), servers["root"].queries.Load())
+
+	cached, ok := resolver.delegations.closest(name("www.example.com"))
+	assert.True(t, ok)
+	assert.NotEqual(t, []string{"127.0.0.9:53"}, cached.servers)
+}
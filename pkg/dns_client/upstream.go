@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	dnsaddress "github.com/XxRoloxX/dns/pkg/dns_address"
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+// Media type of DNS messages sent over HTTPS (RFC 8484 §6)
+const DNS_MESSAGE_CONTENT_TYPE = "application/dns-message"
+
+// Server queries are sent to over one of the transports
+type Upstream interface {
+	Exchange(ctx context.Context, msg *message.Message) (*message.Message, error)
+
+	// Address the upstream was created from
+	String() string
+}
+
+// Creates upstream from its address, see dnsaddress.ParseUpstream for its format.
+// Connections are secured with the TLS config, or with the system roots when it is nil
+func NewUpstream(address string, tlsConfig *tls.Config) (Upstream, error) {
+
+	parsed, err := dnsaddress.ParseUpstream(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = parsed.ServerName
+	}
+
+	switch parsed.Scheme {
+	case dnsaddress.SCHEME_TCP:
+		return &tcpUpstream{address: parsed.Address, addr: parsed.HostPort}, nil
+	case dnsaddress.SCHEME_TLS:
+		return &tlsUpstream{address: parsed.Address, addr: parsed.HostPort, config: config}, nil
+	case dnsaddress.SCHEME_QUIC:
+		return &quicUpstream{address: parsed.Address, client: NewDoQClient(parsed.HostPort, config)}, nil
+	case dnsaddress.SCHEME_HTTPS:
+		return &httpsUpstream{
+			address: parsed.Address,
+			url:     parsed.URL,
+			client:  &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}},
+		}, nil
+	default:
+		return &udpUpstream{address: parsed.Address, addr: parsed.HostPort}, nil
+	}
+}
+
+type udpUpstream struct {
+	address string
+	addr    string
+}
+
+func (u *udpUpstream) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return Exchange(ctx, u.addr, msg)
+}
+
+func (u *udpUpstream) String() string {
+	return u.address
+}
+
+type tcpUpstream struct {
+	address string
+	addr    string
+}
+
+func (u *tcpUpstream) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return ExchangeTCP(ctx, u.addr, msg)
+}
+
+func (u *tcpUpstream) String() string {
+	return u.address
+}
+
+// DNS-over-TLS (RFC 7858), each query sent over its own connection
+type tlsUpstream struct {
+	address string
+	addr    string
+	config  *tls.Config
+}
+
+func (u *tlsUpstream) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+
+	dialer := tls.Dialer{Config: u.config}
+
+	conn, err := dialer.DialContext(ctx, "tcp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return exchangeStream(ctx, conn, msg)
+}
+
+func (u *tlsUpstream) String() string {
+	return u.address
+}
+
+type quicUpstream struct {
+	address string
+	client  *DoQClient
+}
+
+func (u *quicUpstream) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	return u.client.Exchange(ctx, msg)
+}
+
+func (u *quicUpstream) String() string {
+	return u.address
+}
+
+// DNS-over-HTTPS (RFC 8484), queries sent in bodies of POST requests over reused connections
+type httpsUpstream struct {
+	address string
+	url     string
+	client  *http.Client
+}
+
+func (u *httpsUpstream) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+
+	// ID is always 0, so responses can be cached by HTTP caches (RFC 8484 §4.1)
+	query := *msg
+	query.Header.TransactionId = 0
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(message.NewEncoder().Encode(&query)))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", DNS_MESSAGE_CONTENT_TYPE)
+	request.Header.Set("Accept", DNS_MESSAGE_CONTENT_TYPE)
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("DoH server answered with status %d", response.StatusCode))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, MAX_MESSAGE_SIZE))
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decodeResponse(body, &query)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded.Header.TransactionId = msg.Header.TransactionId
+	return decoded, nil
+}
+
+func (u *httpsUpstream) String() string {
+	return u.address
+}
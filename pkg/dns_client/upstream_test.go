@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+func answerTestQuery(query message.Query, response *message.Message) {
+	response.AddAnswer(record.NewARecord(query.Name, record.ResourceRecordClass__In, net.IPv4(192, 0, 2, 1)))
+}
+
+// Decodes the query and encodes its answer
+func answerTestMessage(t *testing.T, data []byte) []byte {
+
+	var msg message.Message
+	assert.NoError(t, message.NewDecoder(data).Decode(&msg))
+
+	msg.ResetRRs()
+	msg.SetAsResponse()
+	answerTestQuery(msg.Body.Queries[0], &msg)
+	msg.UpdateRRNumbers()
+
+	return message.NewEncoder().Encode(&msg)
+}
+
+// Answers length-prefixed queries received over connections accepted by the listener
+func serveStream(t *testing.T, listener net.Listener) {
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				for {
+					var length uint16
+					if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+						return
+					}
+
+					query := make([]byte, length)
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+
+					response := answerTestMessage(t, query)
+					conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(response))))
+					conn.Write(response)
+				}
+			}()
+		}
+	}()
+}
+
+// Starts stub servers of every transport on loopback, the encrypted ones with a certificate trusted by the returned config
+func startTestUpstreams(t *testing.T) (map[string]string, *tls.Config) {
+	t.Helper()
+
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != DNS_MESSAGE_CONTENT_TYPE {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", DNS_MESSAGE_CONTENT_TYPE)
+		w.Write(answerTestMessage(t, query))
+	}))
+	doh.StartTLS()
+	t.Cleanup(doh.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(doh.Certificate())
+
+	udp := startStubServer(t, "127.0.0.1:0", answerTestQuery)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	serveStream(t, tcpListener)
+
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", doh.TLS)
+	assert.NoError(t, err)
+	serveStream(t, tlsListener)
+
+	return map[string]string{
+		"udp":   udp.conn.LocalAddr().String(),
+		"tcp":   "tcp://" + tcpListener.Addr().String(),
+		"tls":   "tls://" + tlsListener.Addr().String() + "#example.com",
+		"https": doh.URL + "/dns-query",
+	}, &tls.Config{RootCAs: roots}
+}
+
+func TestNewUpstream(t *testing.T) {
+	testCases := []struct {
+		name        string
+		address     string
+		expected    Upstream
+		expectedErr bool
+	}{
+		{
+			name:     "Address without scheme should use UDP on the DNS port",
+			address:  "192.0.2.1",
+			expected: &udpUpstream{address: "udp://192.0.2.1", addr: "192.0.2.1:53"},
+		},
+		{
+			name:     "TCP upstream should keep its port",
+			address:  "tcp://[2001:db8::1]:5353",
+			expected: &tcpUpstream{address: "tcp://[2001:db8::1]:5353", addr: "[2001:db8::1]:5353"},
+		},
+		{
+			name:     "TLS upstream should default to port 853 and verify the name after #",
+			address:  "tls://192.0.2.1#dns.example.com",
+			expected: &tlsUpstream{address: "tls://192.0.2.1#dns.example.com", addr: "192.0.2.1:853", config: &tls.Config{ServerName: "dns.example.com"}},
+		},
+		{
+			name:        "Unknown scheme should be rejected",
+			address:     "ftp://192.0.2.1",
+			expectedErr: true,
+		},
+		{
+			name:        "Address without host should be rejected",
+			address:     "https://",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, err := NewUpstream(tc.address, nil)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, upstream)
+		})
+	}
+}
+
+func TestUpstream_Exchange(t *testing.T) {
+	addresses, tlsConfig := startTestUpstreams(t)
+
+	for transport, address := range addresses {
+		t.Run(transport, func(t *testing.T) {
+			upstream, err := NewUpstream(address, tlsConfig)
+			assert.NoError(t, err)
+
+			query := &message.Message{Header: message.Header{TransactionId: 4321}}
+			query.Header.Flags.RecursionDesired = true
+			query.AddQuery(message.Query{
+				Name:                name("www.example.com"),
+				ResourceRecordType:  record.ResourceRecordType__A,
+				ResourceRecordClass: record.ResourceRecordClass__In,
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			response, err := upstream.Exchange(ctx, query)
+			assert.NoError(t, err)
+			assert.Equal(t, uint16(4321), response.Header.TransactionId)
+			assert.Len(t, response.Body.Answers, 1)
+		})
+	}
+}
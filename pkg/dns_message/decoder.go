@@ -7,6 +7,9 @@ import (
 	record "github.com/XxRoloxX/dns/pkg/dns_record"
 )
 
+// Maximum number of compression pointers followed while decoding single name
+const MAX_NAME_POINTERS = 64
+
 type Decoder struct {
 	buf []byte
 }
@@ -48,6 +51,10 @@ func (d *Decoder) decodeBody(header *Header) (*MessageBody, error) {
 	additonal := make([]Answer, 0)
 
 	for _ = range header.NumberOfQuestions {
+		if int(index) > len(d.buf) {
+			return nil, errors.New("Failed to decode query, message is truncated")
+		}
+
 		query, read, err := d.decodeQuery(d.buf[index:])
 		if err != nil {
 			return nil, err
@@ -102,28 +109,89 @@ func (d *Decoder) decodeAnswer(index uint16) (*Answer, uint16, error) {
 		return nil, 0, err
 	}
 
-	t, err := record.NewResourceRecordType(binary.BigEndian.Uint16(d.buf[index : index+2]))
-	class, err := record.NewResourceRecordClass(binary.BigEndian.Uint16(d.buf[index+2 : index+4]))
+	if !d.isIndexValid(index + 9) {
+		return nil, 0, errors.New("failed to decode answer section")
+	}
+
+	// Types unknown to us are kept as they are, so the record can still be passed on (RFC 3597 §2)
+	t := record.ResourceRecordType(binary.BigEndian.Uint16(d.buf[index : index+2]))
+
+	// So are classes, including the class of the OPT pseudo-record carrying requestor's UDP payload size (RFC 6891 §6.1.2)
+	class := record.ResourceRecordClass(binary.BigEndian.Uint16(d.buf[index+2 : index+4]))
 	ttl := binary.BigEndian.Uint32(d.buf[index+4 : index+8])
 	rDataLength := binary.BigEndian.Uint16(d.buf[index+8 : index+10])
 
-	if !d.isIndexValid(index + 10 + rDataLength - 1) {
+	if rDataLength > 0 && !d.isIndexValid(index+10+rDataLength-1) {
 		return nil, 0, errors.New("failed to decode answer section")
 	}
 
-	rData := d.buf[index+10 : index+10+rDataLength]
+	rData, err := d.decompressRData(t, index+10, rDataLength)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	return &Answer{
 		Name:                name,
 		ResourceRecordType:  t,
 		ResourceRecordClass: class,
 		Ttl:                 ttl,
-		RDataLength:         rDataLength,
+		RDataLength:         uint16(len(rData)),
 		RData:               rData,
 	}, index + 10 + rDataLength, nil
 
 }
 
+// Replaces compressed names in RDATA of well-known types with full ones, so the record
+// can be copied into other messages (RFC 3597 §4). RDATA of other types is returned as it is
+func (d *Decoder) decompressRData(t record.ResourceRecordType, index uint16, length uint16) ([]byte, error) {
+
+	end := index + length
+	raw := d.buf[index:end]
+
+	// Number of fixed size bytes preceding and following names of the type
+	var prefix, suffix uint16
+	names := 1
+
+	switch t {
+	case record.ResourceRecordType__NS, record.ResourceRecordType__CNAME, record.ResourceRecordType__PTR:
+	case record.ResourceRecordType__MX:
+		prefix = 2
+	case record.ResourceRecordType__SRV:
+		prefix = 6
+	case record.ResourceRecordType__SOA:
+		names = 2
+		suffix = 20
+	default:
+		return raw, nil
+	}
+
+	if length < prefix+suffix+1 {
+		return nil, errors.New(fmt.Sprintf("Invalid RDATA length of type %d: %d", t, length))
+	}
+
+	decompressed := append(make([]byte, 0, length), d.buf[index:index+prefix]...)
+	index += prefix
+
+	for range names {
+		name, next, err := d.decodeNameWithPointers(index)
+		if err != nil {
+			return nil, err
+		}
+		if next > end {
+			return nil, errors.New(fmt.Sprintf("Name exceeds RDATA of type %d", t))
+		}
+
+		decompressed = append(decompressed, (&Encoder{}).encodeName(name)...)
+		index = next
+	}
+
+	if end-index != suffix {
+		return nil, errors.New(fmt.Sprintf("Invalid RDATA length of type %d: %d", t, length))
+	}
+
+	return append(decompressed, d.buf[index:end]...), nil
+}
+
 func (d *Decoder) decodeNameWithPointers(index uint16) ([]string, uint16, error) {
 
 	if !d.isIndexValid(index) {
@@ -133,9 +201,14 @@ func (d *Decoder) decodeNameWithPointers(index uint16) ([]string, uint16, error)
 	groups := make([]string, 0)
 	nameEndsAt := index
 	wasPointerUsed := false
+	pointers := 0
 
 	for {
 
+		if !d.isIndexValid(index) {
+			return nil, 0, errors.New(fmt.Sprintf("Invalid index: %d", index))
+		}
+
 		initialByte := uint8(d.buf[index])
 
 		isTerminated := d.isNameTerminated(initialByte)
@@ -153,11 +226,22 @@ func (d *Decoder) decodeNameWithPointers(index uint16) ([]string, uint16, error)
 
 		isPointer := d.isPoinerToDomain(initialByte)
 		if isPointer {
+			if !d.isIndexValid(index + 1) {
+				return nil, 0, errors.New(fmt.Sprintf("Invalid pointer at index: %d", index))
+			}
+
+			// Pointers forming a loop would never reach the end of the name
+			pointers++
+			if pointers > MAX_NAME_POINTERS {
+				return nil, 0, errors.New("Too many pointers in name")
+			}
+
 			pointer := d.pointerFrom(index)
 
-			// Assign current index to pointer
+			// Assign current index to pointer, which may lead to another pointer
 			index = pointer
 			wasPointerUsed = true
+			continue
 		}
 
 		groupLength := uint8(d.buf[index])
@@ -207,6 +291,10 @@ func (d *Decoder) decodeQuery(buf []byte) (*Query, uint16, error) {
 	groups := make([]string, 0)
 
 	for {
+		if int(index) >= len(buf) {
+			return nil, index, errors.New("Failed to decode query, name is not terminated")
+		}
+
 		groupLength := uint8(buf[index])
 
 		if groupLength == 0 {
@@ -215,8 +303,8 @@ func (d *Decoder) decodeQuery(buf []byte) (*Query, uint16, error) {
 			break
 		}
 
-		if groupLength >= uint8(len(buf)) {
-			return nil, index, errors.New(fmt.Sprintf("Invalid group length: Expected %d, got %d", buf, groupLength))
+		if int(index)+1+int(groupLength) > len(buf) {
+			return nil, index, errors.New(fmt.Sprintf("Invalid group length: Expected < %d, got %d", len(buf)-int(index), groupLength))
 		}
 
 		// Get bytes as group after the group length byte
@@ -226,12 +314,14 @@ func (d *Decoder) decodeQuery(buf []byte) (*Query, uint16, error) {
 		index += uint16(groupLength) + 1
 	}
 
-	t, err := record.NewResourceRecordType(binary.BigEndian.Uint16(buf[index : index+2]))
-
-	if err != nil {
-		return nil, index, err
+	// Type and class follow the name
+	if int(index)+4 > len(buf) {
+		return nil, index, errors.New("Failed to decode query, type and class are missing")
 	}
 
+	// Queries for types unknown to us are still answered, with NODATA or by the upstreams (RFC 3597 §2)
+	t := record.ResourceRecordType(binary.BigEndian.Uint16(buf[index : index+2]))
+
 	class, err := record.NewResourceRecordClass(binary.BigEndian.Uint16(buf[index+2 : index+4]))
 	if err != nil {
 		return nil, index, err
@@ -279,7 +369,7 @@ func (d *Decoder) decodeHeaderFlags(buf []byte) (*HeaderFlags, error) {
 
 	query := buf[0]&128 == 0 // 10000000<- first bit (if zero then it is a query)
 
-	opcode, err := NewOperationCode(uint16(buf[0] & 120)) // 01111000 (4 bites)
+	opcode, err := NewOperationCode(uint16(buf[0]&120) >> 3) // 01111000 (4 bites)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package message
 
 import (
+	"errors"
 	"net"
 	"testing"
 
@@ -101,6 +102,69 @@ func TestDecoder_decodeBody(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "A message with a compressed name in RDATA should be decoded with the full name",
+			rawQuery: []byte{
+				//Header bytes
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+
+				// Question section:
+				0b00000011, 0b01110111, 0b01110111, 0b01110111, // "www"
+				0b00000111, 0b01100101, 0b01111000, 0b01100001, 0b01101101, 0b01110000, 0b01101100, 0b01100101, // "example"
+				0b00000011, 0b01100011, 0b01101111, 0b01101101, // "com"
+				0b00000000,             // Terminating null byte
+				0b00000000, 0b00000101, // Query Type: CNAME
+				0b00000000, 0b00000001, // Query Class: IN
+
+				// Answer section:
+				0b11000000, 0b00001100, // Name (Pointer to "www.example.com" in the question section)
+				0b00000000, 0b00000101, // Type: CNAME
+				0b00000000, 0b00000001, // Class: IN
+				0x00, 0x00, 0x00, 0x3C, // Time to Live: 60 seconds
+				0x00, 0x06, // RDATA Length: 6 bytes
+				0b00000011, 0b01110111, 0b01100101, 0b01100010, // "web"
+				0b11000000, 0b00010000, // Pointer to "example.com" in the question section
+			},
+			expectedQueries: []Query{
+				{
+					Name:                []string{"www", "example", "com"},
+					ResourceRecordType:  record.ResourceRecordType__CNAME,
+					ResourceRecordClass: record.ResourceRecordClass__In,
+				},
+			},
+			expectedAnswers: []Answer{
+				{
+					Name:                []string{"www", "example", "com"},
+					ResourceRecordType:  record.ResourceRecordType__CNAME,
+					ResourceRecordClass: record.ResourceRecordClass__In,
+					Ttl:                 60,
+					RDataLength:         17,
+					RData:               record.NewCNAMERecord(nil, record.ResourceRecordClass__In, []string{"web", "example", "com"}).Data(),
+				},
+			},
+		},
+		{
+			name: "A message with a loop of pointers should be rejected",
+			rawQuery: []byte{
+				//Header bytes
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+
+				// Question section:
+				0b00000011, 0b01100011, 0b01101111, 0b01101101, // "com"
+				0b00000000,             // Terminating null byte
+				0b00000000, 0b00000001, // Query Type: A
+				0b00000000, 0b00000001, // Query Class: IN
+
+				// Answer section:
+				0b00000001, 0b01100001, // "a"
+				0b11000000, 0b00010101, // Pointer to itself
+			},
+			expectedErr: errors.New("Too many pointers in name"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -111,7 +175,7 @@ func TestDecoder_decodeBody(t *testing.T) {
 			}
 
 			body, err := NewDecoder(tc.rawQuery).decodeBody(&header)
-			if err != nil && tc.expectedErr != nil {
+			if tc.expectedErr != nil {
 				assert.Equal(t, tc.expectedErr, err)
 				return
 			}
@@ -124,6 +188,75 @@ func TestDecoder_decodeBody(t *testing.T) {
 	}
 }
 
+func TestDecoder_Decode_truncatedQuestion(t *testing.T) {
+
+	// Header with ID 1, RD set and one question
+	header := []byte{0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	testCases := []struct {
+		name     string
+		question []byte
+	}{
+		{
+			name:     "Question without type and class should not be decoded",
+			question: []byte{0x03, 'c', 'o', 'm', 0x00},
+		},
+		{
+			name:     "Question with type but without class should not be decoded",
+			question: []byte{0x03, 'c', 'o', 'm', 0x00, 0x00, 0x01},
+		},
+		{
+			name:     "Question with name which is not terminated should not be decoded",
+			question: []byte{0x03, 'c', 'o', 'm'},
+		},
+		{
+			name:     "Question with label longer than the message should not be decoded",
+			question: []byte{0x07, 'c', 'o', 'm'},
+		},
+		{
+			name:     "Missing question should not be decoded",
+			question: []byte{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var msg Message
+			assert.Error(t, NewDecoder(append(append([]byte{}, header...), tc.question...)).Decode(&msg))
+		})
+	}
+}
+
+func TestDecoder_Decode_unknownTypes(t *testing.T) {
+
+	raw := []byte{
+		// Header with one question and one answer
+		0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+
+		// Question section:
+		0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x41, // Query Type: HTTPS
+		0x00, 0x01, // Query Class: IN
+
+		// Answer section:
+		0xC0, 0x0C, // Name (Pointer to "com")
+		0x00, 0x41, // Type: HTTPS
+		0x00, 0x0A, // Class: unassigned
+		0x00, 0x00, 0x00, 0x3C, // Time to Live: 60 seconds
+		0x00, 0x01, // RDATA Length: 1 byte
+		0xFF,
+	}
+
+	var msg Message
+	assert.NoError(t, NewDecoder(raw).Decode(&msg))
+
+	// Unknown type and class are kept as they are (RFC 3597 §2)
+	assert.Equal(t, record.ResourceRecordType(65), msg.Body.Queries[0].ResourceRecordType)
+	assert.Equal(t, record.ResourceRecordType(65), msg.Body.Answers[0].ResourceRecordType)
+	assert.Equal(t, record.ResourceRecordClass(10), msg.Body.Answers[0].ResourceRecordClass)
+	assert.Equal(t, []byte{0xFF}, msg.Body.Answers[0].RData)
+}
+
 func TestDecoder_decodeHeader(t *testing.T) {
 
 	testCases := []struct {
@@ -159,6 +292,25 @@ func TestDecoder_decodeHeader(t *testing.T) {
 				NumberOfAdditionalRR: 0,
 			},
 		},
+		{
+			name: "Operation code other than query should be decoded",
+			rawQuery: []byte{
+				0b00000000, 0b00000001, // Transaction ID
+				0b00101000, 0b00000000, // Flags (opcode UPDATE)
+				0b00000000, 0b00000001, // Questions
+				0b00000000, 0b00000000, // Answer RRs
+				0b00000000, 0b00000000, // Authority RRs
+				0b00000000, 0b00000000, // Additional RRs
+			},
+			expectedHeader: Header{
+				TransactionId: 1,
+				Flags: HeaderFlags{
+					Query:         true,
+					OperationCode: OpCode__Update,
+				},
+				NumberOfQuestions: 1,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -3,6 +3,7 @@ package message
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	// message "github.com/XxRoloxX/dns/pkg/dns_message"
 	"github.com/XxRoloxX/dns/pkg/dns_record"
@@ -14,6 +15,8 @@ const (
 	OpCode__Query  OpCode = 0
 	OpCode__IQuery OpCode = 1
 	OpCode__Status OpCode = 2
+	OpCode__Notify OpCode = 4 // RFC 1996
+	OpCode__Update OpCode = 5 // RFC 2136
 )
 
 func NewOperationCode(code uint16) (OpCode, error) {
@@ -24,11 +27,35 @@ func NewOperationCode(code uint16) (OpCode, error) {
 		return OpCode__IQuery, nil
 	case 2:
 		return OpCode__Status, nil
+	case 4:
+		return OpCode__Notify, nil
+	case 5:
+		return OpCode__Update, nil
 	default:
 		return 0, errors.New(fmt.Sprintf("Invalid operation code: %d", code))
 	}
 }
 
+var operationCodeNames = map[OpCode]string{
+	OpCode__Query:  "query",
+	OpCode__IQuery: "iquery",
+	OpCode__Status: "status",
+	OpCode__Notify: "notify",
+	OpCode__Update: "update",
+}
+
+// Parses operation code given by its name, e.g. "update"
+func ParseOperationCode(value string) (OpCode, error) {
+
+	for code, name := range operationCodeNames {
+		if strings.EqualFold(name, value) {
+			return code, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Invalid operation code: %s", value))
+}
+
 type ResponseCode uint
 
 const (
@@ -36,6 +63,8 @@ const (
 	ResponseCode__FormErr  = 1
 	ResponseCode__ServFail = 2
 	ResponseCode__NxDomain = 3
+	ResponseCode__NotImp   = 4
+	ResponseCode__Refused  = 5
 )
 
 func NewResponseCode(code uint16) (ResponseCode, error) {
@@ -48,6 +77,10 @@ func NewResponseCode(code uint16) (ResponseCode, error) {
 		return ResponseCode__ServFail, nil
 	case 3:
 		return ResponseCode__NxDomain, nil
+	case 4:
+		return ResponseCode__NotImp, nil
+	case 5:
+		return ResponseCode__Refused, nil
 	default:
 		return 0, errors.New("Invalid response code")
 	}
@@ -107,6 +140,19 @@ func (m *Message) SetResponseCode(code ResponseCode) {
 	m.Header.Flags.ResponseCode = code
 }
 
+// Marks response as coming from a server authoritative for the queried zone
+func (m *Message) SetAuthorative(authorative bool) {
+	m.Header.Flags.AuthorativeAnswer = authorative
+}
+
+// Removes records from answer, authority and additional sections, leaving only queries
+func (m *Message) ResetRRs() {
+	m.Body.Answers = make([]Answer, 0)
+	m.Body.Authorative = make([]Answer, 0)
+	m.Body.Additional = make([]Answer, 0)
+	m.UpdateRRNumbers()
+}
+
 // Update headers with numbers of Answers, Authorative and Additional RRs
 func (m *Message) UpdateRRNumbers() {
 	m.Header.NumberOfAnswers = uint16(len(m.Body.Answers))
@@ -114,16 +160,54 @@ func (m *Message) UpdateRRNumbers() {
 	m.Header.NumberOfAdditionalRR = uint16(len(m.Body.Additional))
 }
 
-func (m *Message) AddAnswer(rr record.ResourceRecord) {
-
-	m.Body.Answers = append(m.Body.Answers, Answer{
+func newAnswer(rr record.ResourceRecord, ttl uint32) Answer {
+	return Answer{
 		Name:                rr.Name(),
 		ResourceRecordType:  rr.Type(),
 		ResourceRecordClass: rr.Class(),
-		Ttl:                 1080,
+		Ttl:                 ttl,
 		RDataLength:         uint16(len(rr.Data())),
 		RData:               rr.Data(),
-	})
+	}
+}
+
+// Appends records of the RRset to the section, all sharing TTL of the set
+func appendRRSet(section []Answer, set *record.RRSet) []Answer {
+	for _, rr := range set.Records() {
+		section = append(section, newAnswer(rr, set.Ttl()))
+	}
+
+	return section
+}
+
+// Groups records of the section into RRsets, removing duplicated RDATA
+// and unifying TTLs within each set
+func groupIntoRRSets(section []Answer) []Answer {
+
+	builder := record.NewRRSetBuilder()
+	for _, answer := range section {
+		builder.Add(record.NewRawRecord(
+			answer.Name,
+			answer.ResourceRecordType,
+			answer.ResourceRecordClass,
+			answer.RData,
+		), answer.Ttl)
+	}
+
+	grouped := make([]Answer, 0, len(section))
+	for _, set := range builder.Build() {
+		grouped = appendRRSet(grouped, set)
+	}
+
+	return grouped
+}
+
+func (m *Message) AddAnswer(rr record.ResourceRecord) {
+	m.Body.Answers = append(m.Body.Answers, newAnswer(rr, record.DEFAULT_TTL))
+}
+
+func (m *Message) AddAnswerRRSet(set *record.RRSet) {
+	m.Body.Answers = appendRRSet(m.Body.Answers, set)
 }
 
 func (m *Message) AddQuery(q Query) {
@@ -132,25 +216,17 @@ func (m *Message) AddQuery(q Query) {
 }
 
 func (m *Message) AddAuthorative(rr record.ResourceRecord) {
+	m.Body.Authorative = append(m.Body.Authorative, newAnswer(rr, record.DEFAULT_TTL))
+}
 
-	m.Body.Authorative = append(m.Body.Answers, Answer{
-		Name:                rr.Name(),
-		ResourceRecordType:  rr.Type(),
-		ResourceRecordClass: rr.Class(),
-		Ttl:                 1080,
-		RDataLength:         uint16(len(rr.Data())),
-		RData:               rr.Data(),
-	})
+func (m *Message) AddAuthorativeRRSet(set *record.RRSet) {
+	m.Body.Authorative = appendRRSet(m.Body.Authorative, set)
 }
 
 func (m *Message) AddAdditional(rr record.ResourceRecord) {
+	m.Body.Additional = append(m.Body.Additional, newAnswer(rr, record.DEFAULT_TTL))
+}
 
-	m.Body.Additional = append(m.Body.Answers, Answer{
-		Name:                rr.Name(),
-		ResourceRecordType:  rr.Type(),
-		ResourceRecordClass: rr.Class(),
-		Ttl:                 1080,
-		RDataLength:         uint16(len(rr.Data())),
-		RData:               rr.Data(),
-	})
+func (m *Message) AddAdditionalRRSet(set *record.RRSet) {
+	m.Body.Additional = appendRRSet(m.Body.Additional, set)
 }
@@ -0,0 +1,171 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+// EDNS option codes (RFC 6891 §6.1.2)
+const (
+	EDNSOption__ClientSubnet = 8  // RFC 7871
+	EDNSOption__TCPKeepalive = 11 // RFC 7828
+	EDNSOption__Padding      = 12 // RFC 7830
+)
+
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// EDNS(0) parameters carried by the OPT pseudo-record in the additional section (RFC 6891 §6.1)
+type EDNS struct {
+	UDPPayloadSize uint16
+	ExtendedRcode  uint8
+	Version        uint8
+	DNSSECOk       bool
+	Options        []EDNSOption
+}
+
+// Returns the option with given code, if present
+func (e *EDNS) Option(code uint16) (*EDNSOption, bool) {
+	for i := range e.Options {
+		if e.Options[i].Code == code {
+			return &e.Options[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (e *EDNS) AddOption(code uint16, data []byte) {
+	e.Options = append(e.Options, EDNSOption{Code: code, Data: data})
+}
+
+func (e *EDNS) encodeOptions() []byte {
+
+	encoded := make([]byte, 0)
+	for _, option := range e.Options {
+		encoded = binary.BigEndian.AppendUint16(encoded, option.Code)
+		encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(option.Data)))
+		encoded = append(encoded, option.Data...)
+	}
+
+	return encoded
+}
+
+func (e *EDNS) toAnswer() Answer {
+
+	ttl := uint32(e.ExtendedRcode)<<24 | uint32(e.Version)<<16
+	if e.DNSSECOk {
+		ttl |= 1 << 15
+	}
+
+	options := e.encodeOptions()
+
+	return Answer{
+		Name:                []string{},
+		ResourceRecordType:  record.ResourceRecordType__OPT,
+		ResourceRecordClass: record.ResourceRecordClass(e.UDPPayloadSize),
+		Ttl:                 ttl,
+		RDataLength:         uint16(len(options)),
+		RData:               options,
+	}
+}
+
+func decodeEDNS(answer Answer) (*EDNS, error) {
+
+	edns := &EDNS{
+		UDPPayloadSize: uint16(answer.ResourceRecordClass),
+		ExtendedRcode:  uint8(answer.Ttl >> 24),
+		Version:        uint8(answer.Ttl >> 16),
+		DNSSECOk:       answer.Ttl&(1<<15) > 0,
+		Options:        make([]EDNSOption, 0),
+	}
+
+	data := answer.RData
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("Failed to decode EDNS option, invalid length")
+		}
+
+		code := binary.BigEndian.Uint16(data[0:2])
+		length := binary.BigEndian.Uint16(data[2:4])
+
+		if len(data) < 4+int(length) {
+			return nil, errors.New(fmt.Sprintf("Failed to decode EDNS option %d, invalid length %d", code, length))
+		}
+
+		edns.Options = append(edns.Options, EDNSOption{Code: code, Data: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+
+	return edns, nil
+}
+
+func isOPT(answer Answer) bool {
+	return answer.ResourceRecordType == record.ResourceRecordType__OPT
+}
+
+// Returns EDNS parameters of the message, or nil when the message carries no OPT record
+func (m *Message) EDNS() (*EDNS, error) {
+
+	var edns *EDNS
+
+	for _, answer := range m.Body.Additional {
+		if !isOPT(answer) {
+			continue
+		}
+
+		// Message with more than one OPT record is malformed (RFC 6891 §6.1.1)
+		if edns != nil {
+			return nil, errors.New("Message contains more than one OPT record")
+		}
+
+		decoded, err := decodeEDNS(answer)
+		if err != nil {
+			return nil, err
+		}
+
+		edns = decoded
+	}
+
+	return edns, nil
+}
+
+// Replaces OPT record of the message with one carrying given parameters
+func (m *Message) SetEDNS(edns *EDNS) {
+
+	additional := make([]Answer, 0, len(m.Body.Additional)+1)
+	for _, answer := range m.Body.Additional {
+		if !isOPT(answer) {
+			additional = append(additional, answer)
+		}
+	}
+
+	if edns != nil {
+		additional = append(additional, edns.toAnswer())
+	}
+
+	m.Body.Additional = additional
+	m.UpdateRRNumbers()
+}
+
+// Splits the OPT record off the additional section
+func splitOPT(section []Answer) ([]Answer, []Answer) {
+
+	records := make([]Answer, 0, len(section))
+	opt := make([]Answer, 0, 1)
+
+	for _, answer := range section {
+		if isOPT(answer) {
+			opt = append(opt, answer)
+		} else {
+			records = append(records, answer)
+		}
+	}
+
+	return records, opt
+}
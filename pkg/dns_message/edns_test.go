@@ -0,0 +1,86 @@
+package message
+
+import (
+	"net"
+	"testing"
+
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_EDNS(t *testing.T) {
+	testCases := []struct {
+		name         string
+		edns         *EDNS
+		expectedEDNS *EDNS
+	}{
+		{
+			name:         "Message without OPT record should have no EDNS",
+			edns:         nil,
+			expectedEDNS: nil,
+		},
+		{
+			name: "EDNS parameters should survive encoding",
+			edns: &EDNS{
+				UDPPayloadSize: 1232,
+				DNSSECOk:       true,
+				Options:        []EDNSOption{{Code: EDNSOption__TCPKeepalive, Data: []byte{0x00, 0x64}}},
+			},
+			expectedEDNS: &EDNS{
+				UDPPayloadSize: 1232,
+				DNSSECOk:       true,
+				Options:        []EDNSOption{{Code: EDNSOption__TCPKeepalive, Data: []byte{0x00, 0x64}}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := Message{}
+			msg.AddQuery(Query{
+				Name:                []string{"example", "com"},
+				ResourceRecordType:  record.ResourceRecordType__A,
+				ResourceRecordClass: record.ResourceRecordClass__In,
+			})
+			msg.SetEDNS(tc.edns)
+
+			var decoded Message
+			assert.NoError(t, NewDecoder(NewEncoder().Encode(&msg)).Decode(&decoded))
+
+			edns, err := decoded.EDNS()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedEDNS, edns)
+		})
+	}
+}
+
+func TestMessage_EDNS_malformedOption(t *testing.T) {
+	msg := Message{}
+	msg.Body.Additional = []Answer{{
+		Name:                []string{},
+		ResourceRecordType:  record.ResourceRecordType__OPT,
+		ResourceRecordClass: 512,
+		RDataLength:         3,
+		RData:               []byte{0x00, 0x0b, 0x00},
+	}}
+
+	_, err := msg.EDNS()
+	assert.Error(t, err)
+}
+
+func TestEncoder_EncodeWithinLimit_keepsOPT(t *testing.T) {
+	msg := Message{}
+	for i := range 40 {
+		msg.AddAnswer(record.NewARecord([]string{"example", "com"}, record.ResourceRecordClass__In, net.IPv4(192, 168, 1, byte(i))))
+	}
+	msg.SetEDNS(&EDNS{UDPPayloadSize: 512})
+
+	var decoded Message
+	assert.NoError(t, NewDecoder(NewEncoder().EncodeWithinLimit(&msg, 512)).Decode(&decoded))
+
+	// Truncated response should still carry the OPT record
+	assert.True(t, decoded.Header.Flags.Truncation)
+	edns, err := decoded.EDNS()
+	assert.NoError(t, err)
+	assert.NotNil(t, edns)
+}
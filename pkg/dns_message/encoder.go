@@ -3,6 +3,7 @@ package message
 import (
 	"encoding/binary"
 	bin "github.com/XxRoloxX/dns/pkg/binary_utils"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
 )
 
 func test() {
@@ -21,20 +22,86 @@ func NewEncoder() *Encoder {
 
 func (e *Encoder) Encode(message *Message) []byte {
 
-	encodedHeader := e.encodeHeader(&message.Header)
+	answers := groupIntoRRSets(message.Body.Answers)
+	authorative := groupIntoRRSets(message.Body.Authorative)
+	additional := groupIntoRRSets(message.Body.Additional)
+
+	// Grouping may drop duplicated records, so the header has to follow
+	header := message.Header
+	header.NumberOfQuestions = uint16(len(message.Body.Queries))
+	header.NumberOfAnswers = uint16(len(answers))
+	header.NumberOfAuthorityRR = uint16(len(authorative))
+	header.NumberOfAdditionalRR = uint16(len(additional))
+
+	encodedHeader := e.encodeHeader(&header)
 	e.buffer = append(e.buffer, encodedHeader...)
 
 	for _, query := range message.Body.Queries {
 		e.buffer = append(e.buffer, e.encodeQuery(query)...)
 	}
 
-	for _, answer := range message.Body.Answers {
-		e.buffer = append(e.buffer, e.encodeAnswer(answer)...)
+	for _, section := range [][]Answer{answers, authorative, additional} {
+		for _, answer := range section {
+			e.buffer = append(e.buffer, e.encodeAnswer(answer)...)
+		}
 	}
 
 	return e.buffer
 }
 
+// Encodes the message so that it fits within the size limit. Additional records are optional,
+// so they are dropped first. When the message still doesn't fit, answer and authority sections
+// are emptied and TC flag is set, making the client retry over a transport without the limit.
+// OPT record is never dropped, as it describes the response itself (RFC 6891 §7)
+func (e *Encoder) EncodeWithinLimit(message *Message, limit int) []byte {
+
+	limited := *message
+	additional, opt := splitOPT(groupIntoRRSets(message.Body.Additional))
+
+	for {
+		limited.Body.Additional = append(append(make([]Answer, 0, len(additional)+len(opt)), additional...), opt...)
+
+		e.buffer = make([]byte, 0)
+		encoded := e.Encode(&limited)
+
+		if len(encoded) <= limit {
+			return encoded
+		}
+
+		if len(additional) == 0 {
+			break
+		}
+
+		additional = dropLastRRSet(additional)
+	}
+
+	limited.Header.Flags.Truncation = true
+	limited.Body.Answers = nil
+	limited.Body.Authorative = nil
+	limited.Body.Additional = opt
+
+	e.buffer = make([]byte, 0)
+	return e.Encode(&limited)
+}
+
+// Removes trailing RRset from the grouped section, so that no RRset is sent partially (RFC 2181 §9)
+func dropLastRRSet(section []Answer) []Answer {
+
+	last := section[len(section)-1]
+	lastKey := record.NewRRSetKey(last.Name, last.ResourceRecordType, last.ResourceRecordClass)
+
+	end := len(section) - 1
+	for end > 0 {
+		previous := section[end-1]
+		if record.NewRRSetKey(previous.Name, previous.ResourceRecordType, previous.ResourceRecordClass) != lastKey {
+			break
+		}
+		end--
+	}
+
+	return section[:end]
+}
+
 func (e *Encoder) encodeHeaderFlags(headerFlags *HeaderFlags) []byte {
 
 	flags := make([]byte, 2)
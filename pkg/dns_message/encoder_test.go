@@ -127,3 +127,124 @@ func TestEncoder_encodeBody(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoder_Encode_groupsAnswersIntoRRSets(t *testing.T) {
+	msg := Message{
+		Body: MessageBody{
+			Answers: []Answer{
+				{
+					Name:                []string{"example", "com"},
+					ResourceRecordType:  record.ResourceRecordType__A,
+					ResourceRecordClass: record.ResourceRecordClass__In,
+					Ttl:                 60,
+					RDataLength:         4,
+					RData:               net.IPv4(192, 168, 1, 2).To4(),
+				},
+				{
+					Name:                []string{"example", "com"},
+					ResourceRecordType:  record.ResourceRecordType__A,
+					ResourceRecordClass: record.ResourceRecordClass__In,
+					Ttl:                 30,
+					RDataLength:         4,
+					RData:               net.IPv4(192, 168, 1, 1).To4(),
+				},
+				{
+					Name:                []string{"example", "com"},
+					ResourceRecordType:  record.ResourceRecordType__A,
+					ResourceRecordClass: record.ResourceRecordClass__In,
+					Ttl:                 60,
+					RDataLength:         4,
+					RData:               net.IPv4(192, 168, 1, 2).To4(),
+				},
+			},
+		},
+	}
+
+	var decoded Message
+	err := NewDecoder(NewEncoder().Encode(&msg)).Decode(&decoded)
+	assert.NoError(t, err)
+
+	// Duplicate should be removed, records sorted and sharing the lowest TTL
+	assert.Equal(t, uint16(2), decoded.Header.NumberOfAnswers)
+	assert.Equal(t, []byte(net.IPv4(192, 168, 1, 1).To4()), decoded.Body.Answers[0].RData)
+	assert.Equal(t, []byte(net.IPv4(192, 168, 1, 2).To4()), decoded.Body.Answers[1].RData)
+	assert.Equal(t, uint32(30), decoded.Body.Answers[0].Ttl)
+	assert.Equal(t, uint32(30), decoded.Body.Answers[1].Ttl)
+}
+
+func TestEncoder_EncodeWithinLimit(t *testing.T) {
+	newAnswers := func(count int) []Answer {
+		answers := make([]Answer, 0, count)
+		for i := range count {
+			answers = append(answers, Answer{
+				Name:                []string{"example", "com"},
+				ResourceRecordType:  record.ResourceRecordType__A,
+				ResourceRecordClass: record.ResourceRecordClass__In,
+				Ttl:                 60,
+				RDataLength:         4,
+				RData:               net.IPv4(192, 168, 1, byte(i)).To4(),
+			})
+		}
+		return answers
+	}
+
+	otherAdditional := newAnswers(1)
+	otherAdditional[0].Name = []string{"mail", "example", "com"}
+
+	testCases := []struct {
+		name               string
+		answers            []Answer
+		additional         []Answer
+		limit              int
+		expectedAnswers    uint16
+		expectedAdditional uint16
+		expectedTruncation bool
+	}{
+		{
+			name:               "Message within limit should be encoded whole",
+			answers:            newAnswers(2),
+			additional:         newAnswers(2),
+			limit:              512,
+			expectedAnswers:    2,
+			expectedAdditional: 2,
+		},
+		{
+			name:               "Additional RRsets should be dropped whole before answers",
+			answers:            newAnswers(2),
+			additional:         append(otherAdditional, newAnswers(2)...),
+			limit:              12 + 2*27 + 32,
+			expectedAnswers:    2,
+			expectedAdditional: 1,
+		},
+		{
+			name:               "Answers not fitting within limit should be truncated",
+			answers:            newAnswers(30),
+			additional:         newAnswers(2),
+			limit:              512,
+			expectedAnswers:    0,
+			expectedAdditional: 0,
+			expectedTruncation: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := Message{
+				Body: MessageBody{
+					Answers:    tc.answers,
+					Additional: tc.additional,
+				},
+			}
+
+			encoded := NewEncoder().EncodeWithinLimit(&msg, tc.limit)
+			assert.LessOrEqual(t, len(encoded), tc.limit)
+
+			var decoded Message
+			assert.NoError(t, NewDecoder(encoded).Decode(&decoded))
+
+			assert.Equal(t, tc.expectedAnswers, decoded.Header.NumberOfAnswers)
+			assert.Equal(t, tc.expectedAdditional, decoded.Header.NumberOfAdditionalRR)
+			assert.Equal(t, tc.expectedTruncation, decoded.Header.Flags.Truncation)
+		})
+	}
+}
@@ -1,10 +1,10 @@
 package record
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
-	"strings"
 )
 
 type ResourceRecord interface {
@@ -73,7 +73,7 @@ func (r *AAAARecord) Type() ResourceRecordType {
 }
 
 func (r *AAAARecord) Data() []byte {
-	return r.address.To4()
+	return r.address.To16()
 }
 
 // RR pointing to another domain
@@ -95,8 +95,13 @@ func (r *CNAMERecord) Type() ResourceRecordType {
 	return ResourceRecordType__CNAME
 }
 
+// Canonical name the owner is an alias for
+func (r *CNAMERecord) Domain() []string {
+	return r.domain
+}
+
 func (r *CNAMERecord) Data() []byte {
-	return []byte(strings.Join(r.domain, ""))
+	return encodeDomainName(r.domain)
 }
 
 func NewCNAMERecord(name []string, class ResourceRecordClass, domain []string) *CNAMERecord {
@@ -138,11 +143,12 @@ func NewTXTRecord(name []string, class ResourceRecordClass, data []byte) *TXTRec
 	}
 }
 
-// RR pointing to raw data
+// RR pointing to the mail exchange of the domain (RFC 1035 §3.3.9)
 type MXRecord struct {
-	name  []string
-	class ResourceRecordClass
-	data  []byte
+	name       []string
+	class      ResourceRecordClass
+	preference uint16
+	exchange   []string
 }
 
 func (r *MXRecord) Name() []string {
@@ -154,29 +160,317 @@ func (r *MXRecord) Class() ResourceRecordClass {
 }
 
 func (r *MXRecord) Type() ResourceRecordType {
-	return ResourceRecordType__TXT
+	return ResourceRecordType__MX
+}
+
+// Name of the host accepting mail for the domain
+func (r *MXRecord) Exchange() []string {
+	return r.exchange
 }
 
 func (r *MXRecord) Data() []byte {
-	return r.data
+	data := binary.BigEndian.AppendUint16(make([]byte, 0), r.preference)
+	return append(data, encodeDomainName(r.exchange)...)
 }
 
-func NewMXRecord(name []string, class ResourceRecordClass, data []byte) *MXRecord {
+func NewMXRecord(name []string, class ResourceRecordClass, preference uint16, exchange []string) *MXRecord {
 	return &MXRecord{
+		name:       name,
+		class:      class,
+		preference: preference,
+		exchange:   exchange,
+	}
+}
+
+// RR pointing to the host providing the service (RFC 2782)
+type SRVRecord struct {
+	name     []string
+	class    ResourceRecordClass
+	priority uint16
+	weight   uint16
+	port     uint16
+	target   []string
+}
+
+func (r *SRVRecord) Name() []string {
+	return r.name
+}
+
+func (r *SRVRecord) Class() ResourceRecordClass {
+	return r.class
+}
+
+func (r *SRVRecord) Type() ResourceRecordType {
+	return ResourceRecordType__SRV
+}
+
+// Name of the host providing the service
+func (r *SRVRecord) Target() []string {
+	return r.target
+}
+
+func (r *SRVRecord) Data() []byte {
+	data := make([]byte, 0)
+	for _, value := range []uint16{r.priority, r.weight, r.port} {
+		data = binary.BigEndian.AppendUint16(data, value)
+	}
+
+	// Target name must not be compressed (RFC 2782)
+	return append(data, encodeDomainName(r.target)...)
+}
+
+func NewSRVRecord(name []string, class ResourceRecordClass, priority, weight, port uint16, target []string) *SRVRecord {
+	return &SRVRecord{
+		name:     name,
+		class:    class,
+		priority: priority,
+		weight:   weight,
+		port:     port,
+		target:   target,
+	}
+}
+
+// RR delegating authority over the zone to the name server (RFC 1035 §3.3.11)
+type NSRecord struct {
+	name   []string
+	class  ResourceRecordClass
+	domain []string
+}
+
+func (r *NSRecord) Name() []string {
+	return r.name
+}
+
+func (r *NSRecord) Class() ResourceRecordClass {
+	return r.class
+}
+
+func (r *NSRecord) Type() ResourceRecordType {
+	return ResourceRecordType__NS
+}
+
+// Name of the authoritative name server
+func (r *NSRecord) Domain() []string {
+	return r.domain
+}
+
+func (r *NSRecord) Data() []byte {
+	return encodeDomainName(r.domain)
+}
+
+func NewNSRecord(name []string, class ResourceRecordClass, domain []string) *NSRecord {
+	return &NSRecord{
+		name:   name,
+		class:  class,
+		domain: domain,
+	}
+}
+
+// RR pointing from reverse name to the domain (RFC 1035 §3.3.12)
+type PTRRecord struct {
+	name   []string
+	class  ResourceRecordClass
+	domain []string
+}
+
+func (r *PTRRecord) Name() []string {
+	return r.name
+}
+
+func (r *PTRRecord) Class() ResourceRecordClass {
+	return r.class
+}
+
+func (r *PTRRecord) Type() ResourceRecordType {
+	return ResourceRecordType__PTR
+}
+
+func (r *PTRRecord) Data() []byte {
+	return encodeDomainName(r.domain)
+}
+
+func NewPTRRecord(name []string, class ResourceRecordClass, domain []string) *PTRRecord {
+	return &PTRRecord{
+		name:   name,
+		class:  class,
+		domain: domain,
+	}
+}
+
+// RR marking the start of a zone of authority (RFC 1035 §3.3.13)
+type SOARecord struct {
+	name    []string
+	class   ResourceRecordClass
+	mname   []string
+	rname   []string
+	serial  uint32
+	refresh uint32
+	retry   uint32
+	expire  uint32
+	minimum uint32
+}
+
+func NewSOARecord(
+	name []string,
+	class ResourceRecordClass,
+	mname []string,
+	rname []string,
+	serial, refresh, retry, expire, minimum uint32,
+) *SOARecord {
+	return &SOARecord{
+		name:    name,
+		class:   class,
+		mname:   mname,
+		rname:   rname,
+		serial:  serial,
+		refresh: refresh,
+		retry:   retry,
+		expire:  expire,
+		minimum: minimum,
+	}
+}
+
+func (r *SOARecord) Name() []string {
+	return r.name
+}
+
+func (r *SOARecord) Class() ResourceRecordClass {
+	return r.class
+}
+
+func (r *SOARecord) Type() ResourceRecordType {
+	return ResourceRecordType__SOA
+}
+
+// TTL used for negative caching (RFC 2308 §4)
+func (r *SOARecord) Minimum() uint32 {
+	return r.minimum
+}
+
+func (r *SOARecord) Data() []byte {
+
+	data := encodeDomainName(r.mname)
+	data = append(data, encodeDomainName(r.rname)...)
+
+	for _, value := range []uint32{r.serial, r.refresh, r.retry, r.expire, r.minimum} {
+		data = binary.BigEndian.AppendUint32(data, value)
+	}
+
+	return data
+}
+
+// RR describing host hardware and operating system (RFC 1035 §3.3.2),
+// also used as a minimal response to ANY queries (RFC 8482 §4.2)
+type HINFORecord struct {
+	name  []string
+	class ResourceRecordClass
+	cpu   string
+	os    string
+}
+
+func (r *HINFORecord) Name() []string {
+	return r.name
+}
+
+func (r *HINFORecord) Class() ResourceRecordClass {
+	return r.class
+}
+
+func (r *HINFORecord) Type() ResourceRecordType {
+	return ResourceRecordType__HINFO
+}
+
+func (r *HINFORecord) Data() []byte {
+	data := encodeCharacterString(r.cpu)
+	return append(data, encodeCharacterString(r.os)...)
+}
+
+func NewHINFORecord(name []string, class ResourceRecordClass, cpu string, os string) *HINFORecord {
+	return &HINFORecord{
 		name:  name,
 		class: class,
+		cpu:   cpu,
+		os:    os,
+	}
+}
+
+// RR with already encoded RDATA, e.g. decoded from the wire
+type RawRecord struct {
+	name  []string
+	t     ResourceRecordType
+	class ResourceRecordClass
+	data  []byte
+}
+
+func NewRawRecord(name []string, t ResourceRecordType, class ResourceRecordClass, data []byte) *RawRecord {
+	return &RawRecord{
+		name:  name,
+		t:     t,
+		class: class,
 		data:  data,
 	}
 }
 
+func (r *RawRecord) Name() []string {
+	return r.name
+}
+
+func (r *RawRecord) Class() ResourceRecordClass {
+	return r.class
+}
+
+func (r *RawRecord) Type() ResourceRecordType {
+	return r.t
+}
+
+func (r *RawRecord) Data() []byte {
+	return r.data
+}
+
+// Encodes domain name as sequence of length prefixed labels terminated by the root label
+func encodeDomainName(name []string) []byte {
+
+	encodedName := make([]byte, 0)
+
+	for _, label := range name {
+		if len(label) == 0 {
+			continue
+		}
+
+		encodedName = append(encodedName, uint8(len(label)))
+		encodedName = append(encodedName, []byte(label)...)
+	}
+
+	// Termination byte
+	encodedName = append(encodedName, 0)
+
+	return encodedName
+}
+
+// Encodes character string as single length octet followed by at most 255 characters
+func encodeCharacterString(value string) []byte {
+	if len(value) > 255 {
+		value = value[:255]
+	}
+
+	return append([]byte{uint8(len(value))}, []byte(value)...)
+}
+
 type ResourceRecordType uint
 
 const (
 	ResourceRecordType__A     = 1
-	ResourceRecordType__AAAA  = 2
-	ResourceRecordType__MX    = 3
-	ResourceRecordType__TXT   = 4
+	ResourceRecordType__NS    = 2
 	ResourceRecordType__CNAME = 5
+	ResourceRecordType__SOA   = 6
+	ResourceRecordType__PTR   = 12
+	ResourceRecordType__HINFO = 13
+	ResourceRecordType__MX    = 15
+	ResourceRecordType__TXT   = 16
+	ResourceRecordType__AAAA  = 28
+	ResourceRecordType__SRV   = 33
+	ResourceRecordType__OPT   = 41
+	ResourceRecordType__ANY   = 255
 )
 
 func NewResourceRecordType(code uint16) (ResourceRecordType, error) {
@@ -184,11 +478,27 @@ func NewResourceRecordType(code uint16) (ResourceRecordType, error) {
 	case 1:
 		return ResourceRecordType__A, nil
 	case 2:
-		return ResourceRecordType__AAAA, nil
-	case 3:
+		return ResourceRecordType__NS, nil
+	case 5:
+		return ResourceRecordType__CNAME, nil
+	case 6:
+		return ResourceRecordType__SOA, nil
+	case 12:
+		return ResourceRecordType__PTR, nil
+	case 13:
+		return ResourceRecordType__HINFO, nil
+	case 15:
 		return ResourceRecordType__MX, nil
-	case 4:
+	case 16:
 		return ResourceRecordType__TXT, nil
+	case 28:
+		return ResourceRecordType__AAAA, nil
+	case 33:
+		return ResourceRecordType__SRV, nil
+	case 41:
+		return ResourceRecordType__OPT, nil
+	case 255:
+		return ResourceRecordType__ANY, nil
 	default:
 		return 0, errors.New("Invalid resource record type code")
 	}
@@ -198,7 +508,9 @@ type ResourceRecordClass uint
 
 const (
 	ResourceRecordClass__In     = 1
-	ResourceRecordClass__Ch     = 2
+	ResourceRecordClass__Cs     = 2
+	ResourceRecordClass__Ch     = 3
+	ResourceRecordClass__Hs     = 4
 	ResourceRecordClass__Review = 256
 )
 
@@ -206,6 +518,12 @@ func NewResourceRecordClass(code uint16) (ResourceRecordClass, error) {
 	switch code {
 	case 1:
 		return ResourceRecordClass__In, nil
+	case 2:
+		return ResourceRecordClass__Cs, nil
+	case 3:
+		return ResourceRecordClass__Ch, nil
+	case 4:
+		return ResourceRecordClass__Hs, nil
 	case 256:
 		return ResourceRecordClass__Review, nil
 	default:
@@ -0,0 +1,20 @@
+package record
+
+import "strings"
+
+// Checks whether the name is equal to or below the suffix, ignoring case
+func IsSubdomain(name []string, suffix []string) bool {
+
+	if len(suffix) > len(name) {
+		return false
+	}
+
+	offset := len(name) - len(suffix)
+	for i, label := range suffix {
+		if !strings.EqualFold(name[offset+i], label) {
+			return false
+		}
+	}
+
+	return true
+}
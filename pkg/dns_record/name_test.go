@@ -0,0 +1,51 @@
+package record
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSubdomain(t *testing.T) {
+	testCases := []struct {
+		name     string
+		domain   []string
+		suffix   []string
+		expected bool
+	}{
+		{
+			name:     "Name should be subdomain of itself",
+			domain:   []string{"example", "com"},
+			suffix:   []string{"example", "com"},
+			expected: true,
+		},
+		{
+			name:     "Name below the suffix should be subdomain ignoring case",
+			domain:   []string{"www", "Example", "COM"},
+			suffix:   []string{"example", "com"},
+			expected: true,
+		},
+		{
+			name:     "Every name should be subdomain of the root",
+			domain:   []string{"example", "com"},
+			suffix:   []string{},
+			expected: true,
+		},
+		{
+			name:   "Name sharing only the end of a label should not be subdomain",
+			domain: []string{"badexample", "com"},
+			suffix: []string{"example", "com"},
+		},
+		{
+			name:   "Parent should not be subdomain of its child",
+			domain: []string{"com"},
+			suffix: []string{"example", "com"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, IsSubdomain(tc.domain, tc.suffix))
+		})
+	}
+}
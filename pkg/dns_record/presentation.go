@@ -0,0 +1,176 @@
+package record
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var resourceRecordTypeNames = map[ResourceRecordType]string{
+	ResourceRecordType__A:     "A",
+	ResourceRecordType__NS:    "NS",
+	ResourceRecordType__CNAME: "CNAME",
+	ResourceRecordType__SOA:   "SOA",
+	ResourceRecordType__PTR:   "PTR",
+	ResourceRecordType__HINFO: "HINFO",
+	ResourceRecordType__MX:    "MX",
+	ResourceRecordType__TXT:   "TXT",
+	ResourceRecordType__AAAA:  "AAAA",
+	ResourceRecordType__SRV:   "SRV",
+	ResourceRecordType__OPT:   "OPT",
+	ResourceRecordType__ANY:   "ANY",
+}
+
+func (t ResourceRecordType) String() string {
+	name, ok := resourceRecordTypeNames[t]
+	if !ok {
+		return fmt.Sprintf("TYPE%d", uint(t))
+	}
+	return name
+}
+
+// Parses record type given either by its mnemonic, e.g. "AAAA", or by its numeric code
+func ParseResourceRecordType(value string) (ResourceRecordType, error) {
+
+	for t, name := range resourceRecordTypeNames {
+		if strings.EqualFold(name, value) {
+			return t, nil
+		}
+	}
+
+	code, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "TYPE"), 10, 16)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid resource record type: %s", value))
+	}
+
+	return NewResourceRecordType(uint16(code))
+}
+
+// Formats RDATA in zone file presentation format. RDATA which can't be parsed
+// is formatted in the generic format (RFC 3597 §5)
+func FormatRData(t ResourceRecordType, data []byte) string {
+
+	formatted, err := formatRData(t, data)
+	if err != nil {
+		return fmt.Sprintf("\\# %d %s", len(data), hex.EncodeToString(data))
+	}
+
+	return formatted
+}
+
+func formatRData(t ResourceRecordType, data []byte) (string, error) {
+
+	switch t {
+	case ResourceRecordType__A:
+		if len(data) != net.IPv4len {
+			return "", errors.New("Invalid length of A record")
+		}
+		return net.IP(data).String(), nil
+
+	case ResourceRecordType__AAAA:
+		if len(data) != net.IPv6len {
+			return "", errors.New("Invalid length of AAAA record")
+		}
+		return net.IP(data).String(), nil
+
+	case ResourceRecordType__NS, ResourceRecordType__CNAME, ResourceRecordType__PTR:
+		return formatFields(data, domainField)
+
+	case ResourceRecordType__MX:
+		return formatFields(data, uint16Field, domainField)
+
+	case ResourceRecordType__SRV:
+		return formatFields(data, uint16Field, uint16Field, uint16Field, domainField)
+
+	case ResourceRecordType__SOA:
+		return formatFields(data, domainField, domainField, uint32Field, uint32Field, uint32Field, uint32Field, uint32Field)
+
+	case ResourceRecordType__HINFO:
+		return formatFields(data, characterStringField, characterStringField)
+
+	case ResourceRecordType__TXT:
+		fields := make([]string, 0)
+		for len(data) > 0 {
+			field, rest, err := characterStringField(data)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, field)
+			data = rest
+		}
+		return strings.Join(fields, " "), nil
+
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported record type: %s", t))
+	}
+}
+
+// Reads single field from the beginning of RDATA, returning its presentation and remaining data
+type rdataField func(data []byte) (string, []byte, error)
+
+func formatFields(data []byte, fields ...rdataField) (string, error) {
+
+	formatted := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value, rest, err := field(data)
+		if err != nil {
+			return "", err
+		}
+		formatted = append(formatted, value)
+		data = rest
+	}
+
+	if len(data) > 0 {
+		return "", errors.New("Unexpected data after the last field")
+	}
+
+	return strings.Join(formatted, " "), nil
+}
+
+func uint16Field(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("Invalid length of 16-bit field")
+	}
+	return strconv.Itoa(int(binary.BigEndian.Uint16(data))), data[2:], nil
+}
+
+func uint32Field(data []byte) (string, []byte, error) {
+	if len(data) < 4 {
+		return "", nil, errors.New("Invalid length of 32-bit field")
+	}
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(data)), 10), data[4:], nil
+}
+
+// Reads uncompressed domain name, as RDATA of records is never compressed by the encoder
+func domainField(data []byte) (string, []byte, error) {
+
+	labels := make([]string, 0)
+	for {
+		if len(data) == 0 {
+			return "", nil, errors.New("Domain name is not terminated")
+		}
+
+		length := int(data[0])
+		if length == 0 {
+			return strings.Join(labels, ".") + ".", data[1:], nil
+		}
+
+		if length > 63 || len(data) < 1+length {
+			return "", nil, errors.New("Invalid length of domain name label")
+		}
+
+		labels = append(labels, string(data[1:1+length]))
+		data = data[1+length:]
+	}
+}
+
+func characterStringField(data []byte) (string, []byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return "", nil, errors.New("Invalid length of character string")
+	}
+	return strconv.Quote(string(data[1 : 1+int(data[0])])), data[1+int(data[0]):], nil
+}
@@ -0,0 +1,102 @@
+package record
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatRData(t *testing.T) {
+	name := []string{"example", "com"}
+
+	testCases := []struct {
+		name     string
+		record   ResourceRecord
+		expected string
+	}{
+		{
+			name:     "A record should be formatted as IPv4 address",
+			record:   NewARecord(name, ResourceRecordClass__In, net.IPv4(192, 168, 1, 1)),
+			expected: "192.168.1.1",
+		},
+		{
+			name:     "AAAA record should be formatted as IPv6 address",
+			record:   NewAAAARecord(name, ResourceRecordClass__In, net.ParseIP("2001:db8::1")),
+			expected: "2001:db8::1",
+		},
+		{
+			name:     "CNAME record should be formatted as fully qualified name",
+			record:   NewCNAMERecord(name, ResourceRecordClass__In, []string{"www", "example", "com"}),
+			expected: "www.example.com.",
+		},
+		{
+			name:     "MX record should be formatted with its preference",
+			record:   NewMXRecord(name, ResourceRecordClass__In, 10, []string{"mail", "example", "com"}),
+			expected: "10 mail.example.com.",
+		},
+		{
+			name:     "SRV record should be formatted with priority, weight and port",
+			record:   NewSRVRecord(name, ResourceRecordClass__In, 10, 5, 5060, []string{"sip", "example", "com"}),
+			expected: "10 5 5060 sip.example.com.",
+		},
+		{
+			name: "SOA record should be formatted with all timers",
+			record: NewSOARecord(name, ResourceRecordClass__In,
+				[]string{"ns1", "example", "com"}, []string{"hostmaster", "example", "com"},
+				1, 7200, 3600, 1209600, 300),
+			expected: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		},
+		{
+			name:     "HINFO record should be formatted as quoted strings",
+			record:   NewHINFORecord(name, ResourceRecordClass__In, "RFC8482", ""),
+			expected: `"RFC8482" ""`,
+		},
+		{
+			name:     "Malformed RDATA should be formatted in generic format",
+			record:   NewRawRecord(name, ResourceRecordType__A, ResourceRecordClass__In, []byte{0x01, 0x02}),
+			expected: `\# 2 0102`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, FormatRData(tc.record.Type(), tc.record.Data()))
+		})
+	}
+}
+
+func TestParseResourceRecordType(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       string
+		expected    ResourceRecordType
+		expectedErr bool
+	}{
+		{
+			name:     "Mnemonic should be parsed case-insensitively",
+			value:    "aaaa",
+			expected: ResourceRecordType__AAAA,
+		},
+		{
+			name:     "Numeric code should be parsed",
+			value:    "15",
+			expected: ResourceRecordType__MX,
+		},
+		{
+			name:        "Unknown type should be rejected",
+			value:       "BOGUS",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := ParseResourceRecordType(tc.value)
+			assert.Equal(t, tc.expectedErr, err != nil)
+			if err == nil {
+				assert.Equal(t, tc.expected, parsed)
+			}
+		})
+	}
+}
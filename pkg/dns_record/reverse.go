@@ -0,0 +1,94 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	REVERSE_IPV4_SUFFIX = "in-addr.arpa"
+	REVERSE_IPV6_SUFFIX = "ip6.arpa"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// Returns name used for reverse lookups of the address,
+// e.g. 1.1.168.192.in-addr.arpa for 192.168.1.1
+func ReverseName(ip net.IP) []string {
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		name := make([]string, 0, 6)
+		for i := len(ipv4) - 1; i >= 0; i-- {
+			name = append(name, strconv.Itoa(int(ipv4[i])))
+		}
+
+		return append(name, strings.Split(REVERSE_IPV4_SUFFIX, ".")...)
+	}
+
+	ipv6 := ip.To16()
+	if ipv6 == nil {
+		return nil
+	}
+
+	// Each nibble of the address is a separate label, starting from the least significant one
+	name := make([]string, 0, 34)
+	for i := len(ipv6) - 1; i >= 0; i-- {
+		name = append(name, string(hexDigits[ipv6[i]&0x0f]), string(hexDigits[ipv6[i]>>4]))
+	}
+
+	return append(name, strings.Split(REVERSE_IPV6_SUFFIX, ".")...)
+}
+
+// Returns address encoded in the reverse lookup name
+func ParseReverseName(name []string) (net.IP, error) {
+
+	joined := strings.ToLower(strings.Join(name, "."))
+
+	if strings.HasSuffix(joined, "."+REVERSE_IPV4_SUFFIX) {
+		labels := name[:len(name)-2]
+		if len(labels) != net.IPv4len {
+			return nil, errors.New(fmt.Sprintf("Invalid number of labels in reverse name: %s", joined))
+		}
+
+		ip := make(net.IP, net.IPv4len)
+		for i, label := range labels {
+			octet, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid octet in reverse name: %s", joined))
+			}
+			ip[net.IPv4len-1-i] = byte(octet)
+		}
+
+		return ip, nil
+	}
+
+	if strings.HasSuffix(joined, "."+REVERSE_IPV6_SUFFIX) {
+		labels := name[:len(name)-2]
+		if len(labels) != net.IPv6len*2 {
+			return nil, errors.New(fmt.Sprintf("Invalid number of labels in reverse name: %s", joined))
+		}
+
+		ip := make(net.IP, net.IPv6len)
+		for i, label := range labels {
+			nibble := strings.Index(hexDigits, strings.ToLower(label))
+			if len(label) != 1 || nibble < 0 {
+				return nil, errors.New(fmt.Sprintf("Invalid nibble in reverse name: %s", joined))
+			}
+
+			// Labels start from the low nibble of the last byte
+			index := net.IPv6len - 1 - i/2
+			if i%2 == 0 {
+				ip[index] |= byte(nibble)
+			} else {
+				ip[index] |= byte(nibble) << 4
+			}
+		}
+
+		return ip, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Not a reverse lookup name: %s", joined))
+}
@@ -0,0 +1,82 @@
+package record
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseName(t *testing.T) {
+	testCases := []struct {
+		name         string
+		address      net.IP
+		expectedName string
+	}{
+		{
+			name:         "IPv4 address should be reversed under in-addr.arpa",
+			address:      net.ParseIP("192.168.1.10"),
+			expectedName: "10.1.168.192.in-addr.arpa",
+		},
+		{
+			name:         "IPv6 address should be reversed nibble by nibble under ip6.arpa",
+			address:      net.ParseIP("2001:db8::567:89ab"),
+			expectedName: "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedName, strings.Join(ReverseName(tc.address), "."))
+		})
+	}
+}
+
+func TestParseReverseName(t *testing.T) {
+	testCases := []struct {
+		name            string
+		reverseName     string
+		expectedAddress net.IP
+		expectedErr     bool
+	}{
+		{
+			name:            "IPv4 reverse name should be parsed",
+			reverseName:     "10.1.168.192.in-addr.arpa",
+			expectedAddress: net.ParseIP("192.168.1.10"),
+		},
+		{
+			name:            "IPv6 reverse name should be parsed regardless of case",
+			reverseName:     "B.A.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.IP6.ARPA",
+			expectedAddress: net.ParseIP("2001:db8::567:89ab"),
+		},
+		{
+			name:        "Partial IPv4 reverse name should be rejected",
+			reverseName: "168.192.in-addr.arpa",
+			expectedErr: true,
+		},
+		{
+			name:        "Octet out of range should be rejected",
+			reverseName: "300.1.168.192.in-addr.arpa",
+			expectedErr: true,
+		},
+		{
+			name:        "Forward name should be rejected",
+			reverseName: "www.example.com",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			address, err := ParseReverseName(strings.Split(tc.reverseName, "."))
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, tc.expectedAddress.Equal(address))
+		})
+	}
+}
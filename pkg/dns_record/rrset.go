@@ -0,0 +1,157 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Identifies an RRset: all records sharing owner name, type and class (RFC 2181 §5)
+type RRSetKey struct {
+	Name  string
+	Type  ResourceRecordType
+	Class ResourceRecordClass
+}
+
+func NewRRSetKey(name []string, t ResourceRecordType, class ResourceRecordClass) RRSetKey {
+	return RRSetKey{
+		// Owner names are compared case-insensitively
+		Name:  strings.ToLower(strings.Join(name, ".")),
+		Type:  t,
+		Class: class,
+	}
+}
+
+// TTL of records which don't define their own
+const DEFAULT_TTL = 1080
+
+// Set of records with the same owner name, type and class sharing single TTL
+type RRSet struct {
+	key     RRSetKey
+	name    []string
+	ttl     uint32
+	records []ResourceRecord
+}
+
+func NewRRSet(name []string, t ResourceRecordType, class ResourceRecordClass, ttl uint32) *RRSet {
+	return &RRSet{
+		key:     NewRRSetKey(name, t, class),
+		name:    name,
+		ttl:     ttl,
+		records: make([]ResourceRecord, 0),
+	}
+}
+
+func (s *RRSet) Key() RRSetKey {
+	return s.key
+}
+
+func (s *RRSet) Name() []string {
+	return s.name
+}
+
+func (s *RRSet) Type() ResourceRecordType {
+	return s.key.Type
+}
+
+func (s *RRSet) Class() ResourceRecordClass {
+	return s.key.Class
+}
+
+func (s *RRSet) Ttl() uint32 {
+	return s.ttl
+}
+
+func (s *RRSet) Records() []ResourceRecord {
+	return s.records
+}
+
+func (s *RRSet) Len() int {
+	return len(s.records)
+}
+
+// Adds record to the set. Records with RDATA already present in the set are ignored.
+// TTL of the whole set is lowered to the smallest TTL seen (RFC 2181 §5.2)
+func (s *RRSet) Add(rr ResourceRecord, ttl uint32) error {
+
+	if NewRRSetKey(rr.Name(), rr.Type(), rr.Class()) != s.key {
+		return errors.New(fmt.Sprintf(
+			"Record %s does not belong to RRset %s",
+			strings.Join(rr.Name(), "."), s.key.Name))
+	}
+
+	if ttl < s.ttl {
+		s.ttl = ttl
+	}
+
+	for _, existing := range s.records {
+		if bytes.Equal(existing.Data(), rr.Data()) {
+			return nil
+		}
+	}
+
+	s.records = append(s.records, rr)
+
+	return nil
+}
+
+// Limits TTL of the set to [min, max] range. The set already shares the lowest TTL of its records (RFC 2181 §5.2)
+func (s *RRSet) ClampTtl(min, max uint32) {
+	if s.ttl < min {
+		s.ttl = min
+	}
+
+	if s.ttl > max {
+		s.ttl = max
+	}
+}
+
+// Sorts records by their RDATA treated as left-justified unsigned octet sequences (RFC 4034 §6.3)
+func (s *RRSet) Sort() {
+	sort.SliceStable(s.records, func(i, j int) bool {
+		return bytes.Compare(s.records[i].Data(), s.records[j].Data()) < 0
+	})
+}
+
+// Groups records into RRsets preserving the order in which sets were first seen
+type RRSetBuilder struct {
+	order []RRSetKey
+	sets  map[RRSetKey]*RRSet
+}
+
+func NewRRSetBuilder() *RRSetBuilder {
+	return &RRSetBuilder{
+		order: make([]RRSetKey, 0),
+		sets:  make(map[RRSetKey]*RRSet),
+	}
+}
+
+func (b *RRSetBuilder) Add(rr ResourceRecord, ttl uint32) {
+
+	key := NewRRSetKey(rr.Name(), rr.Type(), rr.Class())
+
+	set, ok := b.sets[key]
+	if !ok {
+		set = NewRRSet(rr.Name(), rr.Type(), rr.Class(), ttl)
+		b.sets[key] = set
+		b.order = append(b.order, key)
+	}
+
+	// Key always matches, so Add can't fail
+	_ = set.Add(rr, ttl)
+}
+
+// Returns canonically sorted RRsets
+func (b *RRSetBuilder) Build() []*RRSet {
+
+	sets := make([]*RRSet, 0, len(b.order))
+	for _, key := range b.order {
+		set := b.sets[key]
+		set.Sort()
+		sets = append(sets, set)
+	}
+
+	return sets
+}
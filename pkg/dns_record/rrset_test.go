@@ -0,0 +1,135 @@
+package record
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRRSet_Add(t *testing.T) {
+	testCases := []struct {
+		name            string
+		records         []ResourceRecord
+		ttls            []uint32
+		expectedRecords int
+		expectedTtl     uint32
+		expectedErr     bool
+	}{
+		{
+			name: "Records with different RDATA should be kept",
+			records: []ResourceRecord{
+				NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 1)),
+				NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 2)),
+			},
+			ttls:            []uint32{60, 60},
+			expectedRecords: 2,
+			expectedTtl:     60,
+		},
+		{
+			name: "Records with duplicated RDATA should be ignored",
+			records: []ResourceRecord{
+				NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 1)),
+				NewARecord([]string{"EXAMPLE", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 1)),
+			},
+			ttls:            []uint32{60, 60},
+			expectedRecords: 1,
+			expectedTtl:     60,
+		},
+		{
+			name: "TTL of the set should be lowered to the smallest one",
+			records: []ResourceRecord{
+				NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 1)),
+				NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 2)),
+			},
+			ttls:            []uint32{300, 30},
+			expectedRecords: 2,
+			expectedTtl:     30,
+		},
+		{
+			name: "Record of another type should be rejected",
+			records: []ResourceRecord{
+				NewTXTRecord([]string{"example", "com"}, ResourceRecordClass__In, []byte("text")),
+			},
+			ttls:            []uint32{60},
+			expectedRecords: 0,
+			expectedTtl:     60,
+			expectedErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewRRSet([]string{"example", "com"}, ResourceRecordType__A, ResourceRecordClass__In, 60)
+
+			for i, rr := range tc.records {
+				err := set.Add(rr, tc.ttls[i])
+				assert.Equal(t, tc.expectedErr, err != nil)
+			}
+
+			assert.Equal(t, tc.expectedRecords, set.Len())
+			assert.Equal(t, tc.expectedTtl, set.Ttl())
+		})
+	}
+}
+
+func TestRRSet_ClampTtl(t *testing.T) {
+	testCases := []struct {
+		name        string
+		ttl         uint32
+		min         uint32
+		max         uint32
+		expectedTtl uint32
+	}{
+		{
+			name:        "TTL below minimum should be raised",
+			ttl:         5,
+			min:         30,
+			max:         3600,
+			expectedTtl: 30,
+		},
+		{
+			name:        "TTL above maximum should be lowered",
+			ttl:         86400,
+			min:         30,
+			max:         3600,
+			expectedTtl: 3600,
+		},
+		{
+			name:        "TTL within range should be kept",
+			ttl:         300,
+			min:         30,
+			max:         3600,
+			expectedTtl: 300,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set := NewRRSet([]string{"example", "com"}, ResourceRecordType__A, ResourceRecordClass__In, tc.ttl)
+			set.ClampTtl(tc.min, tc.max)
+			assert.Equal(t, tc.expectedTtl, set.Ttl())
+		})
+	}
+}
+
+func TestRRSetBuilder_Build(t *testing.T) {
+	builder := NewRRSetBuilder()
+
+	builder.Add(NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 2)), 60)
+	builder.Add(NewTXTRecord([]string{"example", "com"}, ResourceRecordClass__In, []byte("text")), 60)
+	builder.Add(NewARecord([]string{"example", "com"}, ResourceRecordClass__In, net.IPv4(192, 168, 1, 1)), 30)
+
+	sets := builder.Build()
+
+	assert.Len(t, sets, 2)
+
+	// Sets should keep order in which they were first seen
+	assert.Equal(t, ResourceRecordType(ResourceRecordType__A), sets[0].Type())
+	assert.Equal(t, ResourceRecordType(ResourceRecordType__TXT), sets[1].Type())
+
+	// Records within set should be sorted canonically
+	assert.Equal(t, []byte{192, 168, 1, 1}, sets[0].Records()[0].Data())
+	assert.Equal(t, []byte{192, 168, 1, 2}, sets[0].Records()[1].Data())
+	assert.Equal(t, uint32(30), sets[0].Ttl())
+}
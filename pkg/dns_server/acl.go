@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+// Seconds between reloads of ACL rules managed through the management API
+const ACL_REFRESH_INTERVAL_KEY = "DNS_ACL_REFRESH_INTERVAL"
+
+const DEFAULT_ACL_REFRESH_INTERVAL = 30
+
+// Time given to the final update of hit counters when the server stops
+const ACL_FLUSH_TIMEOUT = 5 * time.Second
+
+type ACLConfig struct {
+	// Rules evaluated together with the ones managed through the management API
+	Rules []managementserver.ManagedACLRule `yaml:"rules" toml:"rules"`
+
+	// Seconds between reloads of rules managed through the management API
+	RefreshInterval int `yaml:"refresh_interval" toml:"refresh_interval"`
+}
+
+func (c *ACLConfig) Validate() error {
+
+	errs := make([]error, 0)
+
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid acl.refresh_interval: %d, expected a positive number of seconds", c.RefreshInterval)))
+	}
+
+	for i, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid acl rule %d: %s", i+1, err)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ACL rule prepared for matching queries
+type aclRule struct {
+	// Identifies the rule in metrics, "config/<n>" for rules of the config and "api/<id>" for managed ones
+	key string
+
+	// ID of the managed rule, 0 for rules of the config
+	id       int
+	priority int
+
+	source     netip.Prefix
+	transport  string
+	name       []string
+	recordType record.ResourceRecordType
+	opCode     message.OpCode
+	hasType    bool
+	hasOpCode  bool
+	action     managementserver.ACLAction
+
+	// Hits of managed rules not saved in the repository yet
+	pending atomic.Uint64
+}
+
+func compileACLRule(key string, rule managementserver.ManagedACLRule) (*aclRule, error) {
+
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	compiled := &aclRule{
+		key:       key,
+		id:        rule.ID,
+		priority:  rule.Priority,
+		transport: strings.ToLower(rule.Transport),
+		action:    rule.Action,
+	}
+
+	if rule.Source != "" {
+		compiled.source, _ = rule.SourcePrefix()
+	}
+
+	if name := strings.Trim(strings.ToLower(rule.Name), "."); name != "" {
+		compiled.name = strings.Split(name, ".")
+	}
+
+	if rule.Type != "" {
+		compiled.recordType, _ = record.ParseResourceRecordType(rule.Type)
+		compiled.hasType = true
+	}
+
+	if rule.OpCode != "" {
+		compiled.opCode, _ = message.ParseOperationCode(rule.OpCode)
+		compiled.hasOpCode = true
+	}
+
+	return compiled, nil
+}
+
+// Checks whether the query of the client matches all conditions of the rule
+func (r *aclRule) matches(addr netip.Addr, hasAddr bool, transport string, msg *message.Message) bool {
+
+	if r.source.IsValid() && (!hasAddr || !r.source.Contains(addr)) {
+		return false
+	}
+
+	if r.transport != "" && r.transport != transport {
+		return false
+	}
+
+	if r.hasOpCode && r.opCode != msg.Header.Flags.OperationCode {
+		return false
+	}
+
+	if len(r.name) == 0 && !r.hasType {
+		return true
+	}
+
+	if len(msg.Body.Queries) == 0 {
+		return false
+	}
+
+	query := msg.Body.Queries[0]
+
+	if r.hasType && r.recordType != query.ResourceRecordType {
+		return false
+	}
+
+	return record.IsSubdomain(query.Name, r.name)
+}
+
+// Allows, refuses, drops or answers with NXDOMAIN queries matching rules of the access control list.
+// Queries matching no rule are allowed
+type ACL struct {
+	static     []*aclRule
+	repository managementserver.ACLRepository
+	metrics    *Metrics
+
+	rules  atomic.Pointer[[]*aclRule]
+	reload sync.Mutex
+}
+
+func NewACL(rules []managementserver.ManagedACLRule, repository managementserver.ACLRepository, metrics *Metrics) (*ACL, error) {
+
+	acl := &ACL{
+		static:     make([]*aclRule, 0, len(rules)),
+		repository: repository,
+		metrics:    metrics,
+	}
+
+	for i, rule := range rules {
+		compiled, err := compileACLRule(fmt.Sprintf("config/%d", i+1), rule)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid acl rule %d: %s", i+1, err))
+		}
+		acl.static = append(acl.static, compiled)
+	}
+
+	acl.store(nil)
+
+	return acl, nil
+}
+
+func newACLPlugin(s *Server) (Middleware, error) {
+
+	repository, _ := s.repositorySource().(managementserver.ACLRepository)
+
+	acl, err := NewACL(s.config.ACL.Rules, repository, s.metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	if repository != nil {
+		if err := acl.Reload(s.stopContext()); err != nil {
+			slog.Warn("Failed to load ACL rules, using rules of the config only", "err", err)
+		}
+
+		interval := time.Duration(s.config.ACL.RefreshInterval) * time.Second
+
+		s.listeners.Add(1)
+		go func() {
+			defer s.listeners.Done()
+			acl.refresh(s.stopContext(), interval)
+		}()
+	}
+
+	return acl.Middleware, nil
+}
+
+// Orders rules of the config and managed ones by priority, rules of the config go first among equal ones
+func (a *ACL) store(managed []*aclRule) {
+
+	rules := make([]*aclRule, 0, len(a.static)+len(managed))
+	rules = append(rules, a.static...)
+	rules = append(rules, managed...)
+
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].priority < rules[j].priority })
+
+	a.rules.Store(&rules)
+}
+
+// Replaces managed rules with the current rules from the repository and saves hit counters of the previous ones
+func (a *ACL) Reload(ctx context.Context) error {
+
+	a.reload.Lock()
+	defer a.reload.Unlock()
+
+	previous := *a.rules.Load()
+
+	rules, err := a.repository.GetACLRules(ctx)
+	if err != nil {
+		return errors.Join(a.flush(ctx, previous), err)
+	}
+
+	managed := make([]*aclRule, 0, len(rules))
+	for _, rule := range rules {
+		compiled, err := compileACLRule(fmt.Sprintf("api/%d", rule.ID), rule)
+		if err != nil {
+			slog.Warn("Skipping invalid ACL rule", "id", rule.ID, "err", err)
+			continue
+		}
+		managed = append(managed, compiled)
+	}
+
+	a.store(managed)
+
+	// Counters are taken after the swap, so hits of requests matched in the meantime aren't lost
+	return a.flush(ctx, previous)
+}
+
+// Adds hits of the managed rules to their counters in the repository
+func (a *ACL) flush(ctx context.Context, rules []*aclRule) error {
+
+	hits := make(map[int]uint64)
+
+	for _, rule := range rules {
+		if rule.id == 0 {
+			continue
+		}
+		if count := rule.pending.Swap(0); count > 0 {
+			hits[rule.id] = count
+		}
+	}
+
+	if len(hits) == 0 {
+		return nil
+	}
+
+	if err := a.repository.AddACLRuleHits(ctx, hits); err != nil {
+		// Hits are moved to the current rules, which are flushed on the next attempt
+		for _, rule := range *a.rules.Load() {
+			if rule.id != 0 {
+				rule.pending.Add(hits[rule.id])
+			}
+		}
+		return errors.New(fmt.Sprintf("Failed to save ACL hit counters: %s", err))
+	}
+
+	return nil
+}
+
+// Reloads managed rules periodically until the context is done, saving hit counters one last time afterwards
+func (a *ACL) refresh(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Reload(ctx); err != nil {
+				slog.Warn("Failed to reload ACL rules, keeping previous ones", "err", err)
+			}
+
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), ACL_FLUSH_TIMEOUT)
+			defer cancel()
+
+			a.reload.Lock()
+			defer a.reload.Unlock()
+
+			if err := a.flush(flushCtx, *a.rules.Load()); err != nil {
+				slog.Warn("Failed to save ACL hit counters", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// Returns the first rule matching the query, or nil when none does
+func (a *ACL) match(w ResponseWriter, msg *message.Message) *aclRule {
+
+	addr, hasAddr := clientAddr(w.RemoteAddr())
+	transport := w.Transport()
+
+	for _, rule := range *a.rules.Load() {
+		if rule.matches(addr, hasAddr, transport, msg) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func (a *ACL) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+		rule := a.match(w, msg)
+		if rule == nil {
+			next.ServeDNS(ctx, w, msg)
+			return
+		}
+
+		if rule.id != 0 {
+			rule.pending.Add(1)
+		}
+
+		if a.metrics != nil {
+			a.metrics.countACLHit(rule.key)
+		}
+
+		slog.Debug("Query matched ACL rule", "rule", rule.key, "action", rule.action, "addr", w.RemoteAddr(), "transport", w.Transport())
+
+		switch rule.action {
+		case managementserver.ACLAction_Allow:
+			next.ServeDNS(ctx, w, msg)
+		case managementserver.ACLAction_Refuse:
+			WriteError(w, msg, message.ResponseCode__Refused)
+		case managementserver.ACLAction_NxDomain:
+			WriteError(w, msg, message.ResponseCode__NxDomain)
+		case managementserver.ACLAction_Drop:
+			// Dropped queries are left unanswered
+		}
+	})
+}
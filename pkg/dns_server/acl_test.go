@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL_Middleware(t *testing.T) {
+	rules := []managementserver.ManagedACLRule{
+		{Source: "10.0.0.0/8", Action: managementserver.ACLAction_Allow},
+		{Name: "blocked.example.com", Action: managementserver.ACLAction_NxDomain},
+		{Type: "ANY", Transport: "udp", Action: managementserver.ACLAction_Refuse},
+		{OpCode: "update", Action: managementserver.ACLAction_Drop},
+		{Source: "127.0.0.0/8", Name: "internal", Action: managementserver.ACLAction_Refuse, Priority: -1},
+	}
+
+	update := newTestMessage([]string{"example", "com"}, record.ResourceRecordType__SOA)
+	update.Header.Flags.OperationCode = message.OpCode__Update
+
+	testCases := []struct {
+		name         string
+		msg          *message.Message
+		answered     bool
+		expectedCode message.ResponseCode
+		expectedRule string
+	}{
+		{
+			name:     "Query matching no rule should be passed on",
+			msg:      newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A),
+			answered: true,
+		},
+		{
+			name:         "Query below blocked name should get NXDOMAIN",
+			msg:          newTestMessage([]string{"ads", "Blocked", "example", "com"}, record.ResourceRecordType__A),
+			answered:     true,
+			expectedCode: message.ResponseCode__NxDomain,
+			expectedRule: "config/2",
+		},
+		{
+			name:         "ANY query over UDP should be refused",
+			msg:          newTestMessage([]string{"example", "com"}, record.ResourceRecordType__ANY),
+			answered:     true,
+			expectedCode: message.ResponseCode__Refused,
+			expectedRule: "config/3",
+		},
+		{
+			name:         "Update should be dropped",
+			msg:          update,
+			expectedRule: "config/4",
+		},
+		{
+			name:         "Rule with lower priority value should be evaluated first",
+			msg:          newTestMessage([]string{"db", "internal"}, record.ResourceRecordType__A),
+			answered:     true,
+			expectedCode: message.ResponseCode__Refused,
+			expectedRule: "config/5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics := NewMetrics()
+			acl, err := NewACL(rules, nil, metrics)
+			assert.NoError(t, err)
+
+			handler := acl.Middleware(HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+				WriteError(w, msg, message.ResponseCode__NoError)
+			}))
+
+			writer := &testResponseWriter{}
+			handler.ServeDNS(context.Background(), writer, tc.msg)
+
+			if !tc.answered {
+				assert.Nil(t, writer.response)
+			} else {
+				assert.NotNil(t, writer.response)
+				assert.Equal(t, tc.expectedCode, writer.response.Header.Flags.ResponseCode)
+			}
+
+			if tc.expectedRule != "" {
+				assert.Equal(t, map[string]uint64{tc.expectedRule: 1}, metrics.Snapshot().ACLHits)
+			} else {
+				assert.Empty(t, metrics.Snapshot().ACLHits)
+			}
+		})
+	}
+}
+
+func TestACL_Reload(t *testing.T) {
+	ctx := context.Background()
+	repository := managementserver.NewMemoryRecordsRepository(nil)
+
+	acl, err := NewACL(nil, repository, nil)
+	assert.NoError(t, err)
+
+	rule := &managementserver.ManagedACLRule{Name: "example.com", Action: managementserver.ACLAction_Refuse}
+	assert.NoError(t, repository.CreateACLRule(ctx, rule))
+
+	msg := newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A)
+	assert.Nil(t, acl.match(&testResponseWriter{}, msg), "rules should be loaded only on reload")
+
+	assert.NoError(t, acl.Reload(ctx))
+
+	handler := acl.Middleware(RefusedHandler)
+	for range 3 {
+		handler.ServeDNS(ctx, &testResponseWriter{}, newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A))
+	}
+
+	// Hits are saved in the repository on the next reload
+	assert.NoError(t, acl.Reload(ctx))
+
+	rules, err := repository.GetACLRules(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), rules[0].Hits)
+
+	assert.NoError(t, repository.DeleteACLRule(ctx, rule.ID))
+	assert.NoError(t, acl.Reload(ctx))
+	assert.Nil(t, acl.match(&testResponseWriter{}, msg))
+}
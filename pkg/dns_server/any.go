@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+const (
+	// Selects how ANY queries are answered, see AnyPolicy
+	ANY_POLICY_KEY = "DNS_ANY_POLICY"
+
+	// Allows complete answers to ANY queries received over any transport other than UDP
+	FULL_ANY_OVER_TCP_KEY = "DNS_FULL_ANY_OVER_TCP"
+)
+
+// Default TTL of the HINFO record synthesized in response to ANY queries
+const ANY_HINFO_TTL = 3600
+
+// Limits response to ANY queries, which are a common reflection amplification vector (RFC 8482)
+type AnyPolicy string
+
+const (
+	// Answer with a single synthesized HINFO record (RFC 8482 §4.2)
+	AnyPolicy__Hinfo AnyPolicy = "hinfo"
+
+	// Answer with a single RRset chosen from the ones owned by the name (RFC 8482 §4.1)
+	AnyPolicy__RRSet AnyPolicy = "rrset"
+)
+
+func NewAnyPolicy(policy string) (AnyPolicy, error) {
+	switch AnyPolicy(policy) {
+	case AnyPolicy__Hinfo:
+		return AnyPolicy__Hinfo, nil
+	case AnyPolicy__RRSet:
+		return AnyPolicy__RRSet, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Invalid ANY policy: %s", policy))
+	}
+}
+
+// Replaces complete answer to the ANY query with the minimal one allowed by the policy
+func (a *Authoritative) minimizeAnyAnswer(query message.Query, result *resolution, transport string) {
+
+	if len(result.answers) == 0 {
+		return
+	}
+
+	// Stream transports (TCP, TLS, HTTPS and QUIC) verify the client address, so they can't be used for amplification
+	if a.fullAnyOverTCP && transport != TRANSPORT_UDP {
+		return
+	}
+
+	// Addresses of targets are useless without the records naming them
+	result.additional = nil
+
+	if a.anyPolicy == AnyPolicy__RRSet {
+		result.answers = result.answers[:1]
+		return
+	}
+
+	ttl := a.hinfoTtl
+	if ttl == 0 {
+		ttl = ANY_HINFO_TTL
+	}
+
+	hinfo := record.NewRRSet(query.Name, record.ResourceRecordType__HINFO, query.ResourceRecordClass, ttl)
+	_ = hinfo.Add(record.NewHINFORecord(query.Name, query.ResourceRecordClass, "RFC8482", ""), ttl)
+
+	result.answers = []*record.RRSet{hinfo}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+// Answers queries for names in zones stored in the repository. Queries outside of them are passed to the next handler
+type Authoritative struct {
+	repository     managementserver.RecordsRepository
+	synthesizePTR  bool
+	anyPolicy      AnyPolicy
+	fullAnyOverTCP bool
+	hinfoTtl       uint32
+	next           Handler
+}
+
+func NewAuthoritative(repository managementserver.RecordsRepository, config *Config, next Handler) *Authoritative {
+	return &Authoritative{
+		repository:     repository,
+		synthesizePTR:  config.SynthesizePTR,
+		anyPolicy:      config.AnyPolicy,
+		fullAnyOverTCP: config.FullAnyOverTCP,
+		hinfoTtl:       config.TTL.AnyHinfo,
+		next:           next,
+	}
+}
+
+func newAuthoritativePlugin(s *Server) (Middleware, error) {
+	return func(next Handler) Handler {
+		return NewAuthoritative(s.repository, s.config, next)
+	}, nil
+}
+
+func (a *Authoritative) ServeDNS(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+	// Records sent by the client must not be echoed back
+	msg.ResetRRs()
+
+	if msg.Header.Flags.OperationCode != message.OpCode__Query {
+		WriteError(w, msg, message.ResponseCode__NotImp)
+		return
+	}
+
+	for _, query := range msg.Body.Queries {
+		result, err := a.resolve(ctx, query)
+		if err != nil {
+			slog.Error("Failed to resolve", "query", query, "err", err)
+			WriteError(w, msg, message.ResponseCode__ServFail)
+			return
+		}
+
+		// Names outside of local zones are left to the rest of the chain
+		if result.responseCode == message.ResponseCode__Refused {
+			msg.ResetRRs()
+			a.nextHandler().ServeDNS(ctx, w, msg)
+			return
+		}
+
+		if query.ResourceRecordType == record.ResourceRecordType__ANY {
+			a.minimizeAnyAnswer(query, result, w.Transport())
+		}
+
+		for _, set := range result.answers {
+			msg.AddAnswerRRSet(set)
+		}
+
+		for _, set := range result.authority {
+			msg.AddAuthorativeRRSet(set)
+		}
+
+		for _, set := range result.additional {
+			msg.AddAdditionalRRSet(set)
+		}
+
+		msg.SetAuthorative(result.authoritative)
+		msg.SetResponseCode(result.responseCode)
+	}
+
+	msg.SetAsResponse()
+	msg.UpdateRRNumbers()
+
+	w.WriteMsg(msg)
+}
+
+// Returns records of the view selected for the request
+func (a *Authoritative) records(ctx context.Context) managementserver.RecordsRepository {
+	return a.repository.ForView(ViewFromContext(ctx))
+}
+
+func (a *Authoritative) nextHandler() Handler {
+	if a.next == nil {
+		return RefusedHandler
+	}
+	return a.next
+}
@@ -0,0 +1,451 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+const (
+	// Megabytes of memory cached responses may take
+	CACHE_MAX_MEMORY_KEY = "DNS_CACHE_MAX_MEMORY"
+
+	// Bounds of TTLs of cached responses in seconds, and the upper bound of negative responses
+	CACHE_MIN_TTL_KEY          = "DNS_CACHE_MIN_TTL"
+	CACHE_MAX_TTL_KEY          = "DNS_CACHE_MAX_TTL"
+	CACHE_MAX_NEGATIVE_TTL_KEY = "DNS_CACHE_MAX_NEGATIVE_TTL"
+)
+
+const (
+	DEFAULT_CACHE_MAX_MEMORY = 64
+	DEFAULT_CACHE_MAX_TTL    = 86400
+
+	// Negative responses are cached for at most 1 to 3 hours (RFC 2308 §5)
+	DEFAULT_CACHE_MAX_NEGATIVE_TTL = 3600
+)
+
+// Number of independently locked parts of the cache, each with its own share of the memory
+const CACHE_SHARDS = 16
+
+// Estimated memory taken by cached response and each of its records, besides names and data
+const (
+	CACHE_ENTRY_OVERHEAD  = 256
+	CACHE_RECORD_OVERHEAD = 64
+)
+
+type CacheConfig struct {
+	// Megabytes of memory cached responses may take, least recently used ones are evicted above it
+	MaxMemory int `yaml:"max_memory" toml:"max_memory"`
+
+	// Seconds TTLs of cached records are raised or lowered to
+	MinTTL int `yaml:"min_ttl" toml:"min_ttl"`
+	MaxTTL int `yaml:"max_ttl" toml:"max_ttl"`
+
+	// Seconds NXDOMAIN and NODATA responses are cached for at most, 0 disables negative caching
+	MaxNegativeTTL int `yaml:"max_negative_ttl" toml:"max_negative_ttl"`
+}
+
+func (c *CacheConfig) Validate() error {
+
+	errs := make([]error, 0)
+
+	if c.MaxMemory <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid cache.max_memory: %d, expected a positive number of megabytes", c.MaxMemory)))
+	}
+
+	if c.MinTTL < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid cache.min_ttl: %d, expected 0 or more seconds", c.MinTTL)))
+	}
+
+	if c.MaxTTL <= 0 || c.MaxTTL < c.MinTTL {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid cache.max_ttl: %d, expected a positive number of seconds, not lower than cache.min_ttl", c.MaxTTL)))
+	}
+
+	if c.MaxNegativeTTL < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid cache.max_negative_ttl: %d, expected 0 or more seconds", c.MaxNegativeTTL)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Identifies cached response by the question and the DO bit, as responses with DNSSEC records differ from ones without them
+type cacheKey struct {
+	name       string
+	recordType record.ResourceRecordType
+	class      record.ResourceRecordClass
+	dnssecOk   bool
+}
+
+func newCacheKey(msg *message.Message) cacheKey {
+
+	query := msg.Body.Queries[0]
+	key := cacheKey{
+		name:       strings.ToLower(strings.Join(query.Name, ".")),
+		recordType: query.ResourceRecordType,
+		class:      query.ResourceRecordClass,
+	}
+
+	if edns, err := msg.EDNS(); err == nil && edns != nil {
+		key.dnssecOk = edns.DNSSECOk
+	}
+
+	return key
+}
+
+func (k cacheKey) shard() int {
+	hash := fnv.New32a()
+	hash.Write([]byte(k.name))
+	hash.Write(binary.BigEndian.AppendUint16(nil, uint16(k.recordType)))
+	return int(hash.Sum32() % CACHE_SHARDS)
+}
+
+// Response stored with TTLs of its records at the time it was stored
+type cacheEntry struct {
+	key  cacheKey
+	name []string
+
+	code       message.ResponseCode
+	answers    []message.Answer
+	authority  []message.Answer
+	additional []message.Answer
+
+	stored  time.Time
+	expires time.Time
+	size    int
+}
+
+// Returns records of the section with TTLs decreased by the time spent in the cache
+func decayed(section []message.Answer, elapsed uint32) []message.Answer {
+
+	result := make([]message.Answer, 0, len(section))
+	for _, answer := range section {
+		answer.Ttl -= min(answer.Ttl, elapsed)
+		result = append(result, answer)
+	}
+
+	return result
+}
+
+type cacheShard struct {
+	lock    sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	size    int
+	maxSize int
+}
+
+// Least recently used responses of the recursive and forward plugins, split into shards.
+// Positive responses live as long as their shortest TTL, negative ones as long as TTL of the SOA (RFC 2308 §5),
+// and TTLs sent to clients decrease with the time responses spent in the cache
+type Cache struct {
+	shards [CACHE_SHARDS]*cacheShard
+
+	minTTL         uint32
+	maxTTL         uint32
+	maxNegativeTTL uint32
+
+	// Whether the client is allowed to use recursion, other clients are not served from the cache
+	available func(remote net.Addr) bool
+
+	metrics *Metrics
+	now     func() time.Time
+}
+
+func NewCache(config CacheConfig, available func(remote net.Addr) bool) *Cache {
+
+	cache := &Cache{
+		minTTL:         uint32(config.MinTTL),
+		maxTTL:         uint32(config.MaxTTL),
+		maxNegativeTTL: uint32(config.MaxNegativeTTL),
+		available:      available,
+		now:            time.Now,
+	}
+
+	for i := range cache.shards {
+		cache.shards[i] = &cacheShard{
+			entries: make(map[cacheKey]*list.Element),
+			lru:     list.New(),
+			maxSize: config.MaxMemory * 1024 * 1024 / CACHE_SHARDS,
+		}
+	}
+
+	return cache
+}
+
+func newCachePlugin(s *Server) (Middleware, error) {
+
+	cache := NewCache(s.config.Cache, s.recursionAvailable)
+	cache.metrics = s.metrics
+
+	if source, ok := s.repositorySource().(managementserver.CacheFlushSource); ok {
+		s.listeners.Add(1)
+		go func() {
+			defer s.listeners.Done()
+			cache.watch(s.stopContext(), source)
+		}()
+	}
+
+	return cache.Middleware, nil
+}
+
+// Returns response cached for the key with decayed TTLs, or nil when there is none or it expired
+func (c *Cache) get(key cacheKey) *cacheEntry {
+
+	shard := c.shards[key.shard()]
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	element, ok := shard.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*cacheEntry)
+	now := c.now()
+
+	if !now.Before(entry.expires) {
+		shard.remove(element)
+		return nil
+	}
+
+	shard.lru.MoveToFront(element)
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+
+	return &cacheEntry{
+		code:       entry.code,
+		answers:    decayed(entry.answers, elapsed),
+		authority:  decayed(entry.authority, elapsed),
+		additional: decayed(entry.additional, elapsed),
+	}
+}
+
+// Stores the response if it can be cached, evicting the least recently used responses above the memory limit of the shard
+func (c *Cache) set(key cacheKey, response *message.Message) {
+
+	entry := c.newEntry(key, response)
+	if entry == nil {
+		return
+	}
+
+	shard := c.shards[key.shard()]
+	if entry.size > shard.maxSize {
+		return
+	}
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if element, ok := shard.entries[key]; ok {
+		shard.remove(element)
+	}
+
+	shard.entries[key] = shard.lru.PushFront(entry)
+	shard.size += entry.size
+
+	for shard.size > shard.maxSize {
+		shard.remove(shard.lru.Back())
+	}
+}
+
+func (s *cacheShard) remove(element *list.Element) {
+	entry := s.lru.Remove(element).(*cacheEntry)
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+}
+
+// Builds entry of the response with clamped TTLs, or returns nil when the response can't be cached:
+// it is neither an answer, NXDOMAIN nor NODATA, or it is negative without SOA to take its TTL from (RFC 2308 §5)
+func (c *Cache) newEntry(key cacheKey, response *message.Message) *cacheEntry {
+
+	entry := &cacheEntry{
+		key:        key,
+		name:       append([]string{}, response.Body.Queries[0].Name...),
+		code:       response.Header.Flags.ResponseCode,
+		answers:    c.copySection(response.Body.Answers),
+		authority:  c.copySection(response.Body.Authorative),
+		additional: c.copySection(response.Body.Additional),
+		stored:     c.now(),
+	}
+
+	var ttl uint32
+
+	switch {
+	case entry.code == message.ResponseCode__NoError && len(entry.answers) > 0:
+		ttl = c.maxTTL
+		for _, section := range [][]message.Answer{entry.answers, entry.authority} {
+			for _, answer := range section {
+				ttl = min(ttl, answer.Ttl)
+			}
+		}
+
+	case entry.code == message.ResponseCode__NoError || entry.code == message.ResponseCode__NxDomain:
+		negativeTTL, ok := c.negativeTTL(entry.authority)
+		if !ok {
+			return nil
+		}
+		ttl = negativeTTL
+
+		// TTLs of records of negative responses don't exceed the one of the response (RFC 2308 §3)
+		for i := range entry.authority {
+			entry.authority[i].Ttl = min(entry.authority[i].Ttl, ttl)
+		}
+
+	default:
+		return nil
+	}
+
+	if ttl == 0 {
+		return nil
+	}
+
+	entry.expires = entry.stored.Add(time.Duration(ttl) * time.Second)
+
+	entry.size = CACHE_ENTRY_OVERHEAD + len(entry.key.name)
+	for _, section := range [][]message.Answer{entry.answers, entry.authority, entry.additional} {
+		for _, answer := range section {
+			entry.size += CACHE_RECORD_OVERHEAD + len(answer.RData)
+			for _, label := range answer.Name {
+				entry.size += len(label)
+			}
+		}
+	}
+
+	return entry
+}
+
+// Copies records of the section with clamped TTLs, leaving the OPT record out. Data of the records
+// is copied as well, as decoded messages can share it with buffers which are reused
+func (c *Cache) copySection(section []message.Answer) []message.Answer {
+
+	result := make([]message.Answer, 0, len(section))
+	for _, answer := range section {
+		if answer.ResourceRecordType == record.ResourceRecordType__OPT {
+			continue
+		}
+
+		answer.Name = append([]string{}, answer.Name...)
+		answer.RData = append([]byte{}, answer.RData...)
+		answer.Ttl = min(max(answer.Ttl, c.minTTL), c.maxTTL)
+
+		result = append(result, answer)
+	}
+
+	return result
+}
+
+// Returns TTL of negative response, the lower of TTL of the SOA and its MINIMUM field (RFC 2308 §5)
+func (c *Cache) negativeTTL(authority []message.Answer) (uint32, bool) {
+
+	for _, answer := range authority {
+		if answer.ResourceRecordType != record.ResourceRecordType__SOA || len(answer.RData) < 20 {
+			continue
+		}
+
+		minimum := binary.BigEndian.Uint32(answer.RData[len(answer.RData)-4:])
+		return min(max(min(answer.Ttl, minimum), c.minTTL), c.maxNegativeTTL), true
+	}
+
+	return 0, false
+}
+
+// Removes responses to queries for the name, and for names below it when subtree is set
+func (c *Cache) Flush(name []string, subtree bool) int {
+
+	flushed := 0
+
+	for _, shard := range c.shards {
+		shard.lock.Lock()
+		for _, element := range shard.entries {
+			entry := element.Value.(*cacheEntry)
+			if record.IsSubdomain(entry.name, name) && (subtree || len(entry.name) == len(name)) {
+				shard.remove(element)
+				flushed++
+			}
+		}
+		shard.lock.Unlock()
+	}
+
+	return flushed
+}
+
+// Flushes the cache as requested through the management API, until the context is done
+func (c *Cache) watch(ctx context.Context, source managementserver.CacheFlushSource) {
+	watchWithBackoff(ctx, "cache flushes", func(listening func()) error {
+		return source.WatchCacheFlushes(
+			ctx,
+			func() {
+				listening()
+
+				// Flushes requested while no one was listening can't be told apart, so everything is flushed
+				c.Flush([]string{}, true)
+			},
+			func(flush managementserver.CacheFlush) {
+				flushed := c.Flush(flush.NameLabels(), flush.Subtree)
+				slog.Info("Flushed cache", "name", flush.Name, "subtree", flush.Subtree, "responses", flushed)
+			},
+		)
+	})
+}
+
+// Answers queries of clients allowed to use recursion from the cache, and caches responses the rest of the chain sent to them.
+// Authoritative answers and responses of forward rules with no_cache are not cached
+func (c *Cache) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+		if !msg.Header.Flags.RecursionDesired || msg.Header.Flags.OperationCode != message.OpCode__Query ||
+			len(msg.Body.Queries) != 1 || !c.available(w.RemoteAddr()) {
+			next.ServeDNS(ctx, w, msg)
+			return
+		}
+
+		key := newCacheKey(msg)
+
+		if entry := c.get(key); entry != nil {
+			if c.metrics != nil {
+				c.metrics.countCacheHit()
+			}
+
+			msg.ResetRRs()
+			msg.Body.Answers = entry.answers
+			msg.Body.Authorative = entry.authority
+			msg.Body.Additional = entry.additional
+
+			msg.SetAsResponse()
+			msg.SetAuthorative(false)
+			msg.SetResponseCode(entry.code)
+			msg.UpdateRRNumbers()
+
+			w.WriteMsg(msg)
+			return
+		}
+
+		if c.metrics != nil {
+			c.metrics.countCacheMiss()
+		}
+
+		writer := &recordingWriter{ResponseWriter: w}
+		next.ServeDNS(ctx, writer, msg)
+
+		response := writer.response
+		if response == nil || CacheDisabled(ctx) || response.Header.Flags.AuthorativeAnswer ||
+			response.Header.Flags.Truncation || len(response.Body.Queries) != 1 {
+			return
+		}
+
+		c.set(key, response)
+	})
+}
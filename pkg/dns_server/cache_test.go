@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Handler answering like an upstream resolver: names starting with "missing" get NXDOMAIN with SOA,
+// "empty" NODATA without SOA, "broken" SERVFAIL and other names an address with TTL of 300 seconds
+type testResolver struct {
+	queries int
+	noCache bool
+}
+
+func (r *testResolver) ServeDNS(ctx context.Context, w ResponseWriter, msg *message.Message) {
+	r.queries++
+
+	if r.noCache {
+		DisableCache(ctx)
+	}
+
+	query := msg.Body.Queries[0]
+	msg.ResetRRs()
+
+	var in record.ResourceRecordClass = record.ResourceRecordClass__In
+
+	switch query.Name[0] {
+	case "missing":
+		msg.SetResponseCode(message.ResponseCode__NxDomain)
+		soa := record.NewSOARecord([]string{"example", "org"}, in, []string{"ns1", "example", "org"}, []string{"admin", "example", "org"}, 1, 7200, 3600, 1209600, 600)
+		msg.AddAuthorative(soa)
+		msg.Body.Authorative[0].Ttl = 3600
+	case "empty":
+	case "broken":
+		msg.SetResponseCode(message.ResponseCode__ServFail)
+	default:
+		msg.AddAnswer(record.NewARecord(query.Name, in, net.IPv4(192, 0, 2, 1)))
+		msg.Body.Answers[0].Ttl = 300
+	}
+
+	msg.SetAsResponse()
+	msg.UpdateRRNumbers()
+	w.WriteMsg(msg)
+}
+
+func newTestCache(config CacheConfig, now *time.Time) *Cache {
+	cache := NewCache(config, func(remote net.Addr) bool { return true })
+	cache.now = func() time.Time { return *now }
+	return cache
+}
+
+func queryCache(t *testing.T, handler Handler, name string, dnssecOk bool) *message.Message {
+	t.Helper()
+
+	msg := newTestMessage(strings.Split(name, "."), record.ResourceRecordType__A)
+	msg.Header.Flags.RecursionDesired = true
+	if dnssecOk {
+		msg.SetEDNS(&message.EDNS{UDPPayloadSize: 1232, DNSSECOk: true})
+	}
+
+	var noCache bool
+	writer := &testResponseWriter{}
+	handler.ServeDNS(withNoCache(context.Background(), &noCache), writer, msg)
+
+	assert.NotNil(t, writer.response)
+	return writer.response
+}
+
+func TestCache_Middleware(t *testing.T) {
+	testCases := []struct {
+		name            string
+		query           string
+		config          func(c *CacheConfig)
+		noCache         bool
+		after           time.Duration
+		expectedQueries int
+		expectedTTL     uint32
+	}{
+		{
+			name:            "Answer should be served from the cache with decayed TTL",
+			query:           "www.example.org",
+			after:           100 * time.Second,
+			expectedQueries: 1,
+			expectedTTL:     200,
+		},
+		{
+			name:            "Expired answer should be resolved again",
+			query:           "www.example.org",
+			after:           300 * time.Second,
+			expectedQueries: 2,
+			expectedTTL:     300,
+		},
+		{
+			name:            "TTL should be raised to min_ttl",
+			query:           "www.example.org",
+			config:          func(c *CacheConfig) { c.MinTTL = 900 },
+			after:           600 * time.Second,
+			expectedQueries: 1,
+			expectedTTL:     300,
+		},
+		{
+			name:            "TTL should be lowered to max_ttl",
+			query:           "www.example.org",
+			config:          func(c *CacheConfig) { c.MaxTTL = 60 },
+			after:           60 * time.Second,
+			expectedQueries: 2,
+			expectedTTL:     300,
+		},
+		{
+			name:            "NXDOMAIN should be cached for the SOA minimum, lower than TTL of the SOA",
+			query:           "missing.example.org",
+			after:           500 * time.Second,
+			expectedQueries: 1,
+			expectedTTL:     100,
+		},
+		{
+			name:            "NXDOMAIN should expire after the SOA minimum",
+			query:           "missing.example.org",
+			after:           600 * time.Second,
+			expectedQueries: 2,
+			expectedTTL:     3600,
+		},
+		{
+			name:            "NXDOMAIN should be cached for at most max_negative_ttl",
+			query:           "missing.example.org",
+			config:          func(c *CacheConfig) { c.MaxNegativeTTL = 60 },
+			after:           60 * time.Second,
+			expectedQueries: 2,
+			expectedTTL:     3600,
+		},
+		{
+			name:            "NODATA without SOA should not be cached",
+			query:           "empty.example.org",
+			expectedQueries: 2,
+		},
+		{
+			name:            "SERVFAIL should not be cached",
+			query:           "broken.example.org",
+			expectedQueries: 2,
+		},
+		{
+			name:            "Response marked as not to be cached should not be cached",
+			query:           "www.example.org",
+			noCache:         true,
+			expectedQueries: 2,
+			expectedTTL:     300,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := DefaultConfig().Cache
+			if tc.config != nil {
+				tc.config(&config)
+			}
+
+			now := time.Unix(1700000000, 0)
+			resolver := &testResolver{noCache: tc.noCache}
+			handler := newTestCache(config, &now).Middleware(resolver)
+
+			queryCache(t, handler, tc.query, false)
+			now = now.Add(tc.after)
+			response := queryCache(t, handler, tc.query, false)
+
+			assert.Equal(t, tc.expectedQueries, resolver.queries)
+			assert.False(t, response.Header.Flags.AuthorativeAnswer)
+
+			records := append(response.Body.Answers, response.Body.Authorative...)
+			if tc.expectedTTL != 0 {
+				assert.Len(t, records, 1)
+				assert.Equal(t, tc.expectedTTL, records[0].Ttl)
+			}
+		})
+	}
+}
+
+func TestCache_key(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	resolver := &testResolver{}
+	handler := newTestCache(DefaultConfig().Cache, &now).Middleware(resolver)
+
+	queryCache(t, handler, "www.example.org", false)
+	queryCache(t, handler, "WWW.Example.org", false)
+	assert.Equal(t, 1, resolver.queries, "names should be compared ignoring case")
+
+	queryCache(t, handler, "www.example.org", true)
+	assert.Equal(t, 2, resolver.queries, "responses should be cached separately for queries with the DO bit")
+
+	msg := newTestMessage([]string{"www", "example", "org"}, record.ResourceRecordType__A)
+	handler.ServeDNS(context.Background(), &testResponseWriter{}, msg)
+	assert.Equal(t, 3, resolver.queries, "queries without the RD flag should be passed on")
+}
+
+func TestCache_memoryLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := newTestCache(DefaultConfig().Cache, &now)
+	handler := cache.Middleware(&testResolver{})
+
+	// Names of the same length falling into the same shard, which holds two responses
+	names := make([]string, 0, 3)
+	for i := 0; len(names) < 3; i++ {
+		name := fmt.Sprintf("host%03d.example.org", i)
+		if (cacheKey{name: name, recordType: record.ResourceRecordType__A}).shard() == 0 {
+			names = append(names, name)
+		}
+	}
+
+	queryCache(t, handler, names[0], false)
+	cache.shards[0].maxSize = 2 * cache.shards[0].size
+
+	queryCache(t, handler, names[1], false)
+	queryCache(t, handler, names[0], false)
+	queryCache(t, handler, names[2], false)
+
+	assert.LessOrEqual(t, cache.shards[0].size, cache.shards[0].maxSize)
+	assert.Len(t, cache.shards[0].entries, 2)
+
+	var in record.ResourceRecordClass = record.ResourceRecordClass__In
+	for i, expected := range []bool{true, false, true} {
+		_, ok := cache.shards[0].entries[cacheKey{name: names[i], recordType: record.ResourceRecordType__A, class: in}]
+		assert.Equal(t, expected, ok, "the least recently used response should be evicted")
+	}
+}
+
+// Source delivering flushes sent on the channel
+type testCacheFlushSource struct {
+	flushes chan managementserver.CacheFlush
+}
+
+func (s *testCacheFlushSource) WatchCacheFlushes(ctx context.Context, listening func(), flushed func(flush managementserver.CacheFlush)) error {
+	listening()
+	for {
+		select {
+		case flush := <-s.flushes:
+			flushed(flush)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestCache_Flush(t *testing.T) {
+	testCases := []struct {
+		name     string
+		flush    managementserver.CacheFlush
+		expected map[string]bool
+	}{
+		{
+			name:     "Flush of a name should remove only responses for the name",
+			flush:    managementserver.CacheFlush{Name: "Example.org."},
+			expected: map[string]bool{"example.org": false, "www.example.org": true, "a.b.example.org": true, "example.com": true},
+		},
+		{
+			name:     "Flush of a subtree should remove responses for the name and names below it",
+			flush:    managementserver.CacheFlush{Name: "example.org", Subtree: true},
+			expected: map[string]bool{"example.org": false, "www.example.org": false, "a.b.example.org": false, "example.com": true},
+		},
+		{
+			name:     "Flush of the root subtree should remove every response",
+			flush:    managementserver.CacheFlush{Name: ".", Subtree: true},
+			expected: map[string]bool{"example.org": false, "www.example.org": false, "a.b.example.org": false, "example.com": false},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			cache := newTestCache(DefaultConfig().Cache, &now)
+
+			source := &testCacheFlushSource{flushes: make(chan managementserver.CacheFlush)}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				cache.watch(ctx, source)
+				close(done)
+			}()
+
+			// Whole cache is flushed once listening starts
+			source.flushes <- managementserver.CacheFlush{Name: "unrelated.test"}
+
+			resolver := &testResolver{}
+			handler := cache.Middleware(resolver)
+			for name := range tc.expected {
+				queryCache(t, handler, name, false)
+			}
+
+			// Flushes are delivered one at a time, so the first one has been applied once the second is received
+			source.flushes <- tc.flush
+			source.flushes <- managementserver.CacheFlush{Name: "unrelated.test"}
+			cancel()
+			<-done
+
+			for name, cached := range tc.expected {
+				queries := resolver.queries
+				queryCache(t, handler, name, false)
+				assert.Equal(t, cached, resolver.queries == queries, name)
+			}
+		})
+	}
+}
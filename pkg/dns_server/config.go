@@ -0,0 +1,665 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	client "github.com/XxRoloxX/dns/pkg/dns_client"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Path to YAML or TOML configuration file, used when no -config flag is given
+	CONFIG_FILE_KEY = "DNS_CONFIG_FILE"
+
+	// Listen addresses of transports
+	LISTEN_UDP_KEY   = "DNS_LISTEN_UDP"
+	LISTEN_TCP_KEY   = "DNS_LISTEN_TCP"
+	LISTEN_TLS_KEY   = "DNS_LISTEN_TLS"
+	LISTEN_HTTPS_KEY = "DNS_LISTEN_HTTPS"
+	LISTEN_QUIC_KEY  = "DNS_LISTEN_QUIC"
+
+	// Storage of the records, see Backend
+	BACKEND_KEY = "DNS_BACKEND"
+
+	// TTL of zone records defined in the configuration without one
+	DEFAULT_TTL_KEY = "DNS_DEFAULT_TTL"
+
+	// Minimum level of logged messages: debug, info, warn or error
+	LOG_LEVEL_KEY = "DNS_LOG_LEVEL"
+
+	// Seconds given to requests in flight to complete when the server shuts down
+	SHUTDOWN_TIMEOUT_KEY = "DNS_SHUTDOWN_TIMEOUT"
+
+	// Number of UDP sockets bound to the listen address with SO_REUSEPORT
+	UDP_SOCKETS_KEY = "DNS_UDP_SOCKETS"
+)
+
+// Storage the server reads records from
+type Backend string
+
+const (
+	// Records managed by the management server
+	Backend__Postgres Backend = "postgres"
+
+	// Records of zones defined in the configuration
+	Backend__Memory Backend = "memory"
+)
+
+type ListenConfig struct {
+	UDP   string `yaml:"udp" toml:"udp"`
+	TCP   string `yaml:"tcp" toml:"tcp"`
+	TLS   string `yaml:"tls" toml:"tls"`
+	HTTPS string `yaml:"https" toml:"https"`
+	QUIC  string `yaml:"quic" toml:"quic"`
+}
+
+// Certificate of encrypted transports, which are enabled only when both files are set
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+}
+
+type BackendConfig struct {
+	Type     Backend                         `yaml:"type" toml:"type"`
+	Postgres managementserver.PostgresConfig `yaml:"postgres" toml:"postgres"`
+
+	// In-memory copy of the records of the postgres backend
+	Snapshot SnapshotConfig `yaml:"snapshot" toml:"snapshot"`
+}
+
+// Record of a zone defined in the configuration. Names are relative to the zone
+// unless they end with a dot, "@" stands for the zone apex
+type ZoneRecordConfig struct {
+	Name  string `yaml:"name" toml:"name"`
+	Type  string `yaml:"type" toml:"type"`
+	Class string `yaml:"class" toml:"class"`
+	Ttl   uint32 `yaml:"ttl" toml:"ttl"`
+	Data  string `yaml:"data" toml:"data"`
+}
+
+type ZoneConfig struct {
+	Name    string             `yaml:"name" toml:"name"`
+	Records []ZoneRecordConfig `yaml:"records" toml:"records"`
+
+	// Answers PTR queries within the zone with records synthesized from A/AAAA records
+	SynthesizePTR bool `yaml:"synthesize_ptr" toml:"synthesize_ptr"`
+
+	// View the zone is served in, empty for the default view
+	View string `yaml:"view" toml:"view"`
+}
+
+type TTLConfig struct {
+	// TTL of zone records defined without one
+	Default uint32 `yaml:"default" toml:"default"`
+
+	// TTL of the HINFO record synthesized in response to ANY queries
+	AnyHinfo uint32 `yaml:"any_hinfo" toml:"any_hinfo"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level"`
+}
+
+type LimitsConfig struct {
+	// Seconds after which idle TCP, TLS and QUIC connections are closed
+	TCPIdleTimeout int `yaml:"tcp_idle_timeout" toml:"tcp_idle_timeout"`
+
+	// Maximum number of concurrent connections from single client address
+	TCPMaxConnectionsPerClient int `yaml:"tcp_max_connections_per_client" toml:"tcp_max_connections_per_client"`
+
+	// Seconds given to requests in flight to complete when the server shuts down
+	ShutdownTimeout int `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// Number of workers handling requests and number of requests waiting for them
+	Workers   int `yaml:"workers" toml:"workers"`
+	QueueSize int `yaml:"queue_size" toml:"queue_size"`
+
+	// Response to requests shed when the queue is full
+	OverloadAction OverloadAction `yaml:"overload_action" toml:"overload_action"`
+
+	// Number of UDP sockets bound to the same address, spreading packets between cores
+	UDPSockets int `yaml:"udp_sockets" toml:"udp_sockets"`
+}
+
+type Config struct {
+	Listen         ListenConfig    `yaml:"listen" toml:"listen"`
+	TLS            TLSConfig       `yaml:"tls" toml:"tls"`
+	Backend        BackendConfig   `yaml:"backend" toml:"backend"`
+	Zones          []ZoneConfig    `yaml:"zones" toml:"zones"`
+	TTL            TTLConfig       `yaml:"ttl" toml:"ttl"`
+	Log            LogConfig       `yaml:"log" toml:"log"`
+	Limits         LimitsConfig    `yaml:"limits" toml:"limits"`
+	RRL            RRLConfig       `yaml:"rrl" toml:"rrl"`
+	ACL            ACLConfig       `yaml:"acl" toml:"acl"`
+	Views          []ViewConfig    `yaml:"views" toml:"views"`
+	Recursion      RecursionConfig `yaml:"recursion" toml:"recursion"`
+	Forward        ForwardConfig   `yaml:"forward" toml:"forward"`
+	Cache          CacheConfig     `yaml:"cache" toml:"cache"`
+	Plugins        []string        `yaml:"plugins" toml:"plugins"`
+	SynthesizePTR  bool            `yaml:"synthesize_ptr" toml:"synthesize_ptr"`
+	AnyPolicy      AnyPolicy       `yaml:"any_policy" toml:"any_policy"`
+	FullAnyOverTCP bool            `yaml:"full_any_over_tcp" toml:"full_any_over_tcp"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Listen: ListenConfig{
+			UDP:   ":53",
+			TCP:   ":53",
+			TLS:   DOT_ADDRESS,
+			HTTPS: DOH_ADDRESS,
+			QUIC:  DOQ_ADDRESS,
+		},
+		Backend: BackendConfig{
+			Type: Backend__Postgres,
+			Snapshot: SnapshotConfig{
+				Enabled:        true,
+				ResyncInterval: DEFAULT_SNAPSHOT_RESYNC_INTERVAL,
+			},
+		},
+		TTL: TTLConfig{
+			Default:  record.DEFAULT_TTL,
+			AnyHinfo: ANY_HINFO_TTL,
+		},
+		Log: LogConfig{Level: "info"},
+		Limits: LimitsConfig{
+			TCPIdleTimeout:             int(DEFAULT_TCP_IDLE_TIMEOUT.Seconds()),
+			TCPMaxConnectionsPerClient: DEFAULT_TCP_MAX_CONNECTIONS_PER_CLIENT,
+			ShutdownTimeout:            int(DEFAULT_SHUTDOWN_TIMEOUT.Seconds()),
+			Workers:                    DEFAULT_WORKERS,
+			QueueSize:                  DEFAULT_QUEUE_SIZE,
+			OverloadAction:             OverloadAction__Drop,
+			UDPSockets:                 1,
+		},
+		RRL: RRLConfig{
+			Window:           DEFAULT_RRL_WINDOW,
+			Slip:             DEFAULT_RRL_SLIP,
+			IPv4PrefixLength: DEFAULT_RRL_IPV4_PREFIX_LENGTH,
+			IPv6PrefixLength: DEFAULT_RRL_IPV6_PREFIX_LENGTH,
+			MaxTableSize:     DEFAULT_RRL_MAX_TABLE_SIZE,
+		},
+		ACL: ACLConfig{RefreshInterval: DEFAULT_ACL_REFRESH_INTERVAL},
+		Recursion: RecursionConfig{
+			Allow:         DEFAULT_RECURSION_ALLOW,
+			Timeout:       DEFAULT_RECURSION_TIMEOUT,
+			QueryTimeout:  int(client.DEFAULT_QUERY_TIMEOUT.Seconds()),
+			MaxReferrals:  client.DEFAULT_MAX_REFERRALS,
+			MaxCNAMEChain: client.DEFAULT_MAX_CNAME_CHAIN,
+			MaxDepth:      client.DEFAULT_MAX_DEPTH,
+		},
+		Forward: ForwardConfig{
+			Strategy:          ForwardStrategy__Random,
+			Timeout:           DEFAULT_FORWARD_TIMEOUT,
+			UnhealthyDuration: DEFAULT_FORWARD_UNHEALTHY_DURATION,
+			RefreshInterval:   DEFAULT_FORWARD_REFRESH_INTERVAL,
+		},
+		Cache: CacheConfig{
+			MaxMemory:      DEFAULT_CACHE_MAX_MEMORY,
+			MaxTTL:         DEFAULT_CACHE_MAX_TTL,
+			MaxNegativeTTL: DEFAULT_CACHE_MAX_NEGATIVE_TTL,
+		},
+		Plugins:   strings.Split(DEFAULT_PLUGINS, ","),
+		AnyPolicy: AnyPolicy__Hinfo,
+	}
+}
+
+// Reads configuration from YAML or TOML file, chosen by its extension. Options missing from the file keep their defaults
+func LoadConfigFile(path string) (*Config, error) {
+
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to read config file: %s", err))
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, config)
+	case ".toml":
+		err = toml.Unmarshal(data, config)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported config file format: %s, expected .yaml, .yml or .toml", path))
+	}
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse config file %s: %s", path, err))
+	}
+
+	return config, nil
+}
+
+// Overrides options of the config with the ones set in the environment
+func (c *Config) ApplyEnv() error {
+
+	for key, value := range map[string]*string{
+		LISTEN_UDP_KEY:    &c.Listen.UDP,
+		LISTEN_TCP_KEY:    &c.Listen.TCP,
+		LISTEN_TLS_KEY:    &c.Listen.TLS,
+		LISTEN_HTTPS_KEY:  &c.Listen.HTTPS,
+		LISTEN_QUIC_KEY:   &c.Listen.QUIC,
+		TLS_CERT_FILE_KEY: &c.TLS.CertFile,
+		TLS_KEY_FILE_KEY:  &c.TLS.KeyFile,
+		LOG_LEVEL_KEY:     &c.Log.Level,
+	} {
+		if env := os.Getenv(key); env != "" {
+			*value = env
+		}
+	}
+
+	if env := os.Getenv(BACKEND_KEY); env != "" {
+		c.Backend.Type = Backend(env)
+	}
+
+	c.Backend.Postgres.ApplyEnv()
+
+	if env := os.Getenv(FORWARD_STRATEGY_KEY); env != "" {
+		c.Forward.Strategy = ForwardStrategy(env)
+	}
+
+	if env := os.Getenv(ANY_POLICY_KEY); env != "" {
+		c.AnyPolicy = AnyPolicy(env)
+	}
+
+	if env := os.Getenv(OVERLOAD_ACTION_KEY); env != "" {
+		c.Limits.OverloadAction = OverloadAction(env)
+	}
+
+	if env := os.Getenv(PLUGINS_KEY); env != "" {
+		c.Plugins = splitList(env)
+	}
+
+	if env := os.Getenv(RRL_EXEMPT_KEY); env != "" {
+		c.RRL.Exempt = splitList(env)
+	}
+
+	if env := os.Getenv(RECURSION_ROOT_HINTS_KEY); env != "" {
+		c.Recursion.RootHints = splitList(env)
+	}
+
+	if env := os.Getenv(RECURSION_ALLOW_KEY); env != "" {
+		c.Recursion.Allow = splitList(env)
+	}
+
+	if env := os.Getenv(FORWARD_UPSTREAMS_KEY); env != "" {
+		c.Forward.Upstreams = splitList(env)
+	}
+
+	for key, value := range map[string]*bool{
+		SYNTHESIZE_PTR_KEY:    &c.SynthesizePTR,
+		FULL_ANY_OVER_TCP_KEY: &c.FullAnyOverTCP,
+		SNAPSHOT_KEY:          &c.Backend.Snapshot.Enabled,
+	} {
+		if env := os.Getenv(key); env != "" {
+			parsed, err := strconv.ParseBool(env)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Invalid value of %s: %s, expected true or false", key, env))
+			}
+			*value = parsed
+		}
+	}
+
+	for key, value := range map[string]*int{
+		TCP_IDLE_TIMEOUT_KEY:               &c.Limits.TCPIdleTimeout,
+		TCP_MAX_CONNECTIONS_PER_CLIENT_KEY: &c.Limits.TCPMaxConnectionsPerClient,
+		SHUTDOWN_TIMEOUT_KEY:               &c.Limits.ShutdownTimeout,
+		WORKERS_KEY:                        &c.Limits.Workers,
+		QUEUE_SIZE_KEY:                     &c.Limits.QueueSize,
+		UDP_SOCKETS_KEY:                    &c.Limits.UDPSockets,
+		RRL_RESPONSES_PER_SECOND_KEY:       &c.RRL.ResponsesPerSecond,
+		RRL_WINDOW_KEY:                     &c.RRL.Window,
+		RRL_SLIP_KEY:                       &c.RRL.Slip,
+		RRL_LEAK_KEY:                       &c.RRL.Leak,
+		RRL_MAX_TABLE_SIZE_KEY:             &c.RRL.MaxTableSize,
+		ACL_REFRESH_INTERVAL_KEY:           &c.ACL.RefreshInterval,
+		SNAPSHOT_RESYNC_INTERVAL_KEY:       &c.Backend.Snapshot.ResyncInterval,
+		RECURSION_TIMEOUT_KEY:              &c.Recursion.Timeout,
+		FORWARD_TIMEOUT_KEY:                &c.Forward.Timeout,
+		FORWARD_REFRESH_INTERVAL_KEY:       &c.Forward.RefreshInterval,
+		CACHE_MAX_MEMORY_KEY:               &c.Cache.MaxMemory,
+		CACHE_MIN_TTL_KEY:                  &c.Cache.MinTTL,
+		CACHE_MAX_TTL_KEY:                  &c.Cache.MaxTTL,
+		CACHE_MAX_NEGATIVE_TTL_KEY:         &c.Cache.MaxNegativeTTL,
+	} {
+		if env := os.Getenv(key); env != "" {
+			parsed, err := strconv.Atoi(env)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Invalid value of %s: %s, expected a number", key, env))
+			}
+			*value = parsed
+		}
+	}
+
+	if env := os.Getenv(DEFAULT_TTL_KEY); env != "" {
+		parsed, err := strconv.ParseUint(env, 10, 32)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid value of %s: %s, expected a number", DEFAULT_TTL_KEY, env))
+		}
+		c.TTL.Default = uint32(parsed)
+	}
+
+	return nil
+}
+
+// Checks the config, reporting all invalid options at once
+func (c *Config) Validate() error {
+
+	errs := make([]error, 0)
+
+	if _, err := c.LogLevel(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Listen.UDP == "" && c.Listen.TCP == "" && c.Listen.TLS == "" && c.Listen.HTTPS == "" && c.Listen.QUIC == "" {
+		errs = append(errs, errors.New("No listen address configured"))
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("Both tls.cert_file and tls.key_file have to be set to enable encrypted transports"))
+	}
+
+	if _, err := NewAnyPolicy(string(c.AnyPolicy)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Limits.TCPIdleTimeout < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid limits.tcp_idle_timeout: %d, expected 0 or more seconds", c.Limits.TCPIdleTimeout)))
+	}
+
+	if c.Limits.TCPMaxConnectionsPerClient <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid limits.tcp_max_connections_per_client: %d, expected a positive number", c.Limits.TCPMaxConnectionsPerClient)))
+	}
+
+	if c.Limits.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid limits.shutdown_timeout: %d, expected a positive number of seconds", c.Limits.ShutdownTimeout)))
+	}
+
+	if c.Limits.Workers <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid limits.workers: %d, expected a positive number", c.Limits.Workers)))
+	}
+
+	if c.Limits.QueueSize < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid limits.queue_size: %d, expected 0 or more", c.Limits.QueueSize)))
+	}
+
+	if _, err := NewOverloadAction(string(c.Limits.OverloadAction)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Limits.UDPSockets <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid limits.udp_sockets: %d, expected a positive number", c.Limits.UDPSockets)))
+	}
+
+	if c.Limits.UDPSockets > 1 && !reusePortSupported {
+		errs = append(errs, errors.New("Multiple UDP sockets require SO_REUSEPORT, which is not supported on this platform"))
+	}
+
+	if err := c.RRL.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ACL.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Backend.Snapshot.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Recursion.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Forward.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Cache.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Rules can be managed through the management API only with the postgres backend
+	if slices.Contains(c.Plugins, "forward") && len(c.Forward.Upstreams) == 0 && len(c.Forward.Rules) == 0 && c.Backend.Type != Backend__Postgres {
+		errs = append(errs, errors.New("The forward plugin requires forward.upstreams or forward.rules"))
+	}
+
+	if err := validateViews(c.Views); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(c.Plugins) == 0 {
+		errs = append(errs, errors.New("No plugins configured"))
+	}
+
+	for _, name := range c.Plugins {
+		if _, ok := plugins[name]; !ok {
+			errs = append(errs, errors.New(fmt.Sprintf("Unknown plugin: %s", name)))
+		}
+	}
+
+	switch c.Backend.Type {
+	case Backend__Postgres:
+		if len(c.Zones) > 0 {
+			errs = append(errs, errors.New("Zones can be defined in the config only with the memory backend"))
+		}
+	case Backend__Memory:
+		if _, err := c.ZoneRecords(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid backend: %s, expected postgres or memory", c.Backend.Type)))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) LogLevel() (slog.Level, error) {
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid log level: %s, expected debug, info, warn or error", c.Log.Level))
+	}
+
+	return level, nil
+}
+
+// Returns records of zones defined in the config, with absolute names and default TTL applied
+func (c *Config) ZoneRecords() ([]managementserver.ManagedDNSResourceRecord, error) {
+
+	records := make([]managementserver.ManagedDNSResourceRecord, 0)
+
+	for _, zone := range c.Zones {
+		origin := strings.TrimSuffix(zone.Name, ".")
+		if origin == "" {
+			return nil, errors.New("Zone without name")
+		}
+
+		if zone.View != managementserver.DEFAULT_VIEW && !c.hasView(zone.View) {
+			return nil, errors.New(fmt.Sprintf("Zone %s is served in undefined view %s", origin, zone.View))
+		}
+
+		hasSOA := false
+
+		for _, zoneRecord := range zone.Records {
+			rr := managementserver.ManagedDNSResourceRecord{
+				Name:  absoluteName(zoneRecord.Name, origin),
+				Type:  managementserver.ManagedDNSRecordType(strings.ToUpper(zoneRecord.Type)),
+				Class: managementserver.ManagedDNSRecordClass_IN,
+				Data:  zoneRecord.Data,
+				Ttl:   zoneRecord.Ttl,
+				View:  zone.View,
+			}
+
+			if zoneRecord.Class != "" {
+				rr.Class = managementserver.ManagedDNSRecordClass(strings.ToUpper(zoneRecord.Class))
+			}
+
+			if rr.Ttl == 0 {
+				rr.Ttl = c.TTL.Default
+			}
+
+			if _, err := rr.ConvertToResourceRecord(); err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid %s record %s in zone %s: %s", rr.Type, rr.Name, origin, err))
+			}
+
+			if rr.Type == managementserver.ManagedDNSRecordType_SOA && strings.EqualFold(rr.Name, origin) {
+				hasSOA = true
+				rr.SynthesizePTR = zone.SynthesizePTR
+			}
+
+			records = append(records, rr)
+		}
+
+		if !hasSOA {
+			return nil, errors.New(fmt.Sprintf("Zone %s has no SOA record at its apex", origin))
+		}
+	}
+
+	return records, nil
+}
+
+func (c *Config) hasView(name string) bool {
+	for _, view := range c.Views {
+		if view.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes name of the zone record absolute
+func absoluteName(name string, origin string) string {
+
+	if name == "" || name == "@" {
+		return origin
+	}
+
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+
+	return name + "." + origin
+}
+
+func splitList(value string) []string {
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Command line options of the DNS server
+type Options struct {
+	ConfigPath  string
+	CheckConfig bool
+
+	// Overrides applied on top of the config file and the environment, in order they were given
+	overrides []func(c *Config) error
+}
+
+func ParseOptions(name string, args []string) (*Options, error) {
+
+	options := &Options{}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&options.ConfigPath, "config", os.Getenv(CONFIG_FILE_KEY), "path to YAML or TOML configuration file")
+	flags.BoolVar(&options.CheckConfig, "check-config", false, "validate the configuration and exit")
+
+	override := func(name string, usage string, apply func(c *Config, value string) error) {
+		flags.Func(name, usage, func(value string) error {
+			options.overrides = append(options.overrides, func(c *Config) error { return apply(c, value) })
+			return nil
+		})
+	}
+
+	setString := func(field func(c *Config) *string) func(c *Config, value string) error {
+		return func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		}
+	}
+
+	override("listen-udp", "UDP listen address, empty to disable", setString(func(c *Config) *string { return &c.Listen.UDP }))
+	override("listen-tcp", "TCP listen address, empty to disable", setString(func(c *Config) *string { return &c.Listen.TCP }))
+	override("listen-tls", "DNS-over-TLS listen address, empty to disable", setString(func(c *Config) *string { return &c.Listen.TLS }))
+	override("listen-https", "DNS-over-HTTPS listen address, empty to disable", setString(func(c *Config) *string { return &c.Listen.HTTPS }))
+	override("listen-quic", "DNS-over-QUIC listen address, empty to disable", setString(func(c *Config) *string { return &c.Listen.QUIC }))
+	override("tls-cert-file", "PEM encoded certificate of encrypted transports", setString(func(c *Config) *string { return &c.TLS.CertFile }))
+	override("tls-key-file", "PEM encoded private key of encrypted transports", setString(func(c *Config) *string { return &c.TLS.KeyFile }))
+	override("log-level", "minimum level of logged messages: debug, info, warn or error", setString(func(c *Config) *string { return &c.Log.Level }))
+
+	override("backend", "storage of the records: postgres or memory", func(c *Config, value string) error {
+		c.Backend.Type = Backend(value)
+		return nil
+	})
+
+	override("plugins", "comma separated list of plugins handling requests", func(c *Config, value string) error {
+		c.Plugins = splitList(value)
+		return nil
+	})
+
+	override("default-ttl", "TTL of zone records defined without one", func(c *Config, value string) error {
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid value of -default-ttl: %s, expected a number", value))
+		}
+		c.TTL.Default = uint32(parsed)
+		return nil
+	})
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() > 0 {
+		return nil, errors.New(fmt.Sprintf("Unexpected arguments: %s", strings.Join(flags.Args(), " ")))
+	}
+
+	return options, nil
+}
+
+// Builds the config from defaults, the config file, the environment and command line flags, in order of precedence
+func (o *Options) Load() (*Config, error) {
+
+	config := DefaultConfig()
+
+	if o.ConfigPath != "" {
+		loaded, err := LoadConfigFile(o.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		config = loaded
+	}
+
+	if err := config.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, override := range o.overrides {
+		if err := override(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+const testYAMLConfig = `
+listen:
+  udp: "127.0.0.1:5353"
+  tls: ""
+backend:
+  type: memory
+ttl:
+  default: 600
+limits:
+  tcp_max_connections_per_client: 3
+acl:
+  rules:
+    - source: 10.0.0.0/8
+      type: ANY
+      action: refuse
+zones:
+  - name: example.com
+    records:
+      - name: "@"
+        type: SOA
+        data: "ns1.example.com. admin.example.com. 1 3600 600 86400 300"
+      - name: www
+        type: A
+        ttl: 60
+        data: "192.168.1.2"
+      - name: mail.example.net.
+        type: A
+        data: "192.168.1.3"
+  - name: 1.168.192.in-addr.arpa
+    synthesize_ptr: true
+    records:
+      - name: "@"
+        type: SOA
+        data: "ns1.example.com. admin.example.com. 1 3600 600 86400 300"
+`
+
+const testTOMLConfig = `
+plugins = ["log", "authoritative"]
+any_policy = "rrset"
+
+[listen]
+udp = "127.0.0.1:5353"
+
+[log]
+level = "debug"
+
+[backend.postgres]
+host = "db"
+`
+
+func writeTestConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	yamlConfig, err := LoadConfigFile(writeTestConfig(t, "dns.yaml", testYAMLConfig))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:5353", yamlConfig.Listen.UDP)
+	assert.Equal(t, "", yamlConfig.Listen.TLS)
+	assert.Equal(t, ":53", yamlConfig.Listen.TCP, "options missing from the file should keep defaults")
+	assert.Equal(t, Backend__Memory, yamlConfig.Backend.Type)
+	assert.Equal(t, 3, yamlConfig.Limits.TCPMaxConnectionsPerClient)
+	assert.Equal(t, []managementserver.ManagedACLRule{{Source: "10.0.0.0/8", Type: "ANY", Action: managementserver.ACLAction_Refuse}}, yamlConfig.ACL.Rules)
+	assert.NoError(t, yamlConfig.Validate())
+
+	tomlConfig, err := LoadConfigFile(writeTestConfig(t, "dns.toml", testTOMLConfig))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"log", "authoritative"}, tomlConfig.Plugins)
+	assert.Equal(t, AnyPolicy__RRSet, tomlConfig.AnyPolicy)
+	assert.Equal(t, "debug", tomlConfig.Log.Level)
+	assert.Equal(t, "db", tomlConfig.Backend.Postgres.Host)
+	assert.NoError(t, tomlConfig.Validate())
+
+	_, err = LoadConfigFile(writeTestConfig(t, "dns.ini", ""))
+	assert.Error(t, err)
+}
+
+func TestConfig_ZoneRecords(t *testing.T) {
+	config, err := LoadConfigFile(writeTestConfig(t, "dns.yaml", testYAMLConfig))
+	assert.NoError(t, err)
+
+	records, err := config.ZoneRecords()
+	assert.NoError(t, err)
+
+	names := make([]string, 0, len(records))
+	for _, rr := range records {
+		names = append(names, rr.Name)
+	}
+
+	assert.Equal(t, []string{"example.com", "www.example.com", "mail.example.net", "1.168.192.in-addr.arpa"}, names)
+	assert.Equal(t, managementserver.ManagedDNSRecordClass_IN, records[0].Class)
+	assert.False(t, records[0].SynthesizePTR)
+	assert.True(t, records[3].SynthesizePTR, "PTR synthesis of the zone should be enabled on its SOA")
+	assert.Equal(t, uint32(600), records[0].Ttl)
+	assert.Equal(t, uint32(60), records[1].Ttl)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{
+			name:   "Default config should be valid",
+			modify: func(c *Config) {},
+			valid:  true,
+		},
+		{
+			name:   "Unknown log level should be rejected",
+			modify: func(c *Config) { c.Log.Level = "verbose" },
+		},
+		{
+			name: "Disabling all transports should be rejected",
+			modify: func(c *Config) {
+				c.Listen = ListenConfig{}
+			},
+		},
+		{
+			name:   "Certificate without key should be rejected",
+			modify: func(c *Config) { c.TLS.CertFile = "cert.pem" },
+		},
+		{
+			name:   "Unknown plugin should be rejected",
+			modify: func(c *Config) { c.Plugins = []string{"log", "missing"} },
+		},
+		{
+			name: "ACL rule with invalid source should be rejected",
+			modify: func(c *Config) {
+				c.ACL.Rules = []managementserver.ManagedACLRule{{Source: "10.0.0.0/40", Action: managementserver.ACLAction_Drop}}
+			},
+		},
+		{
+			name: "Zone of undefined view should be rejected",
+			modify: func(c *Config) {
+				c.Backend.Type = Backend__Memory
+				c.Zones = []ZoneConfig{{Name: "example.com", View: "internal", Records: []ZoneRecordConfig{{Name: "@", Type: "SOA", Data: "ns1.example.com. admin.example.com. 1 3600 600 86400 300"}}}}
+			},
+		},
+		{
+			name:   "View without sources should be rejected",
+			modify: func(c *Config) { c.Views = []ViewConfig{{Name: "internal"}} },
+		},
+		{
+			name: "Duplicate views should be rejected",
+			modify: func(c *Config) {
+				c.Views = []ViewConfig{{Name: "internal", Sources: []string{"10.0.0.0/8"}}, {Name: "internal", Sources: []string{"fd00::/8"}}}
+			},
+		},
+		{
+			name:   "Root hint which is not an address should be rejected",
+			modify: func(c *Config) { c.Recursion.RootHints = []string{"a.root-servers.net"} },
+		},
+		{
+			name:   "Upstream with unknown scheme should be rejected",
+			modify: func(c *Config) { c.Forward.Upstreams = []string{"ftp://192.0.2.1"} },
+		},
+		{
+			name: "Forward plugin without upstreams or rules should be rejected with the memory backend",
+			modify: func(c *Config) {
+				c.Backend.Type = Backend__Memory
+				c.Plugins = append(c.Plugins, "forward")
+			},
+		},
+		{
+			name:   "Forward rule without upstreams should be rejected",
+			modify: func(c *Config) { c.Forward.Rules = []managementserver.ManagedForwardRule{{Domain: "corp.internal"}} },
+		},
+		{
+			name:   "Cache with min_ttl above max_ttl should be rejected",
+			modify: func(c *Config) { c.Cache.MinTTL = c.Cache.MaxTTL + 1 },
+		},
+		{
+			name:   "Snapshot without resync interval should be rejected",
+			modify: func(c *Config) { c.Backend.Snapshot.ResyncInterval = 0 },
+		},
+		{
+			name:   "Unknown backend should be rejected",
+			modify: func(c *Config) { c.Backend.Type = "sqlite" },
+		},
+		{
+			name: "Zones should be rejected with the postgres backend",
+			modify: func(c *Config) {
+				c.Zones = []ZoneConfig{{Name: "example.com"}}
+			},
+		},
+		{
+			name: "Zone without SOA should be rejected",
+			modify: func(c *Config) {
+				c.Backend.Type = Backend__Memory
+				c.Zones = []ZoneConfig{{Name: "example.com", Records: []ZoneRecordConfig{{Name: "www", Type: "A", Data: "192.168.1.2"}}}}
+			},
+		},
+		{
+			name: "Zone record with invalid data should be rejected",
+			modify: func(c *Config) {
+				c.Backend.Type = Backend__Memory
+				c.Zones = []ZoneConfig{{Name: "example.com", Records: []ZoneRecordConfig{{Name: "www", Type: "A", Data: "invalid"}}}}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tc.modify(config)
+
+			err := config.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestOptions_Load(t *testing.T) {
+	path := writeTestConfig(t, "dns.yaml", testYAMLConfig)
+
+	t.Setenv(LISTEN_UDP_KEY, "127.0.0.1:5300")
+	t.Setenv(LOG_LEVEL_KEY, "warn")
+	t.Setenv(TCP_IDLE_TIMEOUT_KEY, "30")
+
+	options, err := ParseOptions("dns", []string{"-config", path, "--check-config", "--log-level", "error"})
+	assert.NoError(t, err)
+	assert.True(t, options.CheckConfig)
+
+	config, err := options.Load()
+	assert.NoError(t, err)
+
+	// Environment overrides the file and flags override the environment
+	assert.Equal(t, "127.0.0.1:5300", config.Listen.UDP)
+	assert.Equal(t, 30, config.Limits.TCPIdleTimeout)
+	assert.Equal(t, "error", config.Log.Level)
+
+	t.Setenv(TCP_IDLE_TIMEOUT_KEY, "soon")
+	_, err = options.Load()
+	assert.Error(t, err)
+}
@@ -1,47 +1,192 @@
 package server
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/XxRoloxX/dns/pkg/dns_message"
 	"log/slog"
 	"net"
+	"time"
 )
 
-type Request struct {
+// Maximum size of a DNS message sent over UDP without EDNS (RFC 1035 §4.2.1)
+const MAX_UDP_MESSAGE_SIZE = 512
+
+// Largest UDP payload advertised and sent with EDNS, avoiding IP fragmentation
+const MAX_EDNS_UDP_PAYLOAD_SIZE = 1232
+
+// Transports requests can be received over
+const (
+	TRANSPORT_UDP   = "udp"
+	TRANSPORT_TCP   = "tcp"
+	TRANSPORT_TLS   = "tls"
+	TRANSPORT_HTTPS = "https"
+	TRANSPORT_QUIC  = "quic"
+)
+
+// Delivers encoded responses to the client over the transport the request was received on
+type responseWriter interface {
+	Write(response []byte) error
+	RemoteAddr() net.Addr
+}
+
+type udpResponseWriter struct {
 	conn *net.UDPConn
 	addr *net.UDPAddr
-	msg  *message.Message
 }
 
-func NewRequest(conn *net.UDPConn, addr *net.UDPAddr, buf []byte) (*Request, error) {
+func (w *udpResponseWriter) Write(response []byte) error {
+	_, err := w.conn.WriteToUDP(response, w.addr)
+	return err
+}
+
+func (w *udpResponseWriter) RemoteAddr() net.Addr {
+	return w.addr
+}
+
+type Request struct {
+	msg       *message.Message
+	transport string
+	writer    responseWriter
+
+	// EDNS parameters sent by the client, nil when the query had no OPT record
+	edns *message.EDNS
+
+	// Time after which the idle connection is closed, advertised in edns-tcp-keepalive option
+	idleTimeout time.Duration
+
+	// Limits responses sent over UDP, nil when rate limiting is disabled
+	rateLimiter *rateLimiter
+
+	// Whether the client is allowed to use recursion, advertised with the RA flag
+	recursionAvailable bool
+
+	// Whether the response must not be cached, set by plugins through DisableCache
+	noCache bool
+}
+
+func NewRequest(buf []byte, transport string, writer responseWriter) (req *Request, err error) {
+
+	// Malformed message which slipped through checks of the decoder is rejected instead of stopping the server
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Recovered from panic while decoding message", "panic", r, "msg", buf)
+			req, err = nil, errors.New(fmt.Sprintf("Failed to decode message: %v", r))
+		}
+	}()
 
 	var msg message.Message
 
-	err := message.NewDecoder(buf).Decode(&msg)
+	err = message.NewDecoder(buf).Decode(&msg)
 	if err != nil {
 		slog.Error("Failed to decode message", "msg", buf)
 		return nil, err
 	}
 
-	slog.Info("Got message", "msg", msg)
+	edns, err := msg.EDNS()
+	if err != nil {
+		slog.Error("Failed to decode EDNS", "err", err)
+		return nil, err
+	}
 
 	return &Request{
-		conn: conn,
-		addr: addr,
-		msg:  &msg,
+		msg:       &msg,
+		transport: transport,
+		writer:    writer,
+		edns:      edns,
 	}, nil
 }
 
+// Returns maximum size of the response, depending on the transport and EDNS payload size of the client
+func (r *Request) sizeLimit() int {
+
+	if r.transport != TRANSPORT_UDP {
+		return MAX_TCP_MESSAGE_SIZE
+	}
+
+	if r.edns == nil || r.edns.UDPPayloadSize <= MAX_UDP_MESSAGE_SIZE {
+		return MAX_UDP_MESSAGE_SIZE
+	}
+
+	return int(min(r.edns.UDPPayloadSize, MAX_EDNS_UDP_PAYLOAD_SIZE))
+}
+
+// Returns EDNS parameters of the response, which carries OPT record only when the query did (RFC 6891 §7)
+func (r *Request) responseEDNS() *message.EDNS {
+
+	if r.edns == nil {
+		return nil
+	}
+
+	edns := &message.EDNS{UDPPayloadSize: MAX_EDNS_UDP_PAYLOAD_SIZE}
+
+	// Keepalive is signalled only over TCP, in response to clients which asked for it (RFC 7828 §3.3.2)
+	if _, ok := r.edns.Option(message.EDNSOption__TCPKeepalive); ok && r.transport != TRANSPORT_UDP && r.idleTimeout > 0 {
+		edns.AddOption(message.EDNSOption__TCPKeepalive, binary.BigEndian.AppendUint16(nil, keepaliveTimeout(r.idleTimeout)))
+	}
+
+	return edns
+}
+
 func (r *Request) Send() error {
 
-	encodedMessage := message.NewEncoder().Encode(r.msg)
+	if r.rateLimiter != nil {
+		switch r.rateLimiter.check(r.writer.RemoteAddr(), r.msg) {
+		case RRLAction__Drop:
+			return nil
+		case RRLAction__Slip:
+			truncateResponse(r.msg)
+		}
+	}
+
+	r.msg.Header.Flags.RecursionAvailable = r.recursionAvailable
 
-	_, err := r.conn.WriteToUDP(encodedMessage, r.addr)
+	edns := r.responseEDNS()
+	r.msg.SetEDNS(edns)
+
+	encodedMessage := message.NewEncoder().EncodeWithinLimit(r.msg, r.sizeLimit())
+	encodedMessage = r.pad(encodedMessage, edns)
+
+	err := r.writer.Write(encodedMessage)
 	if err != nil {
 		slog.Error("Failed to send message", "msg", r.msg)
 		return err
 	}
 
-	slog.Info("Response", "msg", r.msg)
-
 	return nil
 }
+
+// Sends the message as the response to the request
+func (r *Request) WriteMsg(msg *message.Message) error {
+	r.msg = msg
+	return r.Send()
+}
+
+func (r *Request) RemoteAddr() net.Addr {
+	return r.writer.RemoteAddr()
+}
+
+func (r *Request) Transport() string {
+	return r.transport
+}
+
+type noCacheContextKey struct{}
+
+func withNoCache(ctx context.Context, noCache *bool) context.Context {
+	return context.WithValue(ctx, noCacheContextKey{}, noCache)
+}
+
+// Marks the response to the request as one which must not be cached
+func DisableCache(ctx context.Context) {
+	if noCache, ok := ctx.Value(noCacheContextKey{}).(*bool); ok {
+		*noCache = true
+	}
+}
+
+// Checks whether a plugin marked the response to the request as one which must not be cached
+func CacheDisabled(ctx context.Context) bool {
+	noCache, ok := ctx.Value(noCacheContextKey{}).(*bool)
+	return ok && *noCache
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/gin-gonic/gin"
+)
+
+// Address of DNS-over-HTTPS listener
+const DOH_ADDRESS = ":443"
+
+// URI template path of DNS-over-HTTPS endpoint (RFC 8484 §3)
+const DOH_PATH = "/dns-query"
+
+const (
+	DNS_MESSAGE_CONTENT_TYPE = "application/dns-message"
+
+	// JSON flavour of DoH, meant for debugging with tools like curl
+	DNS_JSON_CONTENT_TYPE = "application/dns-json"
+)
+
+// Keeps response written by the handler, so it can be returned as body of the HTTP response
+type bufferResponseWriter struct {
+	response []byte
+	addr     net.Addr
+}
+
+func (w *bufferResponseWriter) Write(response []byte) error {
+	w.response = response
+	return nil
+}
+
+func (w *bufferResponseWriter) RemoteAddr() net.Addr {
+	return w.addr
+}
+
+// Returns address of the HTTP client, the request may be coming from behind a proxy though
+func httpRemoteAddr(g *gin.Context) net.Addr {
+
+	addr, err := netip.ParseAddrPort(g.Request.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+
+	return net.TCPAddrFromAddrPort(addr)
+}
+
+type DoHController struct {
+	server *Server
+}
+
+func NewDoHController(server *Server) *DoHController {
+	return &DoHController{
+		server: server,
+	}
+}
+
+// Returns engine serving DNS-over-HTTPS requests
+func (s *Server) NewDoHEngine() *gin.Engine {
+
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+
+	controller := NewDoHController(s)
+
+	engine.GET(DOH_PATH, controller.HandleGet)
+	engine.POST(DOH_PATH, controller.HandlePost)
+
+	return engine
+}
+
+// Handles wire format query encoded in the dns parameter, or JSON query given by name and type parameters
+func (c *DoHController) HandleGet(g *gin.Context) {
+
+	if g.Query("name") != "" {
+		c.handleJSON(g)
+		return
+	}
+
+	// base64url is sent without padding, but padded values are accepted as well (RFC 8484 §4.1)
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(g.Query("dns"), "="))
+	if err != nil || len(buf) == 0 {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid dns parameter"})
+		return
+	}
+
+	c.handleMessage(g, buf)
+}
+
+// Handles wire format query sent as the request body
+func (c *DoHController) HandlePost(g *gin.Context) {
+
+	if g.ContentType() != DNS_MESSAGE_CONTENT_TYPE {
+		g.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported content type"})
+		return
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(g.Request.Body, MAX_TCP_MESSAGE_SIZE+1))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(buf) > MAX_TCP_MESSAGE_SIZE {
+		g.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Message too large"})
+		return
+	}
+
+	c.handleMessage(g, buf)
+}
+
+func (c *DoHController) handleMessage(g *gin.Context, buf []byte) {
+
+	writer := &bufferResponseWriter{addr: httpRemoteAddr(g)}
+
+	req, err := NewRequest(buf, TRANSPORT_HTTPS, writer)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "Malformed DNS message"})
+		return
+	}
+
+	if !c.serve(g, req, writer) {
+		return
+	}
+
+	setCacheControl(g, req)
+	g.Data(http.StatusOK, DNS_MESSAGE_CONTENT_TYPE, writer.response)
+}
+
+func (c *DoHController) handleJSON(g *gin.Context) {
+
+	queryType := record.ResourceRecordType(record.ResourceRecordType__A)
+	if g.Query("type") != "" {
+		parsed, err := record.ParseResourceRecordType(g.Query("type"))
+		if err != nil {
+			g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		queryType = parsed
+	}
+
+	msg := message.Message{
+		Header: message.Header{
+			Flags: message.HeaderFlags{Query: true, RecursionDesired: true},
+		},
+	}
+	msg.AddQuery(message.Query{
+		Name:                splitQueryName(g.Query("name")),
+		ResourceRecordType:  queryType,
+		ResourceRecordClass: record.ResourceRecordClass__In,
+	})
+
+	writer := &bufferResponseWriter{addr: httpRemoteAddr(g)}
+	req := &Request{
+		msg:       &msg,
+		transport: TRANSPORT_HTTPS,
+		writer:    writer,
+	}
+
+	if !c.serve(g, req, writer) {
+		return
+	}
+
+	setCacheControl(g, req)
+	g.Header("Content-Type", DNS_JSON_CONTENT_TYPE)
+	g.JSON(http.StatusOK, newDoHJSONResponse(req.msg))
+}
+
+// Handles the request on a worker of the server and waits for it, so it's shed under overload and awaited
+// on shutdown like requests of other transports. Requests dropped without a response get 503 and false is returned
+func (c *DoHController) serve(g *gin.Context, req *Request, writer *bufferResponseWriter) bool {
+
+	handled := make(chan struct{})
+	c.server.dispatch(req, func() { close(handled) })
+	<-handled
+
+	if writer.response == nil {
+		g.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request was dropped"})
+		return false
+	}
+
+	return true
+}
+
+func splitQueryName(name string) []string {
+
+	// Root has no labels
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []string{}
+	}
+
+	return strings.Split(name, ".")
+}
+
+// Sets freshness lifetime of the response to the lowest TTL of its records (RFC 8484 §5.1)
+func setCacheControl(g *gin.Context, req *Request) {
+
+	ttl, ok := minimumTtl(req.msg)
+	if !ok || req.noCache {
+		g.Header("Cache-Control", "no-cache")
+		return
+	}
+
+	g.Header("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+}
+
+// Returns the lowest TTL among answer and authority records. Negative responses
+// are covered too, as their authority section carries the SOA with negative TTL
+func minimumTtl(msg *message.Message) (uint32, bool) {
+
+	var ttl uint32
+	found := false
+
+	for _, section := range [][]message.Answer{msg.Body.Answers, msg.Body.Authorative} {
+		for _, answer := range section {
+			if !found || answer.Ttl < ttl {
+				ttl = answer.Ttl
+				found = true
+			}
+		}
+	}
+
+	return ttl, found
+}
+
+type DoHJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint   `json:"type"`
+}
+
+type DoHJSONRecord struct {
+	Name string `json:"name"`
+	Type uint   `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type DoHJSONResponse struct {
+	Status     uint              `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AA         bool              `json:"AA"`
+	Question   []DoHJSONQuestion `json:"Question"`
+	Answer     []DoHJSONRecord   `json:"Answer,omitempty"`
+	Authority  []DoHJSONRecord   `json:"Authority,omitempty"`
+	Additional []DoHJSONRecord   `json:"Additional,omitempty"`
+}
+
+func formatName(name []string) string {
+	return strings.Join(name, ".") + "."
+}
+
+func newDoHJSONRecords(section []message.Answer) []DoHJSONRecord {
+
+	records := make([]DoHJSONRecord, 0, len(section))
+	for _, answer := range section {
+
+		// OPT is a pseudo-record describing the message, not data
+		if answer.ResourceRecordType == record.ResourceRecordType__OPT {
+			continue
+		}
+
+		records = append(records, DoHJSONRecord{
+			Name: formatName(answer.Name),
+			Type: uint(answer.ResourceRecordType),
+			TTL:  answer.Ttl,
+			Data: record.FormatRData(answer.ResourceRecordType, answer.RData),
+		})
+	}
+
+	return records
+}
+
+func newDoHJSONResponse(msg *message.Message) DoHJSONResponse {
+
+	questions := make([]DoHJSONQuestion, 0, len(msg.Body.Queries))
+	for _, query := range msg.Body.Queries {
+		questions = append(questions, DoHJSONQuestion{
+			Name: formatName(query.Name),
+			Type: uint(query.ResourceRecordType),
+		})
+	}
+
+	return DoHJSONResponse{
+		Status:     uint(msg.Header.Flags.ResponseCode),
+		TC:         msg.Header.Flags.Truncation,
+		RD:         msg.Header.Flags.RecursionDesired,
+		RA:         msg.Header.Flags.RecursionAvailable,
+		AA:         msg.Header.Flags.AuthorativeAnswer,
+		Question:   questions,
+		Answer:     newDoHJSONRecords(msg.Body.Answers),
+		Authority:  newDoHJSONRecords(msg.Body.Authorative),
+		Additional: newDoHJSONRecords(msg.Body.Additional),
+	}
+}
+
+func (s *Server) ListenHTTPS() {
+
+	if s.dohServer == nil {
+		return
+	}
+
+	err := s.dohServer.ServeTLS(s.dohListener, "", "")
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("failed to serve DNS-over-HTTPS", "err", err.Error())
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoHController_wireFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	query := message.NewEncoder().Encode(newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A))
+
+	testCases := []struct {
+		name                 string
+		request              *http.Request
+		expectedStatus       int
+		expectedCacheControl string
+	}{
+		{
+			name:                 "GET query should be answered with max-age of the answer TTL",
+			request:              httptest.NewRequest(http.MethodGet, DOH_PATH+"?dns="+base64.RawURLEncoding.EncodeToString(query), nil),
+			expectedStatus:       http.StatusOK,
+			expectedCacheControl: "max-age=300",
+		},
+		{
+			name:                 "GET query with padded base64url should be accepted",
+			request:              httptest.NewRequest(http.MethodGet, DOH_PATH+"?dns="+base64.URLEncoding.EncodeToString(query), nil),
+			expectedStatus:       http.StatusOK,
+			expectedCacheControl: "max-age=300",
+		},
+		{
+			name:           "GET query with invalid dns parameter should be rejected",
+			request:        httptest.NewRequest(http.MethodGet, DOH_PATH+"?dns=%%%", nil),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "POST query should be answered",
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, DOH_PATH, bytes.NewReader(query))
+				req.Header.Set("Content-Type", DNS_MESSAGE_CONTENT_TYPE)
+				return req
+			}(),
+			expectedStatus:       http.StatusOK,
+			expectedCacheControl: "max-age=300",
+		},
+		{
+			name: "POST query with other content type should be rejected",
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, DOH_PATH, bytes.NewReader(query))
+				req.Header.Set("Content-Type", "text/plain")
+				return req
+			}(),
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	engine := srv.NewDoHEngine()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, tc.request)
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, DNS_MESSAGE_CONTENT_TYPE, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.expectedCacheControl, recorder.Header().Get("Cache-Control"))
+
+			var response message.Message
+			assert.NoError(t, message.NewDecoder(recorder.Body.Bytes()).Decode(&response))
+			assert.Equal(t, []record.ResourceRecordType{record.ResourceRecordType__A}, sectionTypes(response.Body.Answers))
+		})
+	}
+}
+
+func TestDoHController_json(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name                 string
+		query                string
+		expectedStatus       int
+		expectedCode         uint
+		expectedQuestion     string
+		expectedAnswer       []DoHJSONRecord
+		expectedCacheControl string
+	}{
+		{
+			name:           "Name and type should be answered in JSON",
+			query:          "?name=www.example.com.&type=A",
+			expectedStatus: http.StatusOK,
+			expectedCode:   message.ResponseCode__NoError,
+			expectedAnswer: []DoHJSONRecord{
+				{Name: "www.example.com.", Type: record.ResourceRecordType__A, TTL: 300, Data: "192.168.1.2"},
+			},
+			expectedCacheControl: "max-age=300",
+		},
+		{
+			name:           "Type should default to A",
+			query:          "?name=www.example.com",
+			expectedStatus: http.StatusOK,
+			expectedCode:   message.ResponseCode__NoError,
+			expectedAnswer: []DoHJSONRecord{
+				{Name: "www.example.com.", Type: record.ResourceRecordType__A, TTL: 300, Data: "192.168.1.2"},
+			},
+			expectedCacheControl: "max-age=300",
+		},
+		{
+			name:                 "Missing name should get max-age of the negative SOA TTL",
+			query:                "?name=missing.example.com&type=1",
+			expectedStatus:       http.StatusOK,
+			expectedCode:         message.ResponseCode__NxDomain,
+			expectedCacheControl: "max-age=300",
+		},
+		{
+			name:                 "Root should be queried without labels",
+			query:                "?name=.&type=NS",
+			expectedStatus:       http.StatusOK,
+			expectedCode:         message.ResponseCode__Refused,
+			expectedQuestion:     ".",
+			expectedCacheControl: "no-cache",
+		},
+		{
+			name:           "Unknown type should be rejected",
+			query:          "?name=www.example.com&type=BOGUS",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Type code out of range should be rejected",
+			query:          "?name=www.example.com&type=70000",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	engine := srv.NewDoHEngine()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DOH_PATH+tc.query, nil))
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response DoHJSONResponse
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+
+			assert.Equal(t, tc.expectedCode, response.Status)
+			if tc.expectedQuestion != "" {
+				assert.Equal(t, tc.expectedQuestion, response.Question[0].Name)
+			}
+			assert.Equal(t, tc.expectedAnswer, response.Answer)
+			assert.Equal(t, tc.expectedCacheControl, recorder.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestDoHController_overload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name           string
+		action         OverloadAction
+		expectedStatus int
+	}{
+		{
+			name:           "Dropped query should get service unavailable",
+			action:         OverloadAction__Drop,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Refused query should get the REFUSED response",
+			action:         OverloadAction__Refused,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Limits.Workers = 0
+			config.Limits.QueueSize = 0
+			config.Limits.OverloadAction = tc.action
+
+			// Without workers every request is shed
+			srv := newServer(config)
+			srv.handler = &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)}
+			defer srv.Close()
+
+			query := message.NewEncoder().Encode(newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A))
+			recorder := httptest.NewRecorder()
+			srv.NewDoHEngine().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DOH_PATH+"?dns="+base64.RawURLEncoding.EncodeToString(query), nil))
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response message.Message
+			assert.NoError(t, message.NewDecoder(recorder.Body.Bytes()).Decode(&response))
+			assert.Equal(t, message.ResponseCode(message.ResponseCode__Refused), response.Header.Flags.ResponseCode)
+		})
+	}
+}
+
+func TestSplitQueryName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{
+			name:     "Fully qualified name should be split into labels",
+			value:    "www.example.com.",
+			expected: []string{"www", "example", "com"},
+		},
+		{
+			name:     "Relative name should be split into labels",
+			value:    "www.example.com",
+			expected: []string{"www", "example", "com"},
+		},
+		{
+			name:     "Root should have no labels",
+			value:    ".",
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, splitQueryName(tc.value))
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	"github.com/quic-go/quic-go"
+)
+
+// Address of DNS-over-QUIC listener, sharing port with DNS-over-TLS (RFC 9250 §4.1.1)
+const DOQ_ADDRESS = ":853"
+
+// ALPN protocol identifier of DNS-over-QUIC
+const DOQ_ALPN = "doq"
+
+// Error codes closing DoQ connections and streams (RFC 9250 §4.3)
+const (
+	DOQ_NO_ERROR       = 0x0
+	DOQ_INTERNAL_ERROR = 0x1
+	DOQ_PROTOCOL_ERROR = 0x2
+)
+
+// Writes single response to the stream the query was received on and closes it
+type quicStreamWriter struct {
+	stream quic.Stream
+	addr   net.Addr
+
+	// Set once the response is written, requests dropped without response leave it unset
+	written bool
+}
+
+func (w *quicStreamWriter) Write(response []byte) error {
+
+	w.written = true
+
+	_, err := w.stream.Write(frameMessage(response))
+	if err != nil {
+		return err
+	}
+
+	// Stream carries exactly one response, so its end is signalled right after (RFC 9250 §4.2)
+	return w.stream.Close()
+}
+
+func (w *quicStreamWriter) RemoteAddr() net.Addr {
+	return w.addr
+}
+
+// Returns configuration of DoQ connections, accepting 0-RTT data for idempotent queries
+func newQUICConfig() *quic.Config {
+	return &quic.Config{
+		Allow0RTT: true,
+	}
+}
+
+func (s *Server) ListenQUIC() {
+
+	if s.quicListener == nil {
+		return
+	}
+
+	for {
+		conn, err := s.quicListener.Accept(s.stopContext())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) || s.stopContext().Err() != nil {
+				return
+			}
+
+			slog.Error("failed to accept connection", "transport", TRANSPORT_QUIC, "err", err.Error())
+			continue
+		}
+
+		if !s.tcpConnections.acquire(conn.RemoteAddr()) {
+			slog.Warn("Too many connections from client", "transport", TRANSPORT_QUIC, "addr", conn.RemoteAddr())
+			conn.CloseWithError(DOQ_NO_ERROR, "too many connections")
+			continue
+		}
+
+		s.inFlight.Add(1)
+		go func() {
+			defer s.inFlight.Done()
+			s.serveQUICConnection(conn)
+		}()
+	}
+}
+
+// Handles queries sent over the connection, each one on its own stream
+func (s *Server) serveQUICConnection(conn quic.EarlyConnection) {
+
+	defer s.tcpConnections.release(conn.RemoteAddr())
+
+	// Stopping server stops accepting new streams, the ones already accepted are still answered
+	ctx, cancel := context.WithCancel(conn.Context())
+	defer cancel()
+
+	stop := context.AfterFunc(s.stopContext(), cancel)
+	defer stop()
+
+	var streams sync.WaitGroup
+
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			break
+		}
+
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			s.serveQUICStream(conn, stream)
+		}()
+	}
+
+	streams.Wait()
+
+	if s.stopContext().Err() != nil {
+		conn.CloseWithError(DOQ_NO_ERROR, "server shutting down")
+	}
+}
+
+func (s *Server) serveQUICStream(conn quic.EarlyConnection, stream quic.Stream) {
+
+	buf, err := readTCPMessage(stream)
+	if err != nil {
+		slog.Warn("failed to read message", "transport", TRANSPORT_QUIC, "err", err.Error())
+		stream.CancelRead(DOQ_PROTOCOL_ERROR)
+		stream.CancelWrite(DOQ_PROTOCOL_ERROR)
+		return
+	}
+
+	writer := &quicStreamWriter{stream: stream, addr: conn.RemoteAddr()}
+
+	req, err := NewRequest(buf, TRANSPORT_QUIC, writer)
+	if err != nil {
+		s.HandleFormattingError(&Request{
+			msg:       &message.Message{},
+			transport: TRANSPORT_QUIC,
+			writer:    writer,
+		})
+		return
+	}
+
+	// Stream identifies the query, so the message ID has to be 0 (RFC 9250 §4.2.1)
+	if req.msg.Header.TransactionId != 0 {
+		conn.CloseWithError(DOQ_PROTOCOL_ERROR, "message ID must be 0")
+		return
+	}
+
+	// 0-RTT data can be replayed, so only idempotent queries are answered before the handshake completes (RFC 9250 §4.5)
+	if req.msg.Header.Flags.OperationCode != message.OpCode__Query {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			return
+		}
+	}
+
+	// Stream is closed once the response is written, so the connection waits for the worker to finish
+	handled := make(chan struct{})
+	s.dispatch(req, func() {
+		// Requests dropped by load shedding or rate limiting get no response, their stream is
+		// reset so the client doesn't wait for it and it stops counting against the connection
+		if !writer.written {
+			stream.CancelWrite(DOQ_NO_ERROR)
+			stream.CancelRead(DOQ_NO_ERROR)
+		}
+		close(handled)
+	})
+	<-handled
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/XxRoloxX/dns/pkg/dns_client"
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts DNS-over-QUIC listener of the server on loopback and returns its address
+func startQUICServer(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	certFile, keyFile := writeSelfSignedCertificate(t, t.TempDir(), 1)
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	listener, err := quic.ListenAddrEarly("127.0.0.1:0", newTLSConfig(reloader, DOQ_ALPN), newQUICConfig())
+	assert.NoError(t, err)
+
+	srv.quicListener = listener
+	if srv.tcpConnections == nil {
+		srv.tcpConnections = newConnectionLimiter(DEFAULT_TCP_MAX_CONNECTIONS_PER_CLIENT)
+	}
+
+	go srv.ListenQUIC()
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String()
+}
+
+func TestServer_ListenQUIC(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	addr := startQUICServer(t, srv)
+
+	doq := client.NewDoQClient(addr, &tls.Config{InsecureSkipVerify: true})
+	defer doq.Close()
+
+	testCases := []struct {
+		name            string
+		opcode          message.OpCode
+		query           []string
+		expectedCode    message.ResponseCode
+		expectedAnswers []record.ResourceRecordType
+	}{
+		{
+			name:            "Query should be answered on its own stream",
+			opcode:          message.OpCode__Query,
+			query:           []string{"www", "example", "com"},
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:         "Missing name should get NXDOMAIN",
+			opcode:       message.OpCode__Query,
+			query:        []string{"missing", "example", "com"},
+			expectedCode: message.ResponseCode__NxDomain,
+		},
+		{
+			name:         "Non-idempotent operation should be answered after the handshake",
+			opcode:       message.OpCode__Update,
+			query:        []string{"example", "com"},
+			expectedCode: message.ResponseCode__NotImp,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			query := newTestMessage(tc.query, record.ResourceRecordType__A)
+			query.Header.TransactionId = 42
+			query.Header.Flags.OperationCode = tc.opcode
+
+			response, err := doq.Exchange(ctx, query)
+			assert.NoError(t, err)
+
+			// Client should restore the ID replaced with 0 on the wire
+			assert.Equal(t, uint16(42), response.Header.TransactionId)
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			assert.Equal(t, tc.expectedAnswers, sectionTypes(response.Body.Answers))
+		})
+	}
+}
+
+func TestServer_ListenQUIC_concurrentQueries(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	addr := startQUICServer(t, srv)
+
+	doq := client.NewDoQClient(addr, &tls.Config{InsecureSkipVerify: true})
+	defer doq.Close()
+
+	var wg sync.WaitGroup
+	for id := range uint16(5) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			query := newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A)
+			query.Header.TransactionId = id
+
+			response, err := doq.Exchange(ctx, query)
+			if assert.NoError(t, err) {
+				assert.Equal(t, id, response.Header.TransactionId)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServer_ListenQUIC_nonZeroMessageId(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	addr := startQUICServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{DOQ_ALPN}}, nil)
+	assert.NoError(t, err)
+
+	stream, err := conn.OpenStreamSync(ctx)
+	assert.NoError(t, err)
+
+	query := message.NewEncoder().Encode(newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A))
+	_, err = stream.Write(frameMessage(query))
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+
+	// Server should close the connection with protocol error instead of answering
+	_, err = io.ReadAll(stream)
+	var appErr *quic.ApplicationError
+	assert.ErrorAs(t, err, &appErr)
+	assert.Equal(t, quic.ApplicationErrorCode(DOQ_PROTOCOL_ERROR), appErr.ErrorCode)
+}
+
+func TestServer_ListenQUIC_droppedQuery(t *testing.T) {
+	dropping := HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {})
+	srv := newTestServer(t, dropping)
+	addr := startQUICServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{DOQ_ALPN}}, nil)
+	assert.NoError(t, err)
+
+	stream, err := conn.OpenStreamSync(ctx)
+	assert.NoError(t, err)
+
+	msg := newTestMessage([]string{"www", "example", "com"}, record.ResourceRecordType__A)
+	msg.Header.TransactionId = 0
+	query := message.NewEncoder().Encode(msg)
+	_, err = stream.Write(frameMessage(query))
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+
+	// Server should reset the stream of the query it dropped instead of leaving the client waiting
+	_, err = io.ReadAll(stream)
+	var streamErr *quic.StreamError
+	assert.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, quic.StreamErrorCode(DOQ_NO_ERROR), streamErr.ErrorCode)
+}
@@ -0,0 +1,631 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dnsaddress "github.com/XxRoloxX/dns/pkg/dns_address"
+	client "github.com/XxRoloxX/dns/pkg/dns_client"
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+const (
+	// Comma separated addresses of the upstreams, see dnsaddress.ParseUpstream for their format
+	FORWARD_UPSTREAMS_KEY = "DNS_FORWARD_UPSTREAMS"
+
+	// Selects how upstreams are picked, see ForwardStrategy
+	FORWARD_STRATEGY_KEY = "DNS_FORWARD_STRATEGY"
+
+	// Seconds given to upstreams to answer single query, retries included
+	FORWARD_TIMEOUT_KEY = "DNS_FORWARD_TIMEOUT"
+
+	// Seconds between reloads of forward rules managed through the management API
+	FORWARD_REFRESH_INTERVAL_KEY = "DNS_FORWARD_REFRESH_INTERVAL"
+)
+
+const (
+	DEFAULT_FORWARD_TIMEOUT            = 5
+	DEFAULT_FORWARD_UNHEALTHY_DURATION = 30
+	DEFAULT_FORWARD_REFRESH_INTERVAL   = 30
+)
+
+// Weight of the latest sample in the smoothed round trip time of an upstream
+const RTT_EWMA_WEIGHT = 0.3
+
+// Order in which upstreams are tried
+type ForwardStrategy string
+
+const (
+	// Upstreams in random order, spreading queries evenly
+	ForwardStrategy__Random ForwardStrategy = "random"
+
+	// Each query starts from the upstream after the one the previous query started from
+	ForwardStrategy__RoundRobin ForwardStrategy = "round_robin"
+
+	// Upstreams with the lowest smoothed round trip time first, the ones not measured yet before all others
+	ForwardStrategy__LowestLatency ForwardStrategy = "lowest_latency"
+)
+
+func NewForwardStrategy(strategy string) (ForwardStrategy, error) {
+	switch ForwardStrategy(strategy) {
+	case ForwardStrategy__Random:
+		return ForwardStrategy__Random, nil
+	case ForwardStrategy__RoundRobin:
+		return ForwardStrategy__RoundRobin, nil
+	case ForwardStrategy__LowestLatency:
+		return ForwardStrategy__LowestLatency, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Invalid forward strategy: %s, expected random, round_robin or lowest_latency", strategy))
+	}
+}
+
+type ForwardConfig struct {
+	// Addresses of the upstreams, e.g. "1.1.1.1", "tcp://9.9.9.9", "tls://1.1.1.1#cloudflare-dns.com" or "https://dns.google/dns-query"
+	Upstreams []string        `yaml:"upstreams" toml:"upstreams"`
+	Strategy  ForwardStrategy `yaml:"strategy" toml:"strategy"`
+
+	// Seconds given to upstreams to answer single query, retries included
+	Timeout int `yaml:"timeout" toml:"timeout"`
+
+	// Seconds upstream which failed to answer is tried only after the healthy ones
+	UnhealthyDuration int `yaml:"unhealthy_duration" toml:"unhealthy_duration"`
+
+	// Forwarding by domain suffix, used together with the rules managed through the management API
+	Rules []managementserver.ManagedForwardRule `yaml:"rules" toml:"rules"`
+
+	// Seconds between reloads of rules managed through the management API. With the postgres backend
+	// rules are also reloaded as soon as they change, the reloads only catch changes whose notifications were lost
+	RefreshInterval int `yaml:"refresh_interval" toml:"refresh_interval"`
+}
+
+func (c *ForwardConfig) Validate() error {
+
+	errs := make([]error, 0)
+
+	for _, address := range c.Upstreams {
+		if _, err := dnsaddress.ParseUpstream(address); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid forward.upstreams: %s", err)))
+		}
+	}
+
+	if _, err := NewForwardStrategy(string(c.Strategy)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid forward.timeout: %d, expected a positive number", c.Timeout)))
+	}
+
+	if c.UnhealthyDuration < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid forward.unhealthy_duration: %d, expected 0 or more seconds", c.UnhealthyDuration)))
+	}
+
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid forward.refresh_interval: %d, expected a positive number of seconds", c.RefreshInterval)))
+	}
+
+	for i, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid forward rule %d: %s", i+1, err)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Upstream with its smoothed round trip time, zero until it answers for the first time
+type upstreamState struct {
+	upstream       client.Upstream
+	rtt            time.Duration
+	unhealthyUntil time.Time
+}
+
+// Sends queries to upstreams picked by the strategy, moving on to the next one when an upstream fails.
+// Upstreams which failed are tried after the healthy ones until they are healthy again
+type upstreamPool struct {
+	mu                sync.Mutex
+	upstreams         []*upstreamState
+	strategy          ForwardStrategy
+	unhealthyDuration time.Duration
+	next              int
+}
+
+func newUpstreamPool(upstreams []client.Upstream, strategy ForwardStrategy, unhealthyDuration time.Duration) *upstreamPool {
+
+	states := make([]*upstreamState, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		states = append(states, &upstreamState{upstream: upstream})
+	}
+
+	return &upstreamPool{
+		upstreams:         states,
+		strategy:          strategy,
+		unhealthyDuration: unhealthyDuration,
+	}
+}
+
+// Creates upstreams from their addresses
+func newUpstreams(addresses []string) ([]client.Upstream, error) {
+
+	upstreams := make([]client.Upstream, 0, len(addresses))
+	for _, address := range addresses {
+		upstream, err := client.NewUpstream(address, nil)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+
+	return upstreams, nil
+}
+
+// Returns upstreams in the order they should be tried: the healthy ones ordered by the strategy,
+// followed by the unhealthy ones, which become healthy again the soonest first
+func (p *upstreamPool) order() []*upstreamState {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	// Round robin rotates the list, so the order of upstreams is kept
+	start := 0
+	if p.strategy == ForwardStrategy__RoundRobin && len(p.upstreams) > 0 {
+		start = p.next % len(p.upstreams)
+		p.next = start + 1
+	}
+
+	healthy := make([]*upstreamState, 0, len(p.upstreams))
+	unhealthy := make([]*upstreamState, 0)
+
+	for i := range p.upstreams {
+		state := p.upstreams[(start+i)%len(p.upstreams)]
+		if now.Before(state.unhealthyUntil) {
+			unhealthy = append(unhealthy, state)
+		} else {
+			healthy = append(healthy, state)
+		}
+	}
+
+	switch p.strategy {
+	case ForwardStrategy__Random:
+		rand.Shuffle(len(healthy), func(i, j int) { healthy[i], healthy[j] = healthy[j], healthy[i] })
+	case ForwardStrategy__LowestLatency:
+		slices.SortStableFunc(healthy, func(a, b *upstreamState) int {
+			return cmp.Compare(a.rtt, b.rtt)
+		})
+	}
+
+	slices.SortStableFunc(unhealthy, func(a, b *upstreamState) int {
+		return a.unhealthyUntil.Compare(b.unhealthyUntil)
+	})
+
+	return append(healthy, unhealthy...)
+}
+
+// Updates smoothed round trip time of the upstream which answered, it is healthy again
+func (p *upstreamPool) observe(state *upstreamState, rtt time.Duration) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state.rtt == 0 {
+		state.rtt = rtt
+	} else {
+		state.rtt = time.Duration(RTT_EWMA_WEIGHT*float64(rtt) + (1-RTT_EWMA_WEIGHT)*float64(state.rtt))
+	}
+
+	state.unhealthyUntil = time.Time{}
+}
+
+func (p *upstreamPool) markUnhealthy(state *upstreamState) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state.unhealthyUntil = time.Now().Add(p.unhealthyDuration)
+}
+
+// Sends the query to upstreams until one of them answers it, within the deadline of the context.
+// Each attempt gets an equal share of the remaining time. Upstreams which fail to answer are marked unhealthy,
+// the ones answering with SERVFAIL or REFUSED are skipped, and the last such answer is returned when no upstream did better
+func (p *upstreamPool) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+
+	candidates := p.order()
+
+	var lastResponse *message.Message
+	var lastErr error
+
+	for i, state := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(candidates)-i))
+		}
+
+		start := time.Now()
+		response, err := state.upstream.Exchange(attemptCtx, msg)
+		cancel()
+
+		if err != nil {
+			// Upstream is not blamed for the query running out of time
+			if ctx.Err() != nil {
+				break
+			}
+
+			p.markUnhealthy(state)
+			lastErr = errors.New(fmt.Sprintf("Query to %s failed: %s", state.upstream, err))
+			continue
+		}
+
+		p.observe(state, time.Since(start))
+
+		code := response.Header.Flags.ResponseCode
+		if code == message.ResponseCode__ServFail || code == message.ResponseCode__Refused {
+			lastResponse = response
+			continue
+		}
+
+		return response, nil
+	}
+
+	if lastResponse != nil {
+		return lastResponse, nil
+	}
+
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	if lastErr == nil {
+		lastErr = errors.New("No upstreams to query")
+	}
+
+	return nil, lastErr
+}
+
+// Forwarding rule prepared for matching queries
+type forwardRule struct {
+	// Identifies the rule, "config/<n>" for rules of the config and "api/<id>" for managed ones
+	key string
+
+	domain   []string
+	pool     *upstreamPool
+	noCache  bool
+	stripECS bool
+}
+
+func (f *Forwarder) compileRule(key string, rule managementserver.ManagedForwardRule) (*forwardRule, error) {
+
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	upstreams, err := newUpstreams(rule.Upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &forwardRule{
+		key:      key,
+		domain:   rule.DomainLabels(),
+		pool:     newUpstreamPool(upstreams, f.strategy, f.unhealthyDuration),
+		noCache:  rule.NoCache,
+		stripECS: rule.StripECS,
+	}, nil
+}
+
+// Forwards queries the rest of the chain has not answered to upstream resolvers. Queries for names
+// below the domain of a rule go to its upstreams, the rule with the longest domain wins, others to forward.upstreams.
+// Like recursion, only clients in recursion.allow are served, and only when they asked for it with the RD flag
+type Forwarder struct {
+	// Upstreams of queries matching no rule, nil when they are passed on
+	pool *upstreamPool
+
+	static     []*forwardRule
+	repository managementserver.ForwardRepository
+
+	rules  atomic.Pointer[[]*forwardRule]
+	reload sync.Mutex
+
+	strategy          ForwardStrategy
+	unhealthyDuration time.Duration
+	allow             []netip.Prefix
+	timeout           time.Duration
+}
+
+func NewForwarder(config ForwardConfig, allow []string, repository managementserver.ForwardRepository) (*Forwarder, error) {
+
+	prefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, err
+	}
+
+	forwarder := &Forwarder{
+		repository:        repository,
+		strategy:          config.Strategy,
+		unhealthyDuration: time.Duration(config.UnhealthyDuration) * time.Second,
+		allow:             prefixes,
+		timeout:           time.Duration(config.Timeout) * time.Second,
+	}
+
+	if len(config.Upstreams) > 0 {
+		upstreams, err := newUpstreams(config.Upstreams)
+		if err != nil {
+			return nil, err
+		}
+		forwarder.pool = newUpstreamPool(upstreams, forwarder.strategy, forwarder.unhealthyDuration)
+	}
+
+	for i, rule := range config.Rules {
+		compiled, err := forwarder.compileRule(fmt.Sprintf("config/%d", i+1), rule)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid forward rule %d: %s", i+1, err))
+		}
+		forwarder.static = append(forwarder.static, compiled)
+	}
+
+	forwarder.store(nil)
+
+	return forwarder, nil
+}
+
+func newForwardPlugin(s *Server) (Middleware, error) {
+
+	repository, _ := s.repositorySource().(managementserver.ForwardRepository)
+
+	forwarder, err := NewForwarder(s.config.Forward, s.config.Recursion.Allow, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if repository != nil {
+		if err := forwarder.Reload(s.stopContext()); err != nil {
+			slog.Warn("Failed to load forward rules, using rules of the config only", "err", err)
+		}
+
+		interval := time.Duration(s.config.Forward.RefreshInterval) * time.Second
+
+		s.listeners.Add(1)
+		go func() {
+			defer s.listeners.Done()
+			forwarder.refresh(s.stopContext(), interval)
+		}()
+
+		if source, ok := s.repositorySource().(managementserver.ForwardRulesChangesSource); ok {
+			s.listeners.Add(1)
+			go func() {
+				defer s.listeners.Done()
+				forwarder.watch(s.stopContext(), source)
+			}()
+		}
+	}
+
+	// Responses to allowed clients advertise recursion, including the ones answered by other plugins
+	s.forwarder = forwarder
+
+	return forwarder.Middleware, nil
+}
+
+// Orders rules of the config and managed ones by length of their domains, rules of the config go first among equal ones
+func (f *Forwarder) store(managed []*forwardRule) {
+
+	rules := make([]*forwardRule, 0, len(f.static)+len(managed))
+	rules = append(rules, f.static...)
+	rules = append(rules, managed...)
+
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].domain) > len(rules[j].domain) })
+
+	f.rules.Store(&rules)
+}
+
+// Replaces managed rules with the current ones from the repository. Rules which were loaded before
+// keep their upstreams, so health and round trip times of the upstreams are not lost
+func (f *Forwarder) Reload(ctx context.Context) error {
+
+	f.reload.Lock()
+	defer f.reload.Unlock()
+
+	rules, err := f.repository.GetForwardRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[string]*forwardRule)
+	for _, rule := range *f.rules.Load() {
+		previous[rule.key] = rule
+	}
+
+	managed := make([]*forwardRule, 0, len(rules))
+	for _, rule := range rules {
+		key := fmt.Sprintf("api/%d", rule.ID)
+		if loaded, ok := previous[key]; ok {
+			managed = append(managed, loaded)
+			continue
+		}
+
+		compiled, err := f.compileRule(key, rule)
+		if err != nil {
+			slog.Warn("Skipping invalid forward rule", "id", rule.ID, "err", err)
+			continue
+		}
+		managed = append(managed, compiled)
+	}
+
+	f.store(managed)
+
+	return nil
+}
+
+// Reloads managed rules periodically until the context is done
+func (f *Forwarder) refresh(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Reload(ctx); err != nil {
+				slog.Warn("Failed to reload forward rules, keeping previous ones", "err", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reloads managed rules whenever they change through the management API, until the context is done
+func (f *Forwarder) watch(ctx context.Context, source managementserver.ForwardRulesChangesSource) {
+	watchWithBackoff(ctx, "changes of forward rules", func(listening func()) error {
+
+		reload := func() {
+			if err := f.Reload(ctx); err != nil {
+				slog.Warn("Failed to reload forward rules, keeping previous ones", "err", err)
+			}
+		}
+
+		return source.WatchForwardRulesChanges(
+			ctx,
+			func() {
+				listening()
+
+				// Changes made while no one was listening are picked up by a full reload
+				reload()
+			},
+			reload,
+		)
+	})
+}
+
+// Returns the rule with the longest domain the name is equal to or below, or nil when none is
+func (f *Forwarder) match(name []string) *forwardRule {
+
+	for _, rule := range *f.rules.Load() {
+		if record.IsSubdomain(name, rule.domain) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// Checks whether the client is allowed to have its queries forwarded
+func (f *Forwarder) Available(remote net.Addr) bool {
+
+	if f == nil {
+		return false
+	}
+
+	addr, ok := clientAddr(remote)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *Forwarder) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+		if !msg.Header.Flags.RecursionDesired || msg.Header.Flags.OperationCode != message.OpCode__Query ||
+			len(msg.Body.Queries) != 1 || !f.Available(w.RemoteAddr()) {
+			next.ServeDNS(ctx, w, msg)
+			return
+		}
+
+		pool, stripECS := f.pool, false
+
+		if rule := f.match(msg.Body.Queries[0].Name); rule != nil {
+			pool, stripECS = rule.pool, rule.stripECS
+			if rule.noCache {
+				DisableCache(ctx)
+			}
+		}
+
+		if pool == nil {
+			next.ServeDNS(ctx, w, msg)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+
+		response, err := pool.Exchange(ctx, forwardedQuery(msg, stripECS))
+		if err != nil {
+			slog.Warn("Failed to forward", "query", msg.Body.Queries[0], "err", err)
+			WriteError(w, msg, message.ResponseCode__ServFail)
+			return
+		}
+
+		msg.ResetRRs()
+		msg.Body.Answers = response.Body.Answers
+		msg.Body.Authorative = response.Body.Authorative
+		msg.Body.Additional = response.Body.Additional
+		msg.SetEDNS(nil)
+
+		msg.SetAsResponse()
+		msg.SetAuthorative(false)
+		msg.SetResponseCode(response.Header.Flags.ResponseCode)
+		msg.UpdateRRNumbers()
+
+		w.WriteMsg(msg)
+	})
+}
+
+// Builds query sent upstream from the one of the client. EDNS options of the client are passed on,
+// apart from the ones concerning only the connection to the client, and its subnet when it is stripped
+func forwardedQuery(msg *message.Message, stripECS bool) *message.Message {
+
+	query := &message.Message{
+		Header: message.Header{
+			TransactionId: msg.Header.TransactionId,
+			Flags: message.HeaderFlags{
+				Query:            true,
+				OperationCode:    message.OpCode__Query,
+				RecursionDesired: true,
+			},
+		},
+	}
+	query.AddQuery(msg.Body.Queries[0])
+
+	edns := &message.EDNS{UDPPayloadSize: client.EDNS_UDP_PAYLOAD_SIZE}
+	if clientEDNS, err := msg.EDNS(); err == nil && clientEDNS != nil {
+		edns.DNSSECOk = clientEDNS.DNSSECOk
+		for _, option := range clientEDNS.Options {
+			switch {
+			case option.Code == message.EDNSOption__TCPKeepalive, option.Code == message.EDNSOption__Padding:
+				continue
+			case option.Code == message.EDNSOption__ClientSubnet && stripECS:
+				continue
+			default:
+				edns.AddOption(option.Code, option.Data)
+			}
+		}
+	}
+	query.SetEDNS(edns)
+
+	return query
+}
@@ -0,0 +1,443 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	client "github.com/XxRoloxX/dns/pkg/dns_client"
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Upstream answering every query with an address after the delay, or failing when unavailable
+type testUpstream struct {
+	name        string
+	delay       time.Duration
+	unavailable bool
+	code        message.ResponseCode
+	queries     []*message.Message
+}
+
+func (u *testUpstream) Exchange(ctx context.Context, msg *message.Message) (*message.Message, error) {
+	u.queries = append(u.queries, msg)
+
+	select {
+	case <-time.After(u.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if u.unavailable {
+		return nil, errors.New("connection refused")
+	}
+
+	response := *msg
+	response.ResetRRs()
+	response.SetAsResponse()
+	response.SetResponseCode(u.code)
+	if u.code == message.ResponseCode__NoError {
+		response.AddAnswer(record.NewARecord(msg.Body.Queries[0].Name, record.ResourceRecordClass__In, net.IPv4(192, 0, 2, 1)))
+	}
+	response.UpdateRRNumbers()
+	return &response, nil
+}
+
+func (u *testUpstream) String() string {
+	return u.name
+}
+
+func names(states []*upstreamState) []string {
+	result := make([]string, 0, len(states))
+	for _, state := range states {
+		result = append(result, state.upstream.String())
+	}
+	return result
+}
+
+func newTestPool(strategy ForwardStrategy, upstreams ...*testUpstream) *upstreamPool {
+	list := make([]client.Upstream, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		list = append(list, upstream)
+	}
+	return newUpstreamPool(list, strategy, time.Minute)
+}
+
+func TestUpstreamPool_order(t *testing.T) {
+
+	t.Run("Round robin should start each query from the next upstream", func(t *testing.T) {
+		pool := newTestPool(ForwardStrategy__RoundRobin, &testUpstream{name: "a"}, &testUpstream{name: "b"}, &testUpstream{name: "c"})
+
+		assert.Equal(t, []string{"a", "b", "c"}, names(pool.order()))
+		assert.Equal(t, []string{"b", "c", "a"}, names(pool.order()))
+		assert.Equal(t, []string{"c", "a", "b"}, names(pool.order()))
+		assert.Equal(t, []string{"a", "b", "c"}, names(pool.order()))
+	})
+
+	t.Run("Lowest latency should prefer unmeasured upstreams, then the fastest ones", func(t *testing.T) {
+		pool := newTestPool(ForwardStrategy__LowestLatency, &testUpstream{name: "a"}, &testUpstream{name: "b"}, &testUpstream{name: "c"})
+		pool.observe(pool.upstreams[0], 30*time.Millisecond)
+		pool.observe(pool.upstreams[1], 10*time.Millisecond)
+
+		assert.Equal(t, []string{"c", "b", "a"}, names(pool.order()))
+	})
+
+	t.Run("Random order should contain every upstream", func(t *testing.T) {
+		pool := newTestPool(ForwardStrategy__Random, &testUpstream{name: "a"}, &testUpstream{name: "b"}, &testUpstream{name: "c"})
+
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, names(pool.order()))
+	})
+
+	t.Run("Unhealthy upstreams should be tried last, the one recovering the soonest first", func(t *testing.T) {
+		pool := newTestPool(ForwardStrategy__RoundRobin, &testUpstream{name: "a"}, &testUpstream{name: "b"}, &testUpstream{name: "c"})
+		pool.markUnhealthy(pool.upstreams[1])
+		pool.markUnhealthy(pool.upstreams[0])
+
+		assert.Equal(t, []string{"c", "b", "a"}, names(pool.order()))
+	})
+}
+
+func TestUpstreamPool_observe(t *testing.T) {
+	pool := newTestPool(ForwardStrategy__LowestLatency, &testUpstream{name: "a"})
+	state := pool.upstreams[0]
+
+	pool.markUnhealthy(state)
+	pool.observe(state, 100*time.Millisecond)
+	pool.observe(state, 200*time.Millisecond)
+
+	assert.Equal(t, 130*time.Millisecond, state.rtt)
+	assert.True(t, state.unhealthyUntil.IsZero())
+}
+
+func TestUpstreamPool_Exchange(t *testing.T) {
+	testCases := []struct {
+		name              string
+		upstreams         []*testUpstream
+		expectedCode      message.ResponseCode
+		expectedErr       bool
+		expectedQueried   []int
+		expectedUnhealthy []int
+	}{
+		{
+			name:            "Query should be answered by the first upstream",
+			upstreams:       []*testUpstream{{name: "a"}, {name: "b"}},
+			expectedQueried: []int{1, 0},
+		},
+		{
+			name:              "Failed upstream should be marked unhealthy and the query retried on the next one",
+			upstreams:         []*testUpstream{{name: "a", unavailable: true}, {name: "b"}},
+			expectedQueried:   []int{1, 1},
+			expectedUnhealthy: []int{0},
+		},
+		{
+			name:              "Upstream not answering within its share of the deadline should be marked unhealthy",
+			upstreams:         []*testUpstream{{name: "a", delay: time.Second}, {name: "b"}},
+			expectedQueried:   []int{1, 1},
+			expectedUnhealthy: []int{0},
+		},
+		{
+			name:            "SERVFAIL should be retried on the next upstream without marking it unhealthy",
+			upstreams:       []*testUpstream{{name: "a", code: message.ResponseCode__ServFail}, {name: "b"}},
+			expectedQueried: []int{1, 1},
+		},
+		{
+			name:              "SERVFAIL should be returned when no upstream did better",
+			upstreams:         []*testUpstream{{name: "a", code: message.ResponseCode__ServFail}, {name: "b", unavailable: true}},
+			expectedCode:      message.ResponseCode__ServFail,
+			expectedQueried:   []int{1, 1},
+			expectedUnhealthy: []int{1},
+		},
+		{
+			name:              "Query should fail when no upstream answered",
+			upstreams:         []*testUpstream{{name: "a", unavailable: true}, {name: "b", unavailable: true}},
+			expectedErr:       true,
+			expectedQueried:   []int{1, 1},
+			expectedUnhealthy: []int{0, 1},
+		},
+		{
+			name:              "Upstream cut off by the deadline of the query should not be marked unhealthy",
+			upstreams:         []*testUpstream{{name: "a", delay: 5 * time.Second}, {name: "b", delay: 5 * time.Second}},
+			expectedErr:       true,
+			expectedQueried:   []int{1, 1},
+			expectedUnhealthy: []int{0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := newTestPool(ForwardStrategy__RoundRobin, tc.upstreams...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+			defer cancel()
+
+			msg := newTestMessage([]string{"www", "example", "org"}, record.ResourceRecordType__A)
+			response, err := pool.Exchange(ctx, msg)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			}
+
+			unhealthy := make([]int, 0)
+			for i, upstream := range tc.upstreams {
+				assert.Len(t, upstream.queries, tc.expectedQueried[i], upstream.name)
+				if !pool.upstreams[i].unhealthyUntil.IsZero() {
+					unhealthy = append(unhealthy, i)
+				}
+			}
+			assert.ElementsMatch(t, tc.expectedUnhealthy, unhealthy)
+		})
+	}
+}
+
+func TestForwarder_Middleware(t *testing.T) {
+	testCases := []struct {
+		name             string
+		allow            []string
+		unavailable      bool
+		recursionDesired bool
+		expectedCode     message.ResponseCode
+		expectedAnswers  int
+	}{
+		{
+			name:             "Query of allowed client should be forwarded",
+			allow:            DEFAULT_RECURSION_ALLOW,
+			recursionDesired: true,
+			expectedAnswers:  1,
+		},
+		{
+			name:         "Query without RD flag should be passed on",
+			allow:        DEFAULT_RECURSION_ALLOW,
+			expectedCode: message.ResponseCode__Refused,
+		},
+		{
+			name:             "Query of client not allowed to use recursion should be passed on",
+			allow:            []string{"10.0.0.0/8"},
+			recursionDesired: true,
+			expectedCode:     message.ResponseCode__Refused,
+		},
+		{
+			name:             "Query no upstream answered should get SERVFAIL",
+			allow:            DEFAULT_RECURSION_ALLOW,
+			unavailable:      true,
+			recursionDesired: true,
+			expectedCode:     message.ResponseCode__ServFail,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := DefaultConfig().Forward
+			config.Upstreams = []string{"192.0.2.53"}
+
+			forwarder, err := NewForwarder(config, tc.allow, nil)
+			assert.NoError(t, err)
+
+			upstream := &testUpstream{name: "stub", unavailable: tc.unavailable}
+			forwarder.pool = newTestPool(ForwardStrategy__Random, upstream)
+
+			msg := newTestMessage([]string{"www", "example", "org"}, record.ResourceRecordType__A)
+			msg.Header.Flags.RecursionDesired = tc.recursionDesired
+			msg.SetEDNS(&message.EDNS{UDPPayloadSize: 4096, DNSSECOk: true, Options: []message.EDNSOption{{Code: message.EDNSOption__Padding}}})
+
+			writer := &testResponseWriter{}
+			forwarder.Middleware(RefusedHandler).ServeDNS(context.Background(), writer, msg)
+
+			assert.NotNil(t, writer.response)
+			assert.Equal(t, tc.expectedCode, writer.response.Header.Flags.ResponseCode)
+			assert.False(t, writer.response.Header.Flags.AuthorativeAnswer)
+			assert.Len(t, writer.response.Body.Answers, tc.expectedAnswers)
+
+			if len(upstream.queries) > 0 {
+				edns, err := upstream.queries[0].EDNS()
+				assert.NoError(t, err)
+				assert.True(t, upstream.queries[0].Header.Flags.RecursionDesired)
+				assert.Equal(t, uint16(client.EDNS_UDP_PAYLOAD_SIZE), edns.UDPPayloadSize)
+				assert.True(t, edns.DNSSECOk)
+				assert.Empty(t, edns.Options)
+			}
+		})
+	}
+}
+
+func TestForwarder_rules(t *testing.T) {
+
+	config := DefaultConfig().Forward
+	config.Upstreams = []string{"192.0.2.53"}
+	config.Rules = []managementserver.ManagedForwardRule{
+		{Domain: "corp.internal", Upstreams: []string{"10.0.0.53"}, NoCache: true},
+		{Domain: "svc.cluster.local", Upstreams: []string{"10.96.0.10"}, StripECS: true},
+		{Domain: "cluster.local", Upstreams: []string{"10.96.0.11"}},
+	}
+
+	repository := managementserver.NewMemoryRecordsRepository(nil)
+	repository.CreateForwardRule(context.Background(), &managementserver.ManagedForwardRule{Domain: "lab.corp.internal", Upstreams: []string{"10.1.0.53"}})
+
+	forwarder, err := NewForwarder(config, DEFAULT_RECURSION_ALLOW, repository)
+	assert.NoError(t, err)
+	assert.NoError(t, forwarder.Reload(context.Background()))
+
+	// Upstreams of the rules are replaced with stubs named after the rules
+	upstreams := map[string]*testUpstream{"default": {name: "default"}}
+	forwarder.pool = newTestPool(ForwardStrategy__Random, upstreams["default"])
+	for _, rule := range *forwarder.rules.Load() {
+		upstreams[rule.key] = &testUpstream{name: rule.key}
+		rule.pool = newTestPool(ForwardStrategy__Random, upstreams[rule.key])
+	}
+
+	testCases := []struct {
+		name             string
+		query            []string
+		expectedUpstream string
+		expectedNoCache  bool
+		expectedECS      bool
+	}{
+		{
+			name:             "Name below domain of a rule should be forwarded to its upstreams",
+			query:            []string{"dc1", "CORP", "internal"},
+			expectedUpstream: "config/1",
+			expectedNoCache:  true,
+			expectedECS:      true,
+		},
+		{
+			name:             "Managed rule with longer domain should win over rule of the config",
+			query:            []string{"host", "lab", "corp", "internal"},
+			expectedUpstream: "api/1",
+			expectedECS:      true,
+		},
+		{
+			name:             "Rule with the longest domain should win regardless of order",
+			query:            []string{"kube-dns", "kube-system", "svc", "cluster", "local"},
+			expectedUpstream: "config/2",
+		},
+		{
+			name:             "Shorter domain should match names outside of the longer one",
+			query:            []string{"node1", "cluster", "local"},
+			expectedUpstream: "config/3",
+			expectedECS:      true,
+		},
+		{
+			name:             "Name matching no rule should be forwarded to forward.upstreams",
+			query:            []string{"www", "example", "org"},
+			expectedUpstream: "default",
+			expectedECS:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, upstream := range upstreams {
+				upstream.queries = nil
+			}
+
+			msg := newTestMessage(tc.query, record.ResourceRecordType__A)
+			msg.Header.Flags.RecursionDesired = true
+			msg.SetEDNS(&message.EDNS{UDPPayloadSize: 4096, Options: []message.EDNSOption{{Code: message.EDNSOption__ClientSubnet, Data: []byte{0, 1, 24, 0, 192, 0, 2}}}})
+
+			var noCache bool
+			ctx := withNoCache(context.Background(), &noCache)
+
+			writer := &testResponseWriter{}
+			forwarder.Middleware(RefusedHandler).ServeDNS(ctx, writer, msg)
+
+			for key, upstream := range upstreams {
+				if key != tc.expectedUpstream {
+					assert.Empty(t, upstream.queries, key)
+				}
+			}
+
+			queries := upstreams[tc.expectedUpstream].queries
+			assert.Len(t, queries, 1)
+			assert.Equal(t, tc.expectedNoCache, CacheDisabled(ctx))
+
+			edns, err := queries[0].EDNS()
+			assert.NoError(t, err)
+			_, hasECS := edns.Option(message.EDNSOption__ClientSubnet)
+			assert.Equal(t, tc.expectedECS, hasECS)
+		})
+	}
+}
+
+func TestForwarder_Reload(t *testing.T) {
+
+	repository := managementserver.NewMemoryRecordsRepository(nil)
+	repository.CreateForwardRule(context.Background(), &managementserver.ManagedForwardRule{Domain: "corp.internal", Upstreams: []string{"10.0.0.53"}})
+
+	forwarder, err := NewForwarder(DefaultConfig().Forward, DEFAULT_RECURSION_ALLOW, repository)
+	assert.NoError(t, err)
+	assert.Nil(t, forwarder.match([]string{"dc1", "corp", "internal"}))
+
+	assert.NoError(t, forwarder.Reload(context.Background()))
+	rule := forwarder.match([]string{"dc1", "corp", "internal"})
+	assert.NotNil(t, rule)
+
+	// Loaded rules keep their upstreams, with health of the upstreams
+	repository.CreateForwardRule(context.Background(), &managementserver.ManagedForwardRule{Domain: "cluster.local", Upstreams: []string{"10.96.0.10"}})
+	assert.NoError(t, forwarder.Reload(context.Background()))
+	assert.Same(t, rule, forwarder.match([]string{"dc1", "corp", "internal"}))
+	assert.NotNil(t, forwarder.match([]string{"svc", "cluster", "local"}))
+
+	repository.DeleteForwardRule(context.Background(), 1)
+	assert.NoError(t, forwarder.Reload(context.Background()))
+	assert.Nil(t, forwarder.match([]string{"dc1", "corp", "internal"}))
+
+	// Names matching no rule are passed on when no forward.upstreams are configured
+	msg := newTestMessage([]string{"dc1", "corp", "internal"}, record.ResourceRecordType__A)
+	msg.Header.Flags.RecursionDesired = true
+
+	writer := &testResponseWriter{}
+	forwarder.Middleware(RefusedHandler).ServeDNS(context.Background(), writer, msg)
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__Refused), writer.response.Header.Flags.ResponseCode)
+}
+
+// Source reporting a change of forward rules for every value sent on the channel
+type testForwardRulesSource struct {
+	changes chan struct{}
+}
+
+func (s *testForwardRulesSource) WatchForwardRulesChanges(ctx context.Context, listening func(), changed func()) error {
+	listening()
+	for {
+		select {
+		case <-s.changes:
+			changed()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestForwarder_watch(t *testing.T) {
+
+	repository := managementserver.NewMemoryRecordsRepository(nil)
+	repository.CreateForwardRule(context.Background(), &managementserver.ManagedForwardRule{Domain: "corp.internal", Upstreams: []string{"10.0.0.53"}})
+
+	forwarder, err := NewForwarder(DefaultConfig().Forward, DEFAULT_RECURSION_ALLOW, repository)
+	assert.NoError(t, err)
+
+	source := &testForwardRulesSource{changes: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		forwarder.watch(ctx, source)
+		close(done)
+	}()
+
+	// Rules are reloaded once listening starts, so the change is applied once the next one is received
+	source.changes <- struct{}{}
+	assert.NotNil(t, forwarder.match([]string{"dc1", "corp", "internal"}))
+
+	repository.DeleteForwardRule(context.Background(), 1)
+	source.changes <- struct{}{}
+	source.changes <- struct{}{}
+	assert.Nil(t, forwarder.match([]string{"dc1", "corp", "internal"}))
+
+	cancel()
+	<-done
+}
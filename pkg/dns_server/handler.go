@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+// Ordered, comma separated list of plugins handling requests, e.g. "log,metrics,authoritative"
+const PLUGINS_KEY = "DNS_PLUGINS"
+
+const DEFAULT_PLUGINS = "log,metrics,acl,authoritative"
+
+// Sends response to the client over the transport the request was received on
+type ResponseWriter interface {
+	WriteMsg(msg *message.Message) error
+	RemoteAddr() net.Addr
+	Transport() string
+}
+
+// Answers DNS requests. Handlers either write the response or pass the request on to the next handler
+type Handler interface {
+	ServeDNS(ctx context.Context, w ResponseWriter, msg *message.Message)
+}
+
+type HandlerFunc func(ctx context.Context, w ResponseWriter, msg *message.Message)
+
+func (f HandlerFunc) ServeDNS(ctx context.Context, w ResponseWriter, msg *message.Message) {
+	f(ctx, w, msg)
+}
+
+// Wraps the handler, adding behaviour before or after it
+type Middleware func(next Handler) Handler
+
+// Composes middlewares around the handler. The first middleware sees the request first
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Replies to the request with given response code and no records
+func WriteError(w ResponseWriter, msg *message.Message, code message.ResponseCode) error {
+	msg.ResetRRs()
+	msg.SetAsResponse()
+	msg.SetAuthorative(false)
+	msg.SetResponseCode(code)
+	return w.WriteMsg(msg)
+}
+
+// Terminates the chain, refusing requests no plugin has answered
+var RefusedHandler = HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+	WriteError(w, msg, message.ResponseCode__Refused)
+})
+
+// Creates middleware of the plugin for the server
+type PluginFactory func(s *Server) (Middleware, error)
+
+var plugins = map[string]PluginFactory{
+	"log":           newLogPlugin,
+	"metrics":       newMetricsPlugin,
+	"acl":           newACLPlugin,
+	"authoritative": newAuthoritativePlugin,
+	"recursive":     newRecursivePlugin,
+	"forward":       newForwardPlugin,
+	"cache":         newCachePlugin,
+}
+
+// Makes the plugin available in the plugin list of the server
+func RegisterPlugin(name string, factory PluginFactory) {
+	plugins[name] = factory
+}
+
+// Builds handler chain from plugins given by their names, in order
+func (s *Server) buildHandler(names []string) (Handler, error) {
+
+	middlewares := make([]Middleware, 0, len(names))
+	for _, name := range names {
+		factory, ok := plugins[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown plugin: %s", name))
+		}
+
+		middleware, err := factory(s)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to create plugin %s: %s", name, err.Error()))
+		}
+
+		middlewares = append(middlewares, middleware)
+	}
+
+	return Chain(RefusedHandler, middlewares...), nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	order := make([]string, 0)
+
+	tracing := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+				order = append(order, name)
+				next.ServeDNS(ctx, w, msg)
+			})
+		}
+	}
+
+	handler := Chain(RefusedHandler, tracing("first"), tracing("second"))
+
+	w := &testResponseWriter{}
+	handler.ServeDNS(context.Background(), w, newTestMessage([]string{"example", "com"}, record.ResourceRecordType__A))
+
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__Refused), w.response.Header.Flags.ResponseCode)
+}
+
+func TestAuthoritative_ServeDNS_passesOutOfZoneQueries(t *testing.T) {
+	testCases := []struct {
+		name         string
+		query        []string
+		expectedCode message.ResponseCode
+		passed       bool
+	}{
+		{
+			name:         "Query in local zone should be answered",
+			query:        []string{"www", "example", "com"},
+			expectedCode: message.ResponseCode__NoError,
+		},
+		{
+			name:         "Query outside of local zones should be passed to the next handler",
+			query:        []string{"www", "example", "org"},
+			expectedCode: message.ResponseCode__NxDomain,
+			passed:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			passed := false
+			next := HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+				passed = true
+				WriteError(w, msg, message.ResponseCode__NxDomain)
+			})
+
+			handler := &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords), next: next}
+
+			w := &testResponseWriter{}
+			handler.ServeDNS(context.Background(), w, newTestMessage(tc.query, record.ResourceRecordType__A))
+
+			assert.Equal(t, tc.passed, passed)
+			assert.Equal(t, tc.expectedCode, w.response.Header.Flags.ResponseCode)
+		})
+	}
+}
+
+func TestServer_buildHandler(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.repository = managementserver.NewMemoryRecordsRepository(testRecords)
+
+	_, err := srv.buildHandler([]string{"log", "missing"})
+	assert.Error(t, err)
+
+	handler, err := srv.buildHandler([]string{"log", "metrics", "authoritative"})
+	assert.NoError(t, err)
+
+	for _, name := range [][]string{{"www", "example", "com"}, {"www", "example", "org"}} {
+		handler.ServeDNS(context.Background(), &testResponseWriter{}, newTestMessage(name, record.ResourceRecordType__A))
+	}
+
+	snapshot := srv.metrics.Snapshot()
+	assert.Equal(t, uint64(2), snapshot.Requests[TRANSPORT_UDP])
+	assert.Equal(t, uint64(1), snapshot.Responses[message.ResponseCode__NoError])
+	assert.Equal(t, uint64(1), snapshot.Responses[message.ResponseCode__Refused])
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+// Keeps the response in memory instead of sending it
+type testResponseWriter struct {
+	response *message.Message
+}
+
+func (w *testResponseWriter) WriteMsg(msg *message.Message) error {
+	w.response = msg
+	return nil
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+}
+
+func (w *testResponseWriter) Transport() string {
+	return TRANSPORT_UDP
+}
+
+// Returns query for the name, every other query of the tests is built from it
+func newTestMessage(name []string, queryType record.ResourceRecordType) *message.Message {
+	msg := &message.Message{
+		Header: message.Header{
+			TransactionId: 1,
+			Flags:         message.HeaderFlags{Query: true},
+		},
+	}
+	msg.AddQuery(message.Query{
+		Name:                name,
+		ResourceRecordType:  queryType,
+		ResourceRecordClass: record.ResourceRecordClass__In,
+	})
+	return msg
+}
+
+// Returns response answering the query for the name with a single address
+func newTestAnswer(name []string, queryType record.ResourceRecordType) *message.Message {
+	msg := newTestMessage(name, queryType)
+	msg.AddAnswer(record.NewARecord(name, record.ResourceRecordClass__In, net.IPv4(192, 168, 1, 2)))
+	msg.SetAsResponse()
+	msg.UpdateRRNumbers()
+	return msg
+}
+
+// Returns encoded query prefixed with its length, as sent over TCP
+func newTestQuery(id uint16, name []string, queryType record.ResourceRecordType, edns *message.EDNS) []byte {
+	msg := newTestMessage(name, queryType)
+	msg.Header.TransactionId = id
+	msg.SetEDNS(edns)
+
+	encoded := message.NewEncoder().Encode(msg)
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(encoded))), encoded...)
+}
+
+// Creates server with the default config, handling requests with the handler without listening on any transport
+func newTestServer(t *testing.T, handler Handler) *Server {
+	t.Helper()
+
+	srv := newServer(DefaultConfig())
+	srv.handler = handler
+	t.Cleanup(func() { srv.Close() })
+
+	return srv
+}
+
+// Sends query to the server over loopback and returns the decoded response
+func exchange(t *testing.T, srv *Server, name string, queryType record.ResourceRecordType) *message.Message {
+	t.Helper()
+
+	serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer serverConn.Close()
+
+	clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer clientConn.Close()
+
+	msg := newTestMessage(strings.Split(name, "."), queryType)
+
+	writer := &udpResponseWriter{conn: serverConn, addr: clientConn.LocalAddr().(*net.UDPAddr)}
+	req, err := NewRequest(message.NewEncoder().Encode(msg), TRANSPORT_UDP, writer)
+	assert.NoError(t, err)
+
+	srv.HandleRequest(req)
+
+	buf := make([]byte, 4096)
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := clientConn.ReadFromUDP(buf)
+	assert.NoError(t, err)
+
+	var response message.Message
+	assert.NoError(t, message.NewDecoder(buf[:n]).Decode(&response))
+
+	return &response
+}
+
+func sectionTypes(section []message.Answer) []record.ResourceRecordType {
+	if len(section) == 0 {
+		return nil
+	}
+
+	types := make([]record.ResourceRecordType, 0, len(section))
+	for _, answer := range section {
+		types = append(types, answer.ResourceRecordType)
+	}
+	return types
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+// Intercepts the response written by the rest of the chain
+type recordingWriter struct {
+	ResponseWriter
+	response *message.Message
+}
+
+func (w *recordingWriter) WriteMsg(msg *message.Message) error {
+	w.response = msg
+	return w.ResponseWriter.WriteMsg(msg)
+}
+
+// Logs every request together with the response and the time it took to answer it
+func LoggingMiddleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+		slog.Info("Got message", "transport", w.Transport(), "addr", w.RemoteAddr(), "msg", *msg)
+
+		start := time.Now()
+		writer := &recordingWriter{ResponseWriter: w}
+
+		next.ServeDNS(ctx, writer, msg)
+
+		if writer.response == nil {
+			slog.Info("No response", "transport", w.Transport(), "addr", w.RemoteAddr(), "duration", time.Since(start))
+			return
+		}
+
+		slog.Info("Response", "msg", *writer.response, "duration", time.Since(start))
+	})
+}
+
+func newLogPlugin(s *Server) (Middleware, error) {
+	return LoggingMiddleware, nil
+}
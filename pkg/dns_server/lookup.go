@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+const WILDCARD_LABEL = "*"
+
+type lookupResult struct {
+	records []managementserver.ManagedDNSResourceRecord
+
+	// Target of the CNAME returned in place of records of the queried type
+	alias []string
+
+	// False when neither the name nor a matching wildcard exists (NXDOMAIN)
+	exists bool
+}
+
+// Returns records answering the query within the zone. Names not present in the zone
+// are answered from the wildcard at their closest encloser (RFC 4592 §3.3)
+func (a *Authoritative) lookup(ctx context.Context, query message.Query, zone *managementserver.Zone) (*lookupResult, error) {
+
+	records, alias, err := a.getRecordsOrAlias(ctx, query, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 {
+		return &lookupResult{records: records, alias: alias, exists: true}, nil
+	}
+
+	exists, err := a.nameExists(ctx, query.Name, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Explicit names, including empty non-terminals, are never answered from wildcards
+	if exists {
+		return &lookupResult{exists: true}, nil
+	}
+
+	encloser, err := a.closestEncloser(ctx, query.Name, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	wildcard := append([]string{WILDCARD_LABEL}, encloser...)
+
+	wildcardExists, err := a.nameExists(ctx, wildcard, zone)
+	if err != nil || !wildcardExists {
+		return &lookupResult{exists: false}, err
+	}
+
+	wildcardQuery := query
+	wildcardQuery.Name = wildcard
+
+	records, alias, err = a.getRecordsOrAlias(ctx, wildcardQuery, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Synthesized records are owned by the queried name, not by the wildcard
+	for i := range records {
+		records[i].Name = strings.Join(query.Name, ".")
+	}
+
+	return &lookupResult{records: records, alias: alias, exists: true}, nil
+}
+
+// Returns records of the queried type or, when there are none, the CNAME owned by the name
+// together with its target
+func (a *Authoritative) getRecordsOrAlias(
+	ctx context.Context,
+	query message.Query,
+	zone *managementserver.Zone,
+) ([]managementserver.ManagedDNSResourceRecord, []string, error) {
+
+	records, err := a.getRecords(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Explicit records always win over synthesized ones
+	if len(records) == 0 && query.ResourceRecordType == record.ResourceRecordType__PTR && a.synthesizesPTR(zone) {
+		records, err = a.synthesizePTRRecords(ctx, query.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(records) > 0 || query.ResourceRecordType == record.ResourceRecordType__CNAME {
+		return records, nil, nil
+	}
+
+	cnameQuery := query
+	cnameQuery.ResourceRecordType = record.ResourceRecordType__CNAME
+
+	records, err = a.getRecords(ctx, cnameQuery)
+	if err != nil || len(records) == 0 {
+		return nil, nil, err
+	}
+
+	rr, err := records[0].ConvertToResourceRecord()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cname, ok := rr.(*record.CNAMERecord)
+	if !ok {
+		return nil, nil, errors.New("record of type CNAME could not be converted to CNAME record")
+	}
+
+	return records, cname.Domain(), nil
+}
+
+// Returns the deepest existing ancestor of the name within the zone
+func (a *Authoritative) closestEncloser(ctx context.Context, name []string, zone *managementserver.Zone) ([]string, error) {
+
+	for i := 1; i < len(name); i++ {
+		ancestor := name[i:]
+
+		if !zone.Contains(strings.Join(ancestor, ".")) {
+			break
+		}
+
+		exists, err := a.nameExists(ctx, ancestor, zone)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			return ancestor, nil
+		}
+	}
+
+	// Zone apex always exists, as it owns the SOA record
+	return strings.Split(zone.Name, "."), nil
+}
+
+// Returns NS records of the topmost zone cut at or above the queried name within the zone,
+// or nil when the name is not delegated away from the zone
+func (a *Authoritative) findDelegation(ctx context.Context, query message.Query, zone *managementserver.Zone) ([]managementserver.ManagedDNSResourceRecord, error) {
+
+	recordClass, err := managementserver.ConvertCodeToRecordClass(query.ResourceRecordClass)
+	if err != nil {
+		return nil, err
+	}
+
+	zoneLabels := len(strings.Split(zone.Name, "."))
+
+	// NS records at the apex describe the zone itself, not a delegation
+	for i := len(query.Name) - zoneLabels - 1; i >= 0; i-- {
+		records, err := a.records(ctx).GetRecordsByNameAndType(
+			ctx,
+			strings.Join(query.Name[i:], "."),
+			managementserver.ManagedDNSRecordType_NS,
+			recordClass,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) > 0 {
+			return records, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns A and AAAA records of the name servers lying within the zone (in-bailiwick glue)
+func (a *Authoritative) findGlue(
+	ctx context.Context,
+	nsRecords []managementserver.ManagedDNSResourceRecord,
+	zone *managementserver.Zone,
+) ([]managementserver.ManagedDNSResourceRecord, error) {
+
+	glue := make([]managementserver.ManagedDNSResourceRecord, 0)
+
+	for _, ns := range nsRecords {
+		target, ok := ns.Target()
+		if !ok || !zone.Contains(target) {
+			continue
+		}
+
+		for _, addressType := range []managementserver.ManagedDNSRecordType{
+			managementserver.ManagedDNSRecordType_A,
+			managementserver.ManagedDNSRecordType_AAAA,
+		} {
+			records, err := a.records(ctx).GetRecordsByNameAndType(ctx, target, addressType, ns.Class)
+			if err != nil {
+				return nil, err
+			}
+
+			glue = append(glue, records...)
+		}
+	}
+
+	return glue, nil
+}
+
+// Returns records matching name, type and class of the query
+func (a *Authoritative) getRecords(ctx context.Context, query message.Query) ([]managementserver.ManagedDNSResourceRecord, error) {
+
+	recordClass, err := managementserver.ConvertCodeToRecordClass(query.ResourceRecordClass)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.Join(query.Name, ".")
+
+	if query.ResourceRecordType == record.ResourceRecordType__ANY {
+		return a.getAllRecords(ctx, name, recordClass)
+	}
+
+	// Types which can't be managed have no records, so the name gets NODATA
+	recordType, err := managementserver.ConvertCodeToRecordType(query.ResourceRecordType)
+	if err != nil {
+		return nil, nil
+	}
+
+	return a.records(ctx).GetRecordsByNameAndType(ctx, name, recordType, recordClass)
+}
+
+// Returns records of all types owned by the name
+func (a *Authoritative) getAllRecords(
+	ctx context.Context,
+	name string,
+	recordClass managementserver.ManagedDNSRecordClass,
+) ([]managementserver.ManagedDNSResourceRecord, error) {
+
+	records, err := a.records(ctx).GetRecordsByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make([]managementserver.ManagedDNSResourceRecord, 0, len(records))
+	for _, r := range records {
+		if r.Class == recordClass {
+			matching = append(matching, r)
+		}
+	}
+
+	return matching, nil
+}
+
+// Checks whether the name owns records of any type or has descendants owning them
+func (a *Authoritative) nameExists(ctx context.Context, name []string, zone *managementserver.Zone) (bool, error) {
+
+	joined := strings.Join(name, ".")
+
+	exists, err := a.records(ctx).NameExists(ctx, joined)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	exists, err = a.records(ctx).HasDescendants(ctx, joined)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	if !a.synthesizesPTR(zone) {
+		return false, nil
+	}
+
+	synthesized, err := a.synthesizePTRRecords(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	return len(synthesized) > 0, nil
+}
+
+// Returns zone enclosing the queried name, or nil if the server is not authoritative for it.
+// Zones the view of the request doesn't define are served from the default view
+func (a *Authoritative) findZone(ctx context.Context, query message.Query) (*managementserver.Zone, error) {
+
+	name := strings.Join(query.Name, ".")
+
+	zones, err := a.records(ctx).GetZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := managementserver.FindZone(zones, name)
+	if zone != nil || ViewFromContext(ctx) == managementserver.DEFAULT_VIEW {
+		return zone, nil
+	}
+
+	zones, err = a.repository.ForView(managementserver.DEFAULT_VIEW).GetZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return managementserver.FindZone(zones, name), nil
+}
+
+// Converts managed records into canonical RRsets
+func buildRRSets(records []managementserver.ManagedDNSResourceRecord) ([]*record.RRSet, error) {
+
+	sets := record.NewRRSetBuilder()
+	for _, managedRecord := range records {
+		rr, err := managedRecord.ConvertToResourceRecord()
+		if err != nil {
+			return nil, err
+		}
+		sets.Add(rr, managedRecord.Ttl)
+	}
+
+	return sets.Build(), nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+// Counters of requests handled by the server
+type Metrics struct {
+	lock      sync.Mutex
+	requests  map[string]uint64
+	responses map[message.ResponseCode]uint64
+	dropped   uint64
+	shed      uint64
+	limited   map[RRLAction]uint64
+	aclHits   map[string]uint64
+
+	cacheHits   uint64
+	cacheMisses uint64
+
+	rrlTableSize uint64
+	rrlEvicted   uint64
+}
+
+// Copy of the counters taken at a single point in time
+type MetricsSnapshot struct {
+	Requests  map[string]uint64
+	Responses map[message.ResponseCode]uint64
+	Dropped   uint64
+
+	// Requests rejected before reaching the handler chain, because the server was overloaded
+	Shed uint64
+
+	// Responses over the rate limit, per decision taken about them
+	RateLimited map[RRLAction]uint64
+
+	// Accounts of the rate limiter, and ones forgotten before their time because the table was full
+	RRLTableSize uint64
+	RRLEvicted   uint64
+
+	// Queries matched by ACL rules, per rule
+	ACLHits map[string]uint64
+
+	// Queries answered from the cache, and ones passed on because their responses were not cached
+	CacheHits   uint64
+	CacheMisses uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[string]uint64),
+		responses: make(map[message.ResponseCode]uint64),
+		limited:   make(map[RRLAction]uint64),
+		aclHits:   make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) countRequest(transport string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[transport]++
+}
+
+func (m *Metrics) countResponse(code message.ResponseCode) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.responses[code]++
+}
+
+func (m *Metrics) countDropped() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.dropped++
+}
+
+func (m *Metrics) countShed() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.shed++
+}
+
+func (m *Metrics) countRateLimited(action RRLAction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.limited[action]++
+}
+
+func (m *Metrics) setRRLTableSize(size int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rrlTableSize = uint64(size)
+}
+
+func (m *Metrics) countRRLEviction() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rrlEvicted++
+}
+
+func (m *Metrics) countACLHit(rule string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.aclHits[rule]++
+}
+
+func (m *Metrics) countCacheHit() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cacheHits++
+}
+
+func (m *Metrics) countCacheMiss() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cacheMisses++
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Requests:     make(map[string]uint64, len(m.requests)),
+		Responses:    make(map[message.ResponseCode]uint64, len(m.responses)),
+		Dropped:      m.dropped,
+		Shed:         m.shed,
+		RateLimited:  make(map[RRLAction]uint64, len(m.limited)),
+		RRLTableSize: m.rrlTableSize,
+		RRLEvicted:   m.rrlEvicted,
+		ACLHits:      make(map[string]uint64, len(m.aclHits)),
+		CacheHits:    m.cacheHits,
+		CacheMisses:  m.cacheMisses,
+	}
+
+	for transport, count := range m.requests {
+		snapshot.Requests[transport] = count
+	}
+
+	for code, count := range m.responses {
+		snapshot.Responses[code] = count
+	}
+
+	for action, count := range m.limited {
+		snapshot.RateLimited[action] = count
+	}
+
+	for rule, count := range m.aclHits {
+		snapshot.ACLHits[rule] = count
+	}
+
+	return snapshot
+}
+
+// Counts requests per transport and responses per response code. Requests left unanswered are counted as dropped
+func (m *Metrics) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+		m.countRequest(w.Transport())
+
+		writer := &recordingWriter{ResponseWriter: w}
+		next.ServeDNS(ctx, writer, msg)
+
+		if writer.response == nil {
+			m.countDropped()
+			return
+		}
+
+		m.countResponse(writer.response.Header.Flags.ResponseCode)
+	})
+}
+
+func newMetricsPlugin(s *Server) (Middleware, error) {
+	return s.metrics.Middleware, nil
+}
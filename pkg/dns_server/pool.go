@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+const (
+	// Number of workers handling requests
+	WORKERS_KEY = "DNS_WORKERS"
+
+	// Number of requests waiting for a free worker, after which new ones are shed
+	QUEUE_SIZE_KEY = "DNS_QUEUE_SIZE"
+
+	// Selects how requests are shed when the queue is full, see OverloadAction
+	OVERLOAD_ACTION_KEY = "DNS_OVERLOAD_ACTION"
+)
+
+const (
+	DEFAULT_WORKERS    = 64
+	DEFAULT_QUEUE_SIZE = 1024
+)
+
+// Response to requests shed when all workers are busy and the queue is full
+type OverloadAction string
+
+const (
+	// Ignore the request, the client retries later
+	OverloadAction__Drop OverloadAction = "drop"
+
+	// Answer with REFUSED, so the client tries another server
+	OverloadAction__Refused OverloadAction = "refused"
+
+	// Answer with SERVFAIL
+	OverloadAction__ServFail OverloadAction = "servfail"
+)
+
+func NewOverloadAction(action string) (OverloadAction, error) {
+	switch OverloadAction(action) {
+	case OverloadAction__Drop:
+		return OverloadAction__Drop, nil
+	case OverloadAction__Refused:
+		return OverloadAction__Refused, nil
+	case OverloadAction__ServFail:
+		return OverloadAction__ServFail, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Invalid overload action: %s, expected drop, refused or servfail", action))
+	}
+}
+
+// Runs jobs on a fixed number of workers, queueing a limited number of them
+type workerPool struct {
+	jobs chan func()
+	done chan struct{}
+	once sync.Once
+}
+
+func newWorkerPool(workers int, queueSize int) *workerPool {
+
+	pool := &workerPool{
+		jobs: make(chan func(), queueSize),
+		done: make(chan struct{}),
+	}
+
+	for range workers {
+		go pool.work()
+	}
+
+	return pool
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Queues the job, returns false without blocking when all workers are busy and the queue is full
+func (p *workerPool) submit(job func()) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stops the workers, jobs still queued are abandoned
+func (p *workerPool) stop() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// Free list of receive buffers shared by all UDP sockets, so they are reused instead of allocated
+// for every packet. It keeps as many buffers as there can be requests handled or queued at once
+type bufferPool struct {
+	buffers chan []byte
+	size    int
+}
+
+func newBufferPool(count int, size int) *bufferPool {
+	return &bufferPool{
+		buffers: make(chan []byte, count),
+		size:    size,
+	}
+}
+
+func (p *bufferPool) get() []byte {
+	select {
+	case buf := <-p.buffers:
+		return buf
+	default:
+		return make([]byte, p.size)
+	}
+}
+
+func (p *bufferPool) put(buf []byte) {
+	select {
+	case p.buffers <- buf[:cap(buf)]:
+	default:
+	}
+}
+
+// Queues the request for a worker, shedding it when the server is overloaded.
+// Release is called once the request is handled or shed
+func (s *Server) dispatch(req *Request, release func()) {
+
+	s.inFlight.Add(1)
+
+	accepted := s.pool.submit(func() {
+		defer s.inFlight.Done()
+		defer release()
+		defer recoverRequest(req)
+		s.HandleRequest(req)
+	})
+
+	if accepted {
+		return
+	}
+
+	s.inFlight.Done()
+	defer release()
+
+	s.shed(req)
+}
+
+// Keeps the worker and the rest of the server running when handling of the request panics
+func recoverRequest(req *Request) {
+	if r := recover(); r != nil {
+		slog.Error("Recovered from panic while handling request", "transport", req.transport, "addr", req.RemoteAddr(), "panic", r)
+	}
+}
+
+// Answers the request according to the overload action, without passing it through the handler chain
+func (s *Server) shed(req *Request) {
+
+	if s.metrics != nil {
+		s.metrics.countShed()
+	}
+
+	slog.Debug("Shedding request, all workers are busy", "transport", req.transport, "addr", req.RemoteAddr())
+
+	switch s.overloadAction {
+	case OverloadAction__Refused:
+		WriteError(req, req.msg, message.ResponseCode__Refused)
+	case OverloadAction__ServFail:
+		WriteError(req, req.msg, message.ResponseCode__ServFail)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPool_submit(t *testing.T) {
+	pool := newWorkerPool(1, 1)
+	defer pool.stop()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	assert.True(t, pool.submit(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	// Single worker is busy, so one job fits in the queue and the next one is rejected
+	assert.True(t, pool.submit(func() {}))
+	assert.False(t, pool.submit(func() {}))
+
+	close(release)
+}
+
+func TestServer_dispatch_shedsRequests(t *testing.T) {
+	testCases := []struct {
+		name         string
+		action       OverloadAction
+		expectedCode message.ResponseCode
+		answered     bool
+	}{
+		{
+			name:   "Shed request should be dropped",
+			action: OverloadAction__Drop,
+		},
+		{
+			name:         "Shed request should be refused",
+			action:       OverloadAction__Refused,
+			expectedCode: message.ResponseCode__Refused,
+			answered:     true,
+		},
+		{
+			name:         "Shed request should get SERVFAIL",
+			action:       OverloadAction__ServFail,
+			expectedCode: message.ResponseCode__ServFail,
+			answered:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			release := make(chan struct{})
+			config := DefaultConfig()
+			config.Limits.Workers = 1
+			config.Limits.QueueSize = 0
+			config.Limits.OverloadAction = tc.action
+
+			srv := newServer(config)
+			srv.handler = HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+				<-release
+			})
+			defer srv.Close()
+
+			// Worker is kept busy by the first request
+			busy := &Request{msg: newTestMessage([]string{"example", "com"}, record.ResourceRecordType__A), transport: TRANSPORT_UDP, writer: &bufferResponseWriter{}}
+			for !srv.pool.submit(func() { srv.HandleRequest(busy) }) {
+				time.Sleep(time.Millisecond)
+			}
+
+			writer := &bufferResponseWriter{addr: &net.UDPAddr{}}
+			req := &Request{msg: newTestMessage([]string{"example", "com"}, record.ResourceRecordType__A), transport: TRANSPORT_UDP, writer: writer}
+
+			released := false
+			srv.dispatch(req, func() { released = true })
+			close(release)
+
+			assert.True(t, released)
+			assert.Equal(t, uint64(1), srv.metrics.Snapshot().Shed)
+
+			if !tc.answered {
+				assert.Nil(t, writer.response)
+				return
+			}
+
+			var response message.Message
+			assert.NoError(t, message.NewDecoder(writer.response).Decode(&response))
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+		})
+	}
+}
+
+func TestListenUDP_reusePort(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+
+	conns, err := listenUDP("127.0.0.1:0", 4)
+	assert.NoError(t, err)
+
+	for _, conn := range conns {
+		defer conn.Close()
+		assert.Equal(t, conns[0].LocalAddr().String(), conn.LocalAddr().String())
+	}
+	assert.Len(t, conns, 4)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+// Enables answering reverse lookups with PTR records synthesized from A/AAAA records in every reverse zone,
+// individual zones enable it with synthesize_ptr of their SOA record
+const SYNTHESIZE_PTR_KEY = "DNS_SYNTHESIZE_PTR"
+
+// Checks whether PTR records are synthesized for names of the zone
+func (a *Authoritative) synthesizesPTR(zone *managementserver.Zone) bool {
+	return a.synthesizePTR || zone.SOA.SynthesizePTR
+}
+
+// Returns PTR records synthesized for the reverse name from A/AAAA records of its address
+func (a *Authoritative) synthesizePTRRecords(ctx context.Context, name []string) ([]managementserver.ManagedDNSResourceRecord, error) {
+
+	address, err := record.ParseReverseName(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	addressRecords, err := a.records(ctx).GetRecordsByAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return managementserver.SynthesizePTRRecords(addressRecords), nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	dnsaddress "github.com/XxRoloxX/dns/pkg/dns_address"
+	client "github.com/XxRoloxX/dns/pkg/dns_client"
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+const (
+	// Comma separated addresses of the root name servers, replacing the built-in ones
+	RECURSION_ROOT_HINTS_KEY = "DNS_RECURSION_ROOT_HINTS"
+
+	// Comma separated client addresses or CIDRs allowed to use recursion
+	RECURSION_ALLOW_KEY = "DNS_RECURSION_ALLOW"
+
+	// Seconds given to resolution of single query
+	RECURSION_TIMEOUT_KEY = "DNS_RECURSION_TIMEOUT"
+)
+
+const DEFAULT_RECURSION_TIMEOUT = 10
+
+// Clients of the local network, the resolver is not meant to be open to everyone
+var DEFAULT_RECURSION_ALLOW = []string{"127.0.0.0/8", "::1", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+type RecursionConfig struct {
+	// Addresses of the root name servers with optional port, the built-in ones when empty
+	RootHints []string `yaml:"root_hints" toml:"root_hints"`
+
+	// Client addresses or CIDRs allowed to use recursion
+	Allow []string `yaml:"allow" toml:"allow"`
+
+	// Seconds given to resolution of single query and to single name server to answer
+	Timeout      int `yaml:"timeout" toml:"timeout"`
+	QueryTimeout int `yaml:"query_timeout" toml:"query_timeout"`
+
+	// Referrals and CNAMEs followed, and nested resolutions of name servers without glue
+	MaxReferrals  int `yaml:"max_referrals" toml:"max_referrals"`
+	MaxCNAMEChain int `yaml:"max_cname_chain" toml:"max_cname_chain"`
+	MaxDepth      int `yaml:"max_depth" toml:"max_depth"`
+}
+
+func (c *RecursionConfig) Validate() error {
+
+	errs := make([]error, 0)
+
+	for _, hint := range c.RootHints {
+		if _, err := dnsaddress.ServerAddress(hint); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid recursion.root_hints: %s", err)))
+		}
+	}
+
+	if _, err := parsePrefixes(c.Allow); err != nil {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid recursion.allow: %s", err)))
+	}
+
+	for name, value := range map[string]int{
+		"timeout":         c.Timeout,
+		"query_timeout":   c.QueryTimeout,
+		"max_referrals":   c.MaxReferrals,
+		"max_cname_chain": c.MaxCNAMEChain,
+		"max_depth":       c.MaxDepth,
+	} {
+		if value <= 0 {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid recursion.%s: %d, expected a positive number", name, value)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Resolves queries the rest of the chain has not answered by iterating from the root name servers.
+// Only clients allowed to use recursion are served, and only when they asked for it with the RD flag
+type Recursive struct {
+	resolver *client.Resolver
+	allow    []netip.Prefix
+	timeout  time.Duration
+}
+
+func NewRecursive(config RecursionConfig) (*Recursive, error) {
+
+	allow, err := parsePrefixes(config.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := client.NewResolver(client.ResolverConfig{
+		RootHints:     config.RootHints,
+		MaxReferrals:  config.MaxReferrals,
+		MaxCNAMEChain: config.MaxCNAMEChain,
+		MaxDepth:      config.MaxDepth,
+		QueryTimeout:  time.Duration(config.QueryTimeout) * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recursive{
+		resolver: resolver,
+		allow:    allow,
+		timeout:  time.Duration(config.Timeout) * time.Second,
+	}, nil
+}
+
+func newRecursivePlugin(s *Server) (Middleware, error) {
+
+	recursive, err := NewRecursive(s.config.Recursion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Responses to allowed clients advertise recursion, including the ones answered by other plugins
+	s.recursive = recursive
+
+	return recursive.Middleware, nil
+}
+
+// Checks whether the client is allowed to use recursion
+func (r *Recursive) Available(remote net.Addr) bool {
+
+	if r == nil {
+		return false
+	}
+
+	addr, ok := clientAddr(remote)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range r.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Recursive) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+
+		if !msg.Header.Flags.RecursionDesired || msg.Header.Flags.OperationCode != message.OpCode__Query ||
+			len(msg.Body.Queries) != 1 || !r.Available(w.RemoteAddr()) {
+			next.ServeDNS(ctx, w, msg)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+
+		response, err := r.resolver.Resolve(ctx, msg.Body.Queries[0])
+		if err != nil {
+			slog.Warn("Failed to resolve", "query", msg.Body.Queries[0], "err", err)
+			WriteError(w, msg, message.ResponseCode__ServFail)
+			return
+		}
+
+		msg.ResetRRs()
+		msg.Body.Answers = response.Body.Answers
+		msg.Body.Authorative = response.Body.Authorative
+		msg.Body.Additional = response.Body.Additional
+
+		// OPT of the authoritative server describes its own response, the server adds its own when sending
+		msg.SetEDNS(nil)
+
+		msg.SetAsResponse()
+		msg.SetAuthorative(false)
+		msg.SetResponseCode(response.Header.Flags.ResponseCode)
+		msg.UpdateRRNumbers()
+
+		w.WriteMsg(msg)
+	})
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns recursive plugin whose name servers answer every query with an address, or fail when unavailable
+func newTestRecursive(t *testing.T, allow []string, unavailable bool) *Recursive {
+	t.Helper()
+
+	config := DefaultConfig().Recursion
+	config.Allow = allow
+
+	recursive, err := NewRecursive(config)
+	assert.NoError(t, err)
+
+	recursive.resolver.SetExchange(func(ctx context.Context, addr string, msg *message.Message) (*message.Message, error) {
+		if unavailable {
+			return nil, errors.New("network is unreachable")
+		}
+
+		response := *msg
+		response.ResetRRs()
+		response.SetAsResponse()
+		response.SetAuthorative(true)
+		response.AddAnswer(record.NewARecord(msg.Body.Queries[0].Name, record.ResourceRecordClass__In, net.IPv4(192, 0, 2, 1)))
+		response.AddAdditional(record.NewARecord([]string{"ns1", "example", "org"}, record.ResourceRecordClass__In, net.IPv4(192, 0, 2, 53)))
+		response.SetEDNS(&message.EDNS{UDPPayloadSize: 1232})
+		response.UpdateRRNumbers()
+		return &response, nil
+	})
+
+	return recursive
+}
+
+func TestRecursive_Middleware(t *testing.T) {
+	testCases := []struct {
+		name               string
+		allow              []string
+		unavailable        bool
+		recursionDesired   bool
+		expectedCode       message.ResponseCode
+		expectedAnswers    int
+		expectedAdditional []record.ResourceRecordType
+	}{
+		{
+			name:               "Query of allowed client should be resolved, without OPT of the name server",
+			allow:              DEFAULT_RECURSION_ALLOW,
+			recursionDesired:   true,
+			expectedAnswers:    1,
+			expectedAdditional: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:         "Query without RD flag should be passed on",
+			allow:        DEFAULT_RECURSION_ALLOW,
+			expectedCode: message.ResponseCode__Refused,
+		},
+		{
+			name:             "Query of client not allowed to use recursion should be passed on",
+			allow:            []string{"10.0.0.0/8"},
+			recursionDesired: true,
+			expectedCode:     message.ResponseCode__Refused,
+		},
+		{
+			name:             "Query which couldn't be resolved should get SERVFAIL",
+			allow:            DEFAULT_RECURSION_ALLOW,
+			unavailable:      true,
+			recursionDesired: true,
+			expectedCode:     message.ResponseCode__ServFail,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newTestRecursive(t, tc.allow, tc.unavailable).Middleware(RefusedHandler)
+
+			msg := newTestMessage([]string{"www", "example", "org"}, record.ResourceRecordType__A)
+			msg.Header.Flags.RecursionDesired = tc.recursionDesired
+
+			writer := &testResponseWriter{}
+			handler.ServeDNS(context.Background(), writer, msg)
+
+			assert.NotNil(t, writer.response)
+			assert.Equal(t, tc.expectedCode, writer.response.Header.Flags.ResponseCode)
+			assert.False(t, writer.response.Header.Flags.AuthorativeAnswer)
+			assert.Equal(t, tc.recursionDesired, writer.response.Header.Flags.RecursionDesired)
+			assert.Len(t, writer.response.Body.Answers, tc.expectedAnswers)
+			assert.Equal(t, tc.expectedAdditional, sectionTypes(writer.response.Body.Additional))
+		})
+	}
+}
+
+func TestServer_recursionAvailable(t *testing.T) {
+	testCases := []struct {
+		name       string
+		allow      []string
+		expectedRA bool
+	}{
+		{
+			name:       "Responses to clients allowed to use recursion should have RA set",
+			allow:      []string{"127.0.0.1"},
+			expectedRA: true,
+		},
+		{
+			name:  "Responses to other clients should have RA unset",
+			allow: []string{"10.0.0.0/8"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recursive := newTestRecursive(t, tc.allow, false)
+			repository := managementserver.NewMemoryRecordsRepository(testRecords)
+
+			srv := newTestServer(t, NewAuthoritative(repository, DefaultConfig(), recursive.Middleware(RefusedHandler)))
+			srv.recursive = recursive
+
+			response := exchange(t, srv, "www.example.com", record.ResourceRecordType__A)
+
+			assert.True(t, response.Header.Flags.AuthorativeAnswer)
+			assert.Equal(t, tc.expectedRA, response.Header.Flags.RecursionAvailable)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+// Maximum number of CNAMEs followed while answering single query
+const MAX_CNAME_CHAIN_LENGTH = 8
+
+// Outcome of resolving single query against served zones
+type resolution struct {
+	answers       []*record.RRSet
+	authority     []*record.RRSet
+	additional    []*record.RRSet
+	responseCode  message.ResponseCode
+	authoritative bool
+}
+
+// Resolves the query, following CNAME chains through all served zones
+func (a *Authoritative) resolve(ctx context.Context, query message.Query) (*resolution, error) {
+
+	result := &resolution{responseCode: message.ResponseCode__NoError}
+	visited := map[string]bool{strings.ToLower(strings.Join(query.Name, ".")): true}
+
+	for chainLength := 0; ; chainLength++ {
+		zone, err := a.findZone(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		if zone == nil {
+
+			// Target of the alias lies outside of our data, the resolver has to follow it on its own
+			if chainLength > 0 {
+				return result, nil
+			}
+
+			result.responseCode = message.ResponseCode__Refused
+			return result, nil
+		}
+
+		// Records of the zone are looked up in the view defining it
+		zoneCtx := withView(ctx, zone.SOA.View)
+
+		delegation, err := a.findDelegation(zoneCtx, query, zone)
+		if err != nil {
+			return nil, err
+		}
+
+		if delegation != nil {
+
+			// Alias pointing into delegated zone is followed by the resolver, like any other one
+			if chainLength > 0 {
+				return result, nil
+			}
+
+			return a.refer(zoneCtx, delegation, zone)
+		}
+
+		// Authority is determined by the first owner name in the chain (RFC 1035 §4.1.1)
+		if chainLength == 0 {
+			result.authoritative = true
+		}
+
+		found, err := a.lookup(zoneCtx, query, zone)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found.exists || len(found.records) == 0 {
+			soa, err := zone.NegativeSOA()
+			if err != nil {
+				return nil, err
+			}
+
+			// Response code describes the last name in the chain (RFC 6604 §3)
+			if !found.exists {
+				result.responseCode = message.ResponseCode__NxDomain
+			}
+
+			result.authority = append(result.authority, soa)
+			return result, nil
+		}
+
+		sets, err := buildRRSets(found.records)
+		if err != nil {
+			return nil, err
+		}
+
+		result.answers = append(result.answers, sets...)
+
+		if found.alias == nil {
+			result.additional, err = a.findAdditionalAddresses(zoneCtx, result.answers)
+			if err != nil {
+				return nil, err
+			}
+
+			return result, nil
+		}
+
+		target := strings.ToLower(strings.Join(found.alias, "."))
+		if visited[target] {
+			slog.Warn("CNAME loop detected", "query", query, "target", target)
+			return result, nil
+		}
+
+		if chainLength+1 >= MAX_CNAME_CHAIN_LENGTH {
+			slog.Warn("CNAME chain too long", "query", query, "target", target)
+			return result, nil
+		}
+
+		visited[target] = true
+		query.Name = found.alias
+	}
+}
+
+// Builds referral to the name servers of the delegated zone (RFC 1034 §4.3.2)
+func (a *Authoritative) refer(
+	ctx context.Context,
+	delegation []managementserver.ManagedDNSResourceRecord,
+	zone *managementserver.Zone,
+) (*resolution, error) {
+
+	authority, err := buildRRSets(delegation)
+	if err != nil {
+		return nil, err
+	}
+
+	glue, err := a.findGlue(ctx, delegation, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	additional, err := buildRRSets(glue)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resolution{
+		authority:     authority,
+		additional:    additional,
+		responseCode:  message.ResponseCode__NoError,
+		authoritative: false,
+	}, nil
+}
+
+// Returns A and AAAA records held for hosts named by MX, NS and SRV answers,
+// sparing the client a follow-up query (RFC 1035 §3.3)
+func (a *Authoritative) findAdditionalAddresses(ctx context.Context, answers []*record.RRSet) ([]*record.RRSet, error) {
+
+	addresses := make([]managementserver.ManagedDNSResourceRecord, 0)
+
+	for _, set := range answers {
+		for _, rr := range set.Records() {
+			target, ok := additionalTarget(rr)
+			if !ok {
+				continue
+			}
+
+			for _, addressType := range []record.ResourceRecordType{
+				record.ResourceRecordType__A,
+				record.ResourceRecordType__AAAA,
+			} {
+				records, err := a.getRecords(ctx, message.Query{
+					Name:                target,
+					ResourceRecordType:  addressType,
+					ResourceRecordClass: rr.Class(),
+				})
+				if err != nil {
+					return nil, err
+				}
+
+				addresses = append(addresses, records...)
+			}
+		}
+	}
+
+	return buildRRSets(addresses)
+}
+
+// Returns name of the host the record points to, if its addresses belong to the additional section
+func additionalTarget(rr record.ResourceRecord) ([]string, bool) {
+	switch r := rr.(type) {
+	case *record.MXRecord:
+		return r.Exchange(), true
+	case *record.NSRecord:
+		return r.Domain(), true
+	case *record.SRVRecord:
+		return r.Target(), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lets multiple sockets bind to the same address, so the kernel spreads packets between them
+func reusePort(network string, address string, conn syscall.RawConn) error {
+
+	var sockErr error
+
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+const reusePortSupported = true
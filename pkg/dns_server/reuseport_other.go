@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network string, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
+
+const reusePortSupported = false
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+const (
+	// Identical responses allowed per second to a single client netblock, 0 disables rate limiting
+	RRL_RESPONSES_PER_SECOND_KEY = "DNS_RRL_RESPONSES_PER_SECOND"
+
+	// Seconds over which the rate is averaged
+	RRL_WINDOW_KEY = "DNS_RRL_WINDOW"
+
+	// Every n-th limited response is sent truncated, asking legitimate clients to retry over TCP
+	RRL_SLIP_KEY = "DNS_RRL_SLIP"
+
+	// Every n-th limited response is sent in full
+	RRL_LEAK_KEY = "DNS_RRL_LEAK"
+
+	// Comma separated CIDRs of clients never limited
+	RRL_EXEMPT_KEY = "DNS_RRL_EXEMPT"
+
+	// Most accounts kept at once, the least recently used ones are forgotten to make room for new ones
+	RRL_MAX_TABLE_SIZE_KEY = "DNS_RRL_MAX_TABLE_SIZE"
+)
+
+const (
+	DEFAULT_RRL_WINDOW             = 15
+	DEFAULT_RRL_SLIP               = 2
+	DEFAULT_RRL_IPV4_PREFIX_LENGTH = 24
+	DEFAULT_RRL_IPV6_PREFIX_LENGTH = 56
+	DEFAULT_RRL_MAX_TABLE_SIZE     = 20000
+)
+
+// Decision made by the rate limiter about a response
+type RRLAction string
+
+const (
+	// Response is sent unchanged
+	RRLAction__Send RRLAction = "send"
+
+	// Response is over the limit and dropped
+	RRLAction__Drop RRLAction = "drop"
+
+	// Response is over the limit and replaced with an empty truncated one
+	RRLAction__Slip RRLAction = "slip"
+
+	// Response is over the limit, but let through
+	RRLAction__Leak RRLAction = "leak"
+)
+
+// Kind of the response, responses of different kinds are limited separately
+type responseCategory string
+
+const (
+	responseCategory__Answer   responseCategory = "answer"
+	responseCategory__NoData   responseCategory = "nodata"
+	responseCategory__NxDomain responseCategory = "nxdomain"
+	responseCategory__Referral responseCategory = "referral"
+	responseCategory__Error    responseCategory = "error"
+)
+
+type RRLConfig struct {
+	ResponsesPerSecond int      `yaml:"responses_per_second" toml:"responses_per_second"`
+	Window             int      `yaml:"window" toml:"window"`
+	Slip               int      `yaml:"slip" toml:"slip"`
+	Leak               int      `yaml:"leak" toml:"leak"`
+	IPv4PrefixLength   int      `yaml:"ipv4_prefix_length" toml:"ipv4_prefix_length"`
+	IPv6PrefixLength   int      `yaml:"ipv6_prefix_length" toml:"ipv6_prefix_length"`
+	Exempt             []string `yaml:"exempt" toml:"exempt"`
+	MaxTableSize       int      `yaml:"max_table_size" toml:"max_table_size"`
+
+	// Decisions are logged and counted, but all responses are sent
+	LogOnly bool `yaml:"log_only" toml:"log_only"`
+}
+
+func (c *RRLConfig) Validate() error {
+
+	errs := make([]error, 0)
+
+	if c.ResponsesPerSecond < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid rrl.responses_per_second: %d, expected 0 or more", c.ResponsesPerSecond)))
+	}
+
+	if c.Window <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid rrl.window: %d, expected a positive number of seconds", c.Window)))
+	}
+
+	if c.Slip < 0 || c.Leak < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid rrl.slip or rrl.leak: %d, %d, expected 0 or more", c.Slip, c.Leak)))
+	}
+
+	if c.IPv4PrefixLength < 0 || c.IPv4PrefixLength > 32 || c.IPv6PrefixLength < 0 || c.IPv6PrefixLength > 128 {
+		errs = append(errs, errors.New("Invalid rrl prefix length, expected up to 32 bits for IPv4 and 128 bits for IPv6"))
+	}
+
+	if c.MaxTableSize <= 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Invalid rrl.max_table_size: %d, expected a positive number of accounts", c.MaxTableSize)))
+	}
+
+	if _, err := parsePrefixes(c.Exempt); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Parses CIDRs, accepting single addresses as well
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+
+	prefixes := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid address or CIDR: %s", value))
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid address or CIDR: %s", value))
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Returns address of the client without IPv4-mapped IPv6 prefix
+func clientAddr(addr net.Addr) (netip.Addr, bool) {
+
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		parsed, err := netip.ParseAddrPort(addr.String())
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return parsed.Addr().Unmap(), true
+	}
+
+	parsed, ok := netip.AddrFromSlice(ip)
+	return parsed.Unmap(), ok
+}
+
+// Account of responses sent to a single client netblock. The balance is credited with the rate every second,
+// up to the rate, and charged for every response, down to the debt of a whole window
+type rrlBucket struct {
+	key     string
+	balance float64
+	updated time.Time
+	limited uint64
+}
+
+// Limits identical responses sent to the same client netblock, so the server can't be used
+// as a reflector in amplification attacks (BIND-style Response Rate Limiting)
+type rateLimiter struct {
+	config  RRLConfig
+	exempt  []netip.Prefix
+	metrics *Metrics
+
+	// Accounts ordered from the most recently charged one
+	lock    sync.Mutex
+	buckets map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+func newRateLimiter(config RRLConfig, metrics *Metrics) (*rateLimiter, error) {
+
+	if config.ResponsesPerSecond <= 0 {
+		return nil, nil
+	}
+
+	exempt, err := parsePrefixes(config.Exempt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rateLimiter{
+		config:  config,
+		exempt:  exempt,
+		metrics: metrics,
+		buckets: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}, nil
+}
+
+// Classifies the response and returns the name it is accounted under
+func classifyResponse(msg *message.Message) (responseCategory, string, record.ResourceRecordType) {
+
+	var query message.Query
+	if len(msg.Body.Queries) > 0 {
+		query = msg.Body.Queries[0]
+	}
+
+	name := strings.ToLower(strings.Join(query.Name, "."))
+
+	// Negative responses and referrals are limited per zone, so random names don't bypass the limit
+	authorityOwner := func(recordType record.ResourceRecordType) (string, bool) {
+		for _, rr := range msg.Body.Authorative {
+			if rr.ResourceRecordType == recordType {
+				return strings.ToLower(strings.Join(rr.Name, ".")), true
+			}
+		}
+		return "", false
+	}
+
+	switch {
+	case msg.Header.Flags.ResponseCode == message.ResponseCode__NxDomain:
+		if zone, ok := authorityOwner(record.ResourceRecordType__SOA); ok {
+			name = zone
+		}
+		return responseCategory__NxDomain, name, 0
+
+	case msg.Header.Flags.ResponseCode != message.ResponseCode__NoError:
+		// Errors of a zone share an account, errors without SOA share one regardless of the name
+		zone, _ := authorityOwner(record.ResourceRecordType__SOA)
+		return responseCategory__Error, zone, 0
+
+	case len(msg.Body.Answers) > 0:
+		return responseCategory__Answer, name, query.ResourceRecordType
+
+	default:
+		if owner, ok := authorityOwner(record.ResourceRecordType__NS); ok && !msg.Header.Flags.AuthorativeAnswer {
+			return responseCategory__Referral, owner, 0
+		}
+		if zone, ok := authorityOwner(record.ResourceRecordType__SOA); ok {
+			name = zone
+		}
+		return responseCategory__NoData, name, query.ResourceRecordType
+	}
+}
+
+// Returns key of the client netblock
+func (l *rateLimiter) netblock(addr netip.Addr) string {
+
+	bits := l.config.IPv6PrefixLength
+	if addr.Is4() {
+		bits = l.config.IPv4PrefixLength
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+
+	return prefix.String()
+}
+
+func (l *rateLimiter) isExempt(addr netip.Addr) bool {
+	for _, prefix := range l.exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decides whether the response to the client can be sent
+func (l *rateLimiter) check(remote net.Addr, msg *message.Message) RRLAction {
+
+	addr, ok := clientAddr(remote)
+	if !ok || l.isExempt(addr) {
+		return RRLAction__Send
+	}
+
+	category, name, recordType := classifyResponse(msg)
+	netblock := l.netblock(addr)
+	key := fmt.Sprintf("%s/%s/%s/%d", netblock, category, name, recordType)
+
+	action, limited := l.account(key)
+	if action == RRLAction__Send {
+		return action
+	}
+
+	if l.metrics != nil {
+		l.metrics.countRateLimited(action)
+	}
+
+	// First limited response of the account is reported, the rest only at debug level
+	level := slog.LevelDebug
+	if limited == 1 {
+		level = slog.LevelInfo
+	}
+
+	slog.Log(context.Background(), level, "Response rate limited",
+		"client", netblock,
+		"category", category,
+		"name", name,
+		"type", recordType,
+		"action", action,
+		"logOnly", l.config.LogOnly,
+	)
+
+	if l.config.LogOnly {
+		return RRLAction__Send
+	}
+
+	return action
+}
+
+// Charges the account for the response, returning the decision and the number of limited responses so far
+func (l *rateLimiter) account(key string) (RRLAction, uint64) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	rate := float64(l.config.ResponsesPerSecond)
+
+	var bucket *rrlBucket
+	if element, ok := l.buckets[key]; ok {
+		bucket = element.Value.(*rrlBucket)
+		l.order.MoveToFront(element)
+	} else {
+		// Spoofed sources and random names can't grow the table without bounds (BIND max-table-size)
+		if len(l.buckets) >= l.config.MaxTableSize {
+			l.evict(l.order.Back())
+			if l.metrics != nil {
+				l.metrics.countRRLEviction()
+			}
+		}
+
+		bucket = &rrlBucket{key: key, balance: rate, updated: now}
+		l.buckets[key] = l.order.PushFront(bucket)
+	}
+
+	if l.metrics != nil {
+		l.metrics.setRRLTableSize(len(l.buckets))
+	}
+
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.balance = min(bucket.balance+elapsed*rate, rate)
+	bucket.updated = now
+
+	bucket.balance = max(bucket.balance-1, -rate*float64(l.config.Window))
+	if bucket.balance >= 0 {
+		bucket.limited = 0
+		return RRLAction__Send, 0
+	}
+
+	bucket.limited++
+
+	if l.config.Leak > 0 && bucket.limited%uint64(l.config.Leak) == 0 {
+		return RRLAction__Leak, bucket.limited
+	}
+
+	if l.config.Slip > 0 && bucket.limited%uint64(l.config.Slip) == 0 {
+		return RRLAction__Slip, bucket.limited
+	}
+
+	return RRLAction__Drop, bucket.limited
+}
+
+// Forgets accounts which were idle long enough to be fully credited again
+func (l *rateLimiter) sweep(now time.Time) {
+
+	window := time.Duration(l.config.Window) * time.Second
+
+	for element := l.order.Back(); element != nil; element = l.order.Back() {
+		if now.Sub(element.Value.(*rrlBucket).updated) <= window {
+			return
+		}
+		l.evict(element)
+	}
+}
+
+func (l *rateLimiter) evict(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.buckets, element.Value.(*rrlBucket).key)
+}
+
+// Strips the response down to the header and the question, with the TC bit asking the client to retry over TCP
+func truncateResponse(msg *message.Message) {
+	msg.ResetRRs()
+	msg.Header.Flags.Truncation = true
+}
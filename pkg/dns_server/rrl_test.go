@@ -0,0 +1,259 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRateLimiter(t *testing.T, modify func(c *RRLConfig)) (*rateLimiter, *time.Time) {
+	t.Helper()
+
+	config := DefaultConfig().RRL
+	config.ResponsesPerSecond = 2
+	config.Window = 1
+	modify(&config)
+
+	limiter, err := newRateLimiter(config, NewMetrics())
+	assert.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	return limiter, &now
+}
+
+func TestRateLimiter_check(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modify   func(c *RRLConfig)
+		expected []RRLAction
+	}{
+		{
+			name:   "Every second limited response should slip by default",
+			modify: func(c *RRLConfig) {},
+			expected: []RRLAction{
+				RRLAction__Send, RRLAction__Send,
+				RRLAction__Drop, RRLAction__Slip, RRLAction__Drop, RRLAction__Slip,
+			},
+		},
+		{
+			name:   "Limited responses should be dropped when slip is disabled",
+			modify: func(c *RRLConfig) { c.Slip = 0 },
+			expected: []RRLAction{
+				RRLAction__Send, RRLAction__Send,
+				RRLAction__Drop, RRLAction__Drop, RRLAction__Drop, RRLAction__Drop,
+			},
+		},
+		{
+			name:   "Leak should take precedence over slip",
+			modify: func(c *RRLConfig) { c.Slip = 2; c.Leak = 3 },
+			expected: []RRLAction{
+				RRLAction__Send, RRLAction__Send,
+				RRLAction__Drop, RRLAction__Slip, RRLAction__Leak, RRLAction__Slip,
+			},
+		},
+		{
+			name:   "Exempt clients should not be limited",
+			modify: func(c *RRLConfig) { c.Exempt = []string{"10.0.0.0/8"} },
+			expected: []RRLAction{
+				RRLAction__Send, RRLAction__Send,
+				RRLAction__Send, RRLAction__Send, RRLAction__Send, RRLAction__Send,
+			},
+		},
+		{
+			name:   "Responses should be sent in log only mode",
+			modify: func(c *RRLConfig) { c.LogOnly = true },
+			expected: []RRLAction{
+				RRLAction__Send, RRLAction__Send,
+				RRLAction__Send, RRLAction__Send, RRLAction__Send, RRLAction__Send,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter, _ := newTestRateLimiter(t, tc.modify)
+			msg := newTestAnswer([]string{"www", "example", "com"}, record.ResourceRecordType__A)
+
+			actions := make([]RRLAction, 0, len(tc.expected))
+			for i := range tc.expected {
+				// Clients of the same netblock share the limit
+				addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 53000}
+				actions = append(actions, limiter.check(addr, msg))
+			}
+
+			assert.Equal(t, tc.expected, actions)
+		})
+	}
+}
+
+func TestRateLimiter_check_refillsOverTime(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, func(c *RRLConfig) { c.Slip = 0 })
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53000}
+	msg := newTestAnswer([]string{"www", "example", "com"}, record.ResourceRecordType__A)
+
+	for range 4 {
+		limiter.check(addr, msg)
+	}
+	assert.Equal(t, RRLAction__Drop, limiter.check(addr, msg))
+
+	// Other names, and other netblocks are accounted separately
+	assert.Equal(t, RRLAction__Send, limiter.check(addr, newTestAnswer([]string{"mail", "example", "com"}, record.ResourceRecordType__A)))
+	assert.Equal(t, RRLAction__Send, limiter.check(&net.UDPAddr{IP: net.IPv4(10, 0, 1, 1)}, msg))
+
+	*now = now.Add(2 * time.Second)
+	assert.Equal(t, RRLAction__Send, limiter.check(addr, msg))
+
+	snapshot := limiter.metrics.Snapshot()
+	assert.Equal(t, uint64(3), snapshot.RateLimited[RRLAction__Drop])
+}
+
+func TestRateLimiter_check_maxTableSize(t *testing.T) {
+	limiter, now := newTestRateLimiter(t, func(c *RRLConfig) { c.Slip = 0; c.MaxTableSize = 2 })
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53000}
+
+	www := newTestAnswer([]string{"www", "example", "com"}, record.ResourceRecordType__A)
+	for range 3 {
+		limiter.check(addr, www)
+	}
+	assert.Equal(t, RRLAction__Drop, limiter.check(addr, www))
+
+	// Random names fill the table, forgetting the least recently charged account
+	*now = now.Add(100 * time.Millisecond)
+	limiter.check(addr, newTestAnswer([]string{"random1", "example", "com"}, record.ResourceRecordType__A))
+	*now = now.Add(100 * time.Millisecond)
+	limiter.check(addr, newTestAnswer([]string{"random2", "example", "com"}, record.ResourceRecordType__A))
+
+	assert.Len(t, limiter.buckets, 2)
+	assert.Equal(t, RRLAction__Send, limiter.check(addr, www), "forgotten account should start over")
+
+	snapshot := limiter.metrics.Snapshot()
+	assert.Equal(t, uint64(2), snapshot.RRLTableSize)
+	assert.Equal(t, uint64(2), snapshot.RRLEvicted)
+
+	// Idle accounts are forgotten once fully credited, without waiting for the table to fill
+	*now = now.Add(2 * time.Second)
+	limiter.check(addr, www)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestClassifyResponse(t *testing.T) {
+	soa := record.NewSOARecord([]string{"example", "com"}, record.ResourceRecordClass__In, []string{"ns1", "example", "com"}, []string{"admin", "example", "com"}, 1, 3600, 600, 86400, 300)
+
+	nxdomain := newTestMessage([]string{"random1", "example", "com"}, record.ResourceRecordType__A)
+	nxdomain.AddAuthorative(soa)
+	nxdomain.SetResponseCode(message.ResponseCode__NxDomain)
+
+	nodata := newTestMessage([]string{"WWW", "example", "com"}, record.ResourceRecordType__MX)
+	nodata.AddAuthorative(soa)
+
+	referral := newTestMessage([]string{"www", "sub", "example", "com"}, record.ResourceRecordType__A)
+	referral.AddAuthorative(record.NewNSRecord([]string{"sub", "example", "com"}, record.ResourceRecordClass__In, []string{"ns1", "sub", "example", "com"}))
+
+	refused := newTestMessage([]string{"example", "org"}, record.ResourceRecordType__A)
+	refused.SetResponseCode(message.ResponseCode__Refused)
+
+	servfail := newTestMessage([]string{"random2", "example", "com"}, record.ResourceRecordType__A)
+	servfail.AddAuthorative(soa)
+	servfail.SetResponseCode(message.ResponseCode__ServFail)
+
+	testCases := []struct {
+		name             string
+		msg              *message.Message
+		expectedCategory responseCategory
+		expectedName     string
+	}{
+		{
+			name:             "Answer should be accounted under the query name",
+			msg:              newTestAnswer([]string{"WWW", "Example", "com"}, record.ResourceRecordType__A),
+			expectedCategory: responseCategory__Answer,
+			expectedName:     "www.example.com",
+		},
+		{
+			name:             "NXDOMAIN should be accounted under the zone",
+			msg:              nxdomain,
+			expectedCategory: responseCategory__NxDomain,
+			expectedName:     "example.com",
+		},
+		{
+			name:             "NODATA should be accounted under the zone",
+			msg:              nodata,
+			expectedCategory: responseCategory__NoData,
+			expectedName:     "example.com",
+		},
+		{
+			name:             "Referral should be accounted under the delegated zone",
+			msg:              referral,
+			expectedCategory: responseCategory__Referral,
+			expectedName:     "sub.example.com",
+		},
+		{
+			name:             "Errors with SOA should be accounted under the zone",
+			msg:              servfail,
+			expectedCategory: responseCategory__Error,
+			expectedName:     "example.com",
+		},
+		{
+			name:             "Errors without SOA should share a single account",
+			msg:              refused,
+			expectedCategory: responseCategory__Error,
+			expectedName:     "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			category, name, _ := classifyResponse(tc.msg)
+			assert.Equal(t, tc.expectedCategory, category)
+			assert.Equal(t, tc.expectedName, name)
+		})
+	}
+}
+
+func TestRequest_Send_rateLimited(t *testing.T) {
+	limiter, _ := newTestRateLimiter(t, func(c *RRLConfig) { c.ResponsesPerSecond = 1 })
+	writer := &bufferResponseWriter{addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53000}}
+
+	send := func() *message.Message {
+		writer.response = nil
+		req := &Request{transport: TRANSPORT_UDP, writer: writer, rateLimiter: limiter}
+		assert.NoError(t, req.WriteMsg(newTestAnswer([]string{"www", "example", "com"}, record.ResourceRecordType__A)))
+
+		if writer.response == nil {
+			return nil
+		}
+
+		var response message.Message
+		assert.NoError(t, message.NewDecoder(writer.response).Decode(&response))
+		return &response
+	}
+
+	full := send()
+	assert.NotNil(t, full)
+	assert.False(t, full.Header.Flags.Truncation)
+	assert.Len(t, full.Body.Answers, 1)
+
+	assert.Nil(t, send(), "first limited response should be dropped")
+
+	slipped := send()
+	assert.NotNil(t, slipped)
+	assert.True(t, slipped.Header.Flags.Truncation)
+	assert.Empty(t, slipped.Body.Answers)
+}
+
+func TestRRLConfig_Validate(t *testing.T) {
+	config := DefaultConfig().RRL
+	assert.NoError(t, config.Validate())
+
+	config.Exempt = []string{"192.168.0.0/16", "::1", "not-an-address"}
+	assert.Error(t, config.Validate())
+
+	config = DefaultConfig().RRL
+	config.MaxTableSize = 0
+	assert.Error(t, config.Validate())
+}
@@ -1,77 +1,286 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	message "github.com/XxRoloxX/dns/pkg/dns_message"
 	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/quic-go/quic-go"
 	"log/slog"
 	"net"
-	"strings"
+	"net/http"
+	"sync"
+	"time"
 )
 
+// Time given to requests in flight to complete when the server shuts down
+const DEFAULT_SHUTDOWN_TIMEOUT = 10 * time.Second
+
 type Server struct {
-	conn       *net.UDPConn
-	repository managementserver.RecordsRepository
+	config         *Config
+	udpConns       []*net.UDPConn
+	tcpListener    net.Listener
+	tlsListener    net.Listener
+	dohListener    net.Listener
+	dohServer      *http.Server
+	quicListener   *quic.EarlyListener
+	tcpIdleTimeout time.Duration
+	tcpConnections *connectionLimiter
+	repository     managementserver.RecordsRepository
+	metrics        *Metrics
+	handler        Handler
+
+	// Limits responses sent over UDP, nil when disabled
+	rateLimiter *rateLimiter
+
+	// Picks split-horizon view of the client, nil when only the default view is served
+	views *viewSelector
+
+	// Resolves queries for names outside of served zones, nil when the recursive plugin is disabled
+	recursive *Recursive
+
+	// Forwards queries for names outside of served zones to upstreams, nil when the forward plugin is disabled
+	forwarder *Forwarder
+
+	// Workers handling requests, and free list of receive buffers shared by UDP sockets
+	pool           *workerPool
+	buffers        *bufferPool
+	overloadAction OverloadAction
+
+	// Time given to requests in flight to complete when the server shuts down
+	shutdownTimeout time.Duration
+
+	stopCtx        context.Context
+	stop           context.CancelFunc
+	requestCtx     context.Context
+	cancelRequests context.CancelFunc
+
+	// Listener loops, and requests and connections being served
+	listeners sync.WaitGroup
+	inFlight  sync.WaitGroup
 }
 
-func NewServer() *Server {
+func NewServer(config *Config) (*Server, error) {
 
-	address, err := net.ResolveUDPAddr("udp", ":53")
+	srv := newServer(config)
+
+	err := srv.listen(config)
 	if err != nil {
-		panic(fmt.Sprintf("failed to resolve address: %s", err.Error()))
+		srv.Close()
+		return nil, err
 	}
 
-	conn, err := net.ListenUDP("udp", address)
+	srv.repository, err = newRepository(config)
 	if err != nil {
-		panic(fmt.Sprintf("failed to listen on socket: %s", err.Error()))
+		srv.Close()
+		return nil, err
 	}
 
-	slog.Info("Listening for DNS messages on :53")
+	if snapshot, ok := srv.repository.(*SnapshotRecordsRepository); ok {
+		interval := time.Duration(config.Backend.Snapshot.ResyncInterval) * time.Second
 
-	repository := managementserver.NewPostgresRecordsRepository()
+		srv.listeners.Add(1)
+		go func() {
+			defer srv.listeners.Done()
+			snapshot.Sync(srv.stopContext(), interval)
+		}()
+	}
 
-	return &Server{
-		conn:       conn,
-		repository: repository,
+	srv.rateLimiter, err = newRateLimiter(config.RRL, srv.metrics)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+
+	srv.views, err = newViewSelector(config.Views)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+
+	srv.handler, err = srv.buildHandler(config.Plugins)
+	if err != nil {
+		srv.Close()
+		return nil, err
 	}
+
+	return srv, nil
 }
 
-func (s *Server) HandleRequest(req *Request) {
+// Creates the server with its worker pool and lifecycle, without opening listeners or the repository
+func newServer(config *Config) *Server {
+
+	srv := &Server{
+		config:         config,
+		tcpIdleTimeout: time.Duration(config.Limits.TCPIdleTimeout) * time.Second,
+		tcpConnections: newConnectionLimiter(config.Limits.TCPMaxConnectionsPerClient),
+		metrics:        NewMetrics(),
+		pool:           newWorkerPool(config.Limits.Workers, config.Limits.QueueSize),
+		buffers:        newBufferPool(config.Limits.Workers+config.Limits.QueueSize, MAX_EDNS_UDP_PAYLOAD_SIZE),
+		overloadAction: config.Limits.OverloadAction,
 
-	for _, query := range req.msg.Body.Queries {
-		records, err := s.repository.GetRecordsByName(strings.Join(query.Name, "."))
+		shutdownTimeout: time.Duration(config.Limits.ShutdownTimeout) * time.Second,
+	}
+
+	srv.stopCtx, srv.stop = context.WithCancel(context.Background())
+	srv.requestCtx, srv.cancelRequests = context.WithCancel(context.Background())
+
+	return srv
+}
+
+// Opens listeners of transports enabled in the config
+func (s *Server) listen(config *Config) error {
+
+	if config.Listen.UDP != "" {
+		conns, err := listenUDP(config.Listen.UDP, config.Limits.UDPSockets)
 		if err != nil {
-			slog.Error("Failed to get records for", "query", query)
-			s.HandleInternalError(req)
-			return
+			return err
 		}
 
-		if len(records) == 0 {
-			s.HandleNoResourceError(req)
-			return
+		s.udpConns = conns
+		slog.Info("Listening for DNS messages on UDP "+config.Listen.UDP, "sockets", len(conns))
+	}
+
+	if config.Listen.TCP != "" {
+		listener, err := net.Listen("tcp", config.Listen.TCP)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to listen on TCP address %s: %s", config.Listen.TCP, err))
 		}
 
-		for _, record := range records {
-			rr, err := record.ConvertToResourceRecord()
-			if err != nil {
-				slog.Error("Failed to convert Managed Resource Record to canonical form", "err", err)
-				s.HandleInternalError(req)
-				return
+		s.tcpListener = listener
+		slog.Info("Listening for DNS messages on TCP " + config.Listen.TCP)
+	}
+
+	if config.TLS.CertFile == "" || config.TLS.KeyFile == "" {
+		slog.Info("No certificate configured, encrypted transports are disabled")
+		return nil
+	}
+
+	reloader, err := newCertificateReloader(config.TLS.CertFile, config.TLS.KeyFile)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to load certificate %s: %s", config.TLS.CertFile, err))
+	}
+
+	if config.Listen.TLS != "" {
+		listener, err := tls.Listen("tcp", config.Listen.TLS, newTLSConfig(reloader, DOT_ALPN))
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to listen on DNS-over-TLS address %s: %s", config.Listen.TLS, err))
+		}
+
+		s.tlsListener = listener
+		slog.Info("Listening for DNS-over-TLS messages on " + config.Listen.TLS)
+	}
+
+	if config.Listen.QUIC != "" {
+		listener, err := quic.ListenAddrEarly(config.Listen.QUIC, newTLSConfig(reloader, DOQ_ALPN), newQUICConfig())
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to listen on DNS-over-QUIC address %s: %s", config.Listen.QUIC, err))
+		}
+
+		s.quicListener = listener
+		slog.Info("Listening for DNS-over-QUIC messages on " + config.Listen.QUIC)
+	}
+
+	if config.Listen.HTTPS != "" {
+		listener, err := net.Listen("tcp", config.Listen.HTTPS)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to listen on DNS-over-HTTPS address %s: %s", config.Listen.HTTPS, err))
+		}
+
+		s.dohListener = listener
+		s.dohServer = &http.Server{
+			Handler:   s.NewDoHEngine(),
+			TLSConfig: newTLSConfig(reloader, "h2", "http/1.1"),
+		}
+		slog.Info("Listening for DNS-over-HTTPS messages on " + config.Listen.HTTPS + DOH_PATH)
+	}
+
+	return nil
+}
+
+// Opens UDP sockets bound to the same address. Multiple sockets are bound with SO_REUSEPORT,
+// so the kernel spreads packets between them and they can be read on separate cores
+func listenUDP(address string, sockets int) ([]*net.UDPConn, error) {
+
+	config := net.ListenConfig{}
+	if sockets > 1 {
+		config.Control = reusePort
+	}
+
+	conns := make([]*net.UDPConn, 0, sockets)
+
+	for range max(sockets, 1) {
+		conn, err := config.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			for _, opened := range conns {
+				opened.Close()
 			}
-			req.msg.AddAnswer(rr)
+			return nil, errors.New(fmt.Sprintf("Failed to listen on UDP address %s: %s", address, err))
 		}
+
+		conns = append(conns, conn.(*net.UDPConn))
+
+		// Sockets after the first one bind to the port it got, when any port was requested
+		address = conn.LocalAddr().String()
 	}
 
-	req.msg.SetAsResponse()
-	req.msg.UpdateRRNumbers()
+	return conns, nil
+}
 
-	req.Send()
+// Opens storage of the records selected in the config
+func newRepository(config *Config) (managementserver.RecordsRepository, error) {
+
+	switch config.Backend.Type {
+	case Backend__Memory:
+		records, err := config.ZoneRecords()
+		if err != nil {
+			return nil, err
+		}
+		return managementserver.NewMemoryRecordsRepository(records), nil
+
+	case Backend__Postgres:
+		repository, err := managementserver.OpenPostgresRecordsRepository(config.Backend.Postgres)
+		if err != nil {
+			return nil, err
+		}
+
+		if !config.Backend.Snapshot.Enabled {
+			return repository, nil
+		}
+
+		snapshot, err := newSnapshot(context.Background(), repository)
+		if err != nil {
+			repository.Close()
+			return nil, err
+		}
+		return snapshot, nil
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Invalid backend: %s", config.Backend.Type))
+	}
 }
 
-func (s *Server) HandleInternalError(req *Request) {
-	req.msg.SetAsResponse()
-	req.msg.SetResponseCode(message.ResponseCode__ServFail)
-	req.Send()
+// Picks the view of the client and passes the request through the handler chain
+func (s *Server) HandleRequest(req *Request) {
+	ctx := withView(s.requestContext(), s.views.selectView(req.RemoteAddr()))
+	ctx = withNoCache(ctx, &req.noCache)
+	req.recursionAvailable = s.recursionAvailable(req.RemoteAddr())
+	s.handler.ServeDNS(ctx, req, req.msg)
+}
+
+// Checks whether the client is allowed to use recursion or forwarding
+func (s *Server) recursionAvailable(remote net.Addr) bool {
+	return s.recursive.Available(remote) || s.forwarder.Available(remote)
+}
+
+// Returns repository of the management server behind the snapshot, which also holds rules and delivers cache flushes
+func (s *Server) repositorySource() managementserver.RecordsRepository {
+	if snapshot, ok := s.repository.(*SnapshotRecordsRepository); ok {
+		return snapshot.Source()
+	}
+	return s.repository
 }
 
 func (s *Server) HandleFormattingError(req *Request) {
@@ -80,42 +289,200 @@ func (s *Server) HandleFormattingError(req *Request) {
 	req.Send()
 }
 
-func (s *Server) HandleNoResourceError(req *Request) {
-	req.msg.SetAsResponse()
-	req.msg.SetResponseCode(message.ResponseCode__NxDomain)
-	req.Send()
+// Returns context cancelled when the server stops accepting new requests
+func (s *Server) stopContext() context.Context {
+	return s.stopCtx
+}
+
+// Returns context of handled requests, cancelled when the server gives up on requests in flight
+func (s *Server) requestContext() context.Context {
+	return s.requestCtx
+}
+
+// Serves requests on all configured transports until the context is cancelled, then shuts the server down
+func (s *Server) Serve(ctx context.Context) error {
+
+	for _, listen := range []func(){s.ListenUDP, s.ListenTCP, s.ListenTLS, s.ListenHTTPS, s.ListenQUIC} {
+		s.listeners.Add(1)
+		go func() {
+			defer s.listeners.Done()
+			listen()
+		}()
+	}
+
+	<-ctx.Done()
+
+	timeout := s.shutdownTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.Shutdown(shutdownCtx)
+}
+
+// Stops accepting requests and waits for the ones in flight until the context is done,
+// after which they are cancelled. Closes listeners and the repository afterwards
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	slog.Info("Shutting down, waiting for requests in flight")
+
+	s.stop()
+
+	errs := make([]error, 0)
+
+	// UDP sockets stay open, so responses to requests in flight can still be sent
+	for _, conn := range s.udpConns {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	if s.dohServer != nil {
+		if err := s.dohServer.Shutdown(ctx); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Failed to shut down DNS-over-HTTPS server: %s", err)))
+		}
+	}
+
+	errs = append(errs, s.closeListeners()...)
+
+	// No requests are accepted once all listeners return
+	s.listeners.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		errs = append(errs, errors.New("Shutdown deadline exceeded, cancelled requests in flight"))
+	}
+
+	s.cancelRequests()
+	s.pool.stop()
+
+	errs = append(errs, s.closeConnections()...)
+
+	return errors.Join(errs...)
 }
 
-func (s *Server) Listen(chan message.Message) {
+// Closes the server immediately, abandoning requests in flight
+func (s *Server) Close() error {
+
+	s.stop()
+	s.cancelRequests()
+	s.pool.stop()
+
+	errs := make([]error, 0)
+
+	if s.dohServer != nil {
+		if err := s.dohServer.Close(); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Failed to close DNS-over-HTTPS server: %s", err)))
+		}
+	}
+
+	errs = append(errs, s.closeListeners()...)
+	errs = append(errs, s.closeConnections()...)
+
+	return errors.Join(errs...)
+}
+
+// Closes listeners of connection oriented transports, connections accepted before are left open
+func (s *Server) closeListeners() []error {
+
+	errs := make([]error, 0)
+
+	// HTTPS listener is already closed by its server, unless it was never served
+	for _, listener := range []net.Listener{s.tcpListener, s.tlsListener, s.dohListener} {
+		if listener == nil {
+			continue
+		}
+
+		err := listener.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, errors.New(fmt.Sprintf("Failed to close listener %s: %s", listener.Addr(), err)))
+		}
+	}
+
+	return errs
+}
+
+// Closes UDP and QUIC sockets together with connections to the repository
+func (s *Server) closeConnections() []error {
+
+	errs := make([]error, 0)
+
+	if s.quicListener != nil {
+		if err := s.quicListener.Close(); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Failed to close QUIC listener: %s", err)))
+		}
+	}
+
+	for _, conn := range s.udpConns {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, errors.New(fmt.Sprintf("Failed to close UDP socket %s: %s", conn.LocalAddr(), err)))
+		}
+	}
+
+	if s.repository != nil {
+		if err := s.repository.Close(); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Failed to close repository: %s", err)))
+		}
+	}
+
+	return errs
+}
+
+// Reads requests from all UDP sockets, each one in its own goroutine
+func (s *Server) ListenUDP() {
+
+	var readers sync.WaitGroup
+
+	for _, conn := range s.udpConns {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			s.readUDP(conn)
+		}()
+	}
+
+	readers.Wait()
+}
+
+func (s *Server) readUDP(conn *net.UDPConn) {
 
 	for {
-		buf := make([]byte, 512)
-		n, addr, err := s.conn.ReadFromUDP(buf)
+		buf := s.buffers.get()
+		n, addr, err := conn.ReadFromUDP(buf)
 		if err != nil {
+			s.buffers.put(buf)
+
+			if errors.Is(err, net.ErrClosed) || s.stopContext().Err() != nil {
+				return
+			}
+
 			slog.Error("failed to read message", "err", err.Error())
 			continue
 		}
 
-		req, err := NewRequest(s.conn, addr, buf[:n])
+		writer := &udpResponseWriter{conn: conn, addr: addr}
+
+		req, err := NewRequest(buf[:n], TRANSPORT_UDP, writer)
 		if err != nil {
 			s.HandleFormattingError(&Request{
-				msg:  &message.Message{},
-				conn: s.conn,
-				addr: addr,
+				msg:         &message.Message{},
+				transport:   TRANSPORT_UDP,
+				writer:      writer,
+				rateLimiter: s.rateLimiter,
 			})
+			s.buffers.put(buf)
 			continue
 		}
 
-		go s.HandleRequest(req)
-
-	}
-}
-
-func (s *Server) Close() {
-
-	err := s.conn.Close()
-	if err != nil {
-		slog.Error("failed to close server", "err", err.Error())
-		panic("failed to close connection to server")
+		req.rateLimiter = s.rateLimiter
+		s.dispatch(req, func() { s.buffers.put(buf) })
 	}
 }
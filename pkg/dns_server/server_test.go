@@ -0,0 +1,553 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRecords = []managementserver.ManagedDNSResourceRecord{
+	{Name: "example.com", Type: managementserver.ManagedDNSRecordType_SOA, Class: managementserver.ManagedDNSRecordClass_IN, Data: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", Ttl: 3600},
+	{Name: "168.192.in-addr.arpa", Type: managementserver.ManagedDNSRecordType_SOA, Class: managementserver.ManagedDNSRecordClass_IN, Data: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", Ttl: 3600},
+	{Name: "example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.1", Ttl: 300},
+	{Name: "www.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.2", Ttl: 300},
+	{Name: "www.example.com", Type: managementserver.ManagedDNSRecordType_TXT, Class: managementserver.ManagedDNSRecordClass_IN, Data: "text", Ttl: 300},
+	{Name: "example.com", Type: managementserver.ManagedDNSRecordType_MX, Class: managementserver.ManagedDNSRecordClass_IN, Data: "10 mail.example.com.", Ttl: 300},
+	{Name: "mail.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.3", Ttl: 300},
+	{Name: "mail.example.com", Type: managementserver.ManagedDNSRecordType_AAAA, Class: managementserver.ManagedDNSRecordClass_IN, Data: "2001:db8::3", Ttl: 300},
+	{Name: "_sip._tcp.example.com", Type: managementserver.ManagedDNSRecordType_SRV, Class: managementserver.ManagedDNSRecordClass_IN, Data: "10 5 5060 sip.example.com", Ttl: 300},
+	{Name: "sip.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.4", Ttl: 300},
+	{Name: "alias.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "www.example.com.", Ttl: 300},
+	{Name: "chain.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "alias.example.com", Ttl: 300},
+	{Name: "external.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "www.example.org", Ttl: 300},
+	{Name: "dangling.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "missing.example.com", Ttl: 300},
+	{Name: "loop1.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "loop2.example.com", Ttl: 300},
+	{Name: "loop2.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "loop1.example.com", Ttl: 300},
+	{Name: "dev.example.com", Type: managementserver.ManagedDNSRecordType_NS, Class: managementserver.ManagedDNSRecordClass_IN, Data: "ns1.dev.example.com.", Ttl: 3600},
+	{Name: "dev.example.com", Type: managementserver.ManagedDNSRecordType_NS, Class: managementserver.ManagedDNSRecordClass_IN, Data: "ns.example.org.", Ttl: 3600},
+	{Name: "ns1.dev.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.3.1", Ttl: 3600},
+	{Name: "delegated.example.com", Type: managementserver.ManagedDNSRecordType_CNAME, Class: managementserver.ManagedDNSRecordClass_IN, Data: "www.dev.example.com", Ttl: 300},
+	{Name: "*.customers.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.2.1", Ttl: 300},
+	{Name: "explicit.customers.example.com", Type: managementserver.ManagedDNSRecordType_TXT, Class: managementserver.ManagedDNSRecordClass_IN, Data: "text", Ttl: 300},
+	{Name: "host.internal.customers.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.2.2", Ttl: 300},
+}
+
+func TestServer_HandleRequest(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		queryType          record.ResourceRecordType
+		expectedCode       message.ResponseCode
+		expectedAA         bool
+		expectedAnswers    []record.ResourceRecordType
+		expectedAuthority  []record.ResourceRecordType
+		expectedAdditional []record.ResourceRecordType
+	}{
+		{
+			name:            "Only records of the requested type should be answered",
+			query:           "www.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:              "Existing name without records of requested type should get NODATA",
+			query:             "www.example.com",
+			queryType:         record.ResourceRecordType__AAAA,
+			expectedCode:      message.ResponseCode__NoError,
+			expectedAA:        true,
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:              "Missing name should get NXDOMAIN",
+			query:             "missing.example.com",
+			queryType:         record.ResourceRecordType__A,
+			expectedCode:      message.ResponseCode__NxDomain,
+			expectedAA:        true,
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:            "Missing name should be answered from the wildcard",
+			query:           "acme.customers.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:            "Wildcard should match names more than one label below its encloser",
+			query:           "eu.acme.customers.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:              "Missing type at the wildcard should get NODATA",
+			query:             "acme.customers.example.com",
+			queryType:         record.ResourceRecordType__TXT,
+			expectedCode:      message.ResponseCode__NoError,
+			expectedAA:        true,
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:              "Explicit name should take precedence over the wildcard",
+			query:             "explicit.customers.example.com",
+			queryType:         record.ResourceRecordType__A,
+			expectedCode:      message.ResponseCode__NoError,
+			expectedAA:        true,
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:              "Empty non-terminal should not be answered from the wildcard",
+			query:             "internal.customers.example.com",
+			queryType:         record.ResourceRecordType__A,
+			expectedCode:      message.ResponseCode__NoError,
+			expectedAA:        true,
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:              "Name below empty non-terminal without its own wildcard should get NXDOMAIN",
+			query:             "missing.internal.customers.example.com",
+			queryType:         record.ResourceRecordType__A,
+			expectedCode:      message.ResponseCode__NxDomain,
+			expectedAA:        true,
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:            "CNAME should be followed to records of the requested type",
+			query:           "alias.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME, record.ResourceRecordType__A},
+		},
+		{
+			name:         "CNAME chain should be followed",
+			query:        "chain.example.com",
+			queryType:    record.ResourceRecordType__A,
+			expectedCode: message.ResponseCode__NoError,
+			expectedAA:   true,
+			expectedAnswers: []record.ResourceRecordType{
+				record.ResourceRecordType__CNAME,
+				record.ResourceRecordType__CNAME,
+				record.ResourceRecordType__A,
+			},
+		},
+		{
+			name:            "CNAME query should not be followed",
+			query:           "alias.example.com",
+			queryType:       record.ResourceRecordType__CNAME,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME},
+		},
+		{
+			name:            "CNAME pointing outside of served zones should be returned alone",
+			query:           "external.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME},
+		},
+		{
+			name:              "CNAME pointing to missing name should get NXDOMAIN",
+			query:             "dangling.example.com",
+			queryType:         record.ResourceRecordType__A,
+			expectedCode:      message.ResponseCode__NxDomain,
+			expectedAA:        true,
+			expectedAnswers:   []record.ResourceRecordType{record.ResourceRecordType__CNAME},
+			expectedAuthority: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:            "CNAME loop should be cut",
+			query:           "loop1.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME, record.ResourceRecordType__CNAME},
+		},
+		{
+			name:               "Name below zone cut should get referral with glue",
+			query:              "www.dev.example.com",
+			queryType:          record.ResourceRecordType__A,
+			expectedCode:       message.ResponseCode__NoError,
+			expectedAA:         false,
+			expectedAuthority:  []record.ResourceRecordType{record.ResourceRecordType__NS, record.ResourceRecordType__NS},
+			expectedAdditional: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:               "NS query at zone cut should get referral",
+			query:              "dev.example.com",
+			queryType:          record.ResourceRecordType__NS,
+			expectedCode:       message.ResponseCode__NoError,
+			expectedAA:         false,
+			expectedAuthority:  []record.ResourceRecordType{record.ResourceRecordType__NS, record.ResourceRecordType__NS},
+			expectedAdditional: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:            "CNAME pointing into delegated zone should not be followed",
+			query:           "delegated.example.com",
+			queryType:       record.ResourceRecordType__A,
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAA:      true,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__CNAME},
+		},
+		{
+			name:               "Addresses of MX exchange should be added to additional section",
+			query:              "example.com",
+			queryType:          record.ResourceRecordType__MX,
+			expectedCode:       message.ResponseCode__NoError,
+			expectedAA:         true,
+			expectedAnswers:    []record.ResourceRecordType{record.ResourceRecordType__MX},
+			expectedAdditional: []record.ResourceRecordType{record.ResourceRecordType__A, record.ResourceRecordType__AAAA},
+		},
+		{
+			name:               "Addresses of SRV target should be added to additional section",
+			query:              "_sip._tcp.example.com",
+			queryType:          record.ResourceRecordType__SRV,
+			expectedCode:       message.ResponseCode__NoError,
+			expectedAA:         true,
+			expectedAnswers:    []record.ResourceRecordType{record.ResourceRecordType__SRV},
+			expectedAdditional: []record.ResourceRecordType{record.ResourceRecordType__A},
+		},
+		{
+			name:         "Name outside of served zones should be refused",
+			query:        "www.example.org",
+			queryType:    record.ResourceRecordType__A,
+			expectedCode: message.ResponseCode__Refused,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+
+			response := exchange(t, srv, tc.query, tc.queryType)
+
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			assert.Equal(t, tc.expectedAA, response.Header.Flags.AuthorativeAnswer)
+			assert.Equal(t, tc.expectedAnswers, sectionTypes(response.Body.Answers))
+			assert.Equal(t, tc.expectedAuthority, sectionTypes(response.Body.Authorative))
+			assert.Equal(t, tc.expectedAdditional, sectionTypes(response.Body.Additional))
+		})
+	}
+}
+
+func TestServer_HandleRequest_synthesizesPTR(t *testing.T) {
+	testCases := []struct {
+		name         string
+		global       bool
+		zone         bool
+		expectedCode message.ResponseCode
+	}{
+		{
+			name:         "PTR should be synthesized in every reverse zone when enabled globally",
+			global:       true,
+			expectedCode: message.ResponseCode__NoError,
+		},
+		{
+			name:         "PTR should be synthesized in reverse zone enabling it on its SOA",
+			zone:         true,
+			expectedCode: message.ResponseCode__NoError,
+		},
+		{
+			name:         "PTR should not be synthesized when disabled",
+			expectedCode: message.ResponseCode__NxDomain,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := append([]managementserver.ManagedDNSResourceRecord{}, testRecords...)
+			for i := range records {
+				if records[i].Name == "168.192.in-addr.arpa" {
+					records[i].SynthesizePTR = tc.zone
+				}
+			}
+
+			srv := newTestServer(t, &Authoritative{
+				repository:    managementserver.NewMemoryRecordsRepository(records),
+				synthesizePTR: tc.global,
+			})
+
+			response := exchange(t, srv, "2.1.168.192.in-addr.arpa", record.ResourceRecordType__PTR)
+
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			if tc.expectedCode != message.ResponseCode__NoError {
+				return
+			}
+
+			assert.Equal(t, []record.ResourceRecordType{record.ResourceRecordType__PTR}, sectionTypes(response.Body.Answers))
+			assert.Equal(t,
+				record.NewPTRRecord(nil, record.ResourceRecordClass__In, []string{"www", "example", "com"}).Data(),
+				response.Body.Answers[0].RData)
+		})
+	}
+}
+
+func TestNewRecordController_synthesizePTR(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	records := make([]managementserver.ManagedDNSResourceRecord, 0, len(testRecords))
+	for _, rr := range testRecords {
+		if rr.Name != "168.192.in-addr.arpa" {
+			records = append(records, rr)
+		}
+	}
+
+	repository := managementserver.NewMemoryRecordsRepository(records)
+	service := managementserver.NewRecordsService(repository)
+
+	engine := gin.New()
+	managementserver.NewRecordsRouter(&managementserver.RecordsRouterParams{
+		Engine:                             engine,
+		NewRecordController:                managementserver.NewNewRecordController(service),
+		DeleteRecordController:             managementserver.NewDeleteRecordController(service),
+		GetRecordsController:               managementserver.NewGetRecordsController(service),
+		GetSynthesizedPTRRecordsController: managementserver.NewGetSynthesizedPTRRecordsController(service),
+	})
+
+	// Reverse zone enabling PTR synthesis on its SOA is created through the management API
+	body := `{"name": "168.192.in-addr.arpa", "type": "SOA", "class": "IN", "ttl": 3600, "synthesize_ptr": true,
+		"data": "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"}`
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+
+	srv := newTestServer(t, &Authoritative{repository: repository})
+
+	response := exchange(t, srv, "2.1.168.192.in-addr.arpa", record.ResourceRecordType__PTR)
+
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__NoError), response.Header.Flags.ResponseCode)
+	assert.Equal(t, []record.ResourceRecordType{record.ResourceRecordType__PTR}, sectionTypes(response.Body.Answers))
+}
+
+func TestServer_HandleRequest_unknownType(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+
+	// HTTPS records can't be managed, so the name owning other records gets NODATA
+	response := exchange(t, srv, "www.example.com", record.ResourceRecordType(65))
+
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__NoError), response.Header.Flags.ResponseCode)
+	assert.True(t, response.Header.Flags.AuthorativeAnswer)
+	assert.Empty(t, response.Body.Answers)
+	assert.Equal(t, []record.ResourceRecordType{record.ResourceRecordType__SOA}, sectionTypes(response.Body.Authorative))
+}
+
+func TestServer_HandleRequest_negativeSOATtl(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+
+	response := exchange(t, srv, "missing.example.com", record.ResourceRecordType__A)
+
+	// SOA in negative responses uses the SOA minimum when it's lower than the SOA TTL
+	assert.Equal(t, uint32(300), response.Body.Authorative[0].Ttl)
+}
+
+func TestServer_HandleRequest_groupsAnswersIntoRRSets(t *testing.T) {
+	records := append([]managementserver.ManagedDNSResourceRecord{
+		{Name: "pool.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.4.2", Ttl: 300},
+		{Name: "pool.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.4.1", Ttl: 60},
+		{Name: "pool.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.4.2", Ttl: 300},
+	}, testRecords...)
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(records)})
+
+	response := exchange(t, srv, "pool.example.com", record.ResourceRecordType__A)
+
+	// Duplicate should be removed, records sorted and sharing the lowest TTL
+	assert.Len(t, response.Body.Answers, 2)
+	assert.Equal(t, []byte{192, 168, 4, 1}, response.Body.Answers[0].RData)
+	assert.Equal(t, []byte{192, 168, 4, 2}, response.Body.Answers[1].RData)
+	assert.Equal(t, uint32(60), response.Body.Answers[0].Ttl)
+	assert.Equal(t, uint32(60), response.Body.Answers[1].Ttl)
+}
+
+func TestServer_HandleRequest_wildcardOwnerName(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+
+	response := exchange(t, srv, "acme.customers.example.com", record.ResourceRecordType__A)
+
+	// Synthesized record should be owned by the queried name
+	assert.Equal(t, []string{"acme", "customers", "example", "com"}, response.Body.Answers[0].Name)
+	assert.Equal(t, []byte{192, 168, 2, 1}, response.Body.Answers[0].RData)
+}
+
+func TestServer_HandleRequest_referralNameServers(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+
+	response := exchange(t, srv, "www.dev.example.com", record.ResourceRecordType__A)
+
+	// Both name servers should be listed, while only the in-bailiwick one gets glue
+	assert.Len(t, response.Body.Authorative, 2)
+	assert.Len(t, response.Body.Additional, 1)
+	assert.Equal(t, []string{"ns1", "dev", "example", "com"}, response.Body.Additional[0].Name)
+}
+
+func TestServer_HandleRequest_anyPolicy(t *testing.T) {
+	testCases := []struct {
+		name            string
+		policy          AnyPolicy
+		query           string
+		expectedCode    message.ResponseCode
+		expectedAnswers []record.ResourceRecordType
+	}{
+		{
+			name:            "ANY query should get synthesized HINFO by default",
+			query:           "example.com",
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__HINFO},
+		},
+		{
+			name:            "ANY query should get single RRset with rrset policy",
+			policy:          AnyPolicy__RRSet,
+			query:           "example.com",
+			expectedCode:    message.ResponseCode__NoError,
+			expectedAnswers: []record.ResourceRecordType{record.ResourceRecordType__SOA},
+		},
+		{
+			name:         "ANY query for missing name should get NXDOMAIN",
+			query:        "missing.example.com",
+			expectedCode: message.ResponseCode__NxDomain,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestServer(t, &Authoritative{
+				repository: managementserver.NewMemoryRecordsRepository(testRecords),
+				anyPolicy:  tc.policy,
+			})
+
+			response := exchange(t, srv, tc.query, record.ResourceRecordType__ANY)
+
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			assert.Equal(t, tc.expectedAnswers, sectionTypes(response.Body.Answers))
+			assert.Empty(t, response.Body.Additional)
+		})
+	}
+}
+
+func TestAuthoritative_minimizeAnyAnswer_fullOverTCP(t *testing.T) {
+	authoritative := &Authoritative{fullAnyOverTCP: true}
+	query := message.Query{
+		Name:                []string{"example", "com"},
+		ResourceRecordType:  record.ResourceRecordType__ANY,
+		ResourceRecordClass: record.ResourceRecordClass__In,
+	}
+
+	answers := []*record.RRSet{
+		record.NewRRSet(query.Name, record.ResourceRecordType__A, record.ResourceRecordClass__In, 60),
+		record.NewRRSet(query.Name, record.ResourceRecordType__MX, record.ResourceRecordClass__In, 60),
+	}
+
+	result := &resolution{answers: answers}
+	authoritative.minimizeAnyAnswer(query, result, TRANSPORT_TCP)
+	assert.Len(t, result.answers, 2)
+
+	for _, transport := range []string{TRANSPORT_TLS, TRANSPORT_HTTPS, TRANSPORT_QUIC} {
+		result = &resolution{answers: answers}
+		authoritative.minimizeAnyAnswer(query, result, transport)
+		assert.Len(t, result.answers, 2, transport)
+	}
+
+	result = &resolution{answers: answers}
+	authoritative.minimizeAnyAnswer(query, result, TRANSPORT_UDP)
+	assert.Len(t, result.answers, 1)
+}
+
+// Returns server listening on loopback, answering requests with the handler
+func newLifecycleTestServer(t *testing.T, handler Handler) *Server {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.Listen = ListenConfig{UDP: "127.0.0.1:0", TCP: "127.0.0.1:0"}
+	config.Backend.Type = Backend__Memory
+
+	srv, err := NewServer(config)
+	assert.NoError(t, err)
+
+	srv.handler = handler
+	return srv
+}
+
+func TestServer_Serve_drainsRequestsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	srv := newLifecycleTestServer(t, HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		WriteError(w, msg, message.ResponseCode__NxDomain)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- srv.Serve(ctx) }()
+
+	conn, err := net.Dial("udp", srv.udpConns[0].LocalAddr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	query := message.NewEncoder().Encode(newTestMessage([]string{"example", "com"}, record.ResourceRecordType__A))
+	_, err = conn.Write(query)
+	assert.NoError(t, err)
+
+	<-started
+	cancel()
+
+	// Response to the request received before shutdown should still be sent
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, MAX_UDP_MESSAGE_SIZE)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+
+	var response message.Message
+	assert.NoError(t, message.NewDecoder(buf[:n]).Decode(&response))
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__NxDomain), response.Header.Flags.ResponseCode)
+
+	assert.NoError(t, <-served)
+
+	_, err = net.Dial("tcp", srv.tcpListener.Addr().String())
+	assert.Error(t, err, "listeners should be closed after shutdown")
+}
+
+func TestServer_Shutdown_deadline(t *testing.T) {
+	cancelled := make(chan struct{})
+	started := make(chan struct{})
+	srv := newLifecycleTestServer(t, HandlerFunc(func(ctx context.Context, w ResponseWriter, msg *message.Message) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+	}))
+
+	go srv.ListenUDP()
+
+	conn, err := net.Dial("udp", srv.udpConns[0].LocalAddr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(message.NewEncoder().Encode(newTestMessage([]string{"example", "com"}, record.ResourceRecordType__A)))
+	assert.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Request blocked past the deadline should be cancelled through its context
+	assert.Error(t, srv.Shutdown(ctx))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request context was not cancelled")
+	}
+}
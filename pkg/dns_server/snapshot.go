@@ -0,0 +1,557 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+const (
+	// Serves records from an in-memory snapshot of the database instead of querying it for every request
+	SNAPSHOT_KEY = "DNS_SNAPSHOT"
+
+	// Seconds between full reloads of the snapshot
+	SNAPSHOT_RESYNC_INTERVAL_KEY = "DNS_SNAPSHOT_RESYNC_INTERVAL"
+)
+
+const DEFAULT_SNAPSHOT_RESYNC_INTERVAL = 300
+
+// Longest wait before reconnecting to the database to listen for changes
+const MAX_WATCH_BACKOFF = 30 * time.Second
+
+type SnapshotConfig struct {
+	// Serves records from memory, keeping them in sync with changes made through the management server
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+
+	// Seconds between full reloads of the snapshot, catching up with changes that were missed
+	ResyncInterval int `yaml:"resync_interval" toml:"resync_interval"`
+}
+
+func (c *SnapshotConfig) Validate() error {
+
+	if c.Enabled && c.ResyncInterval <= 0 {
+		return errors.New(fmt.Sprintf("Invalid backend.snapshot.resync_interval: %d, expected a positive number of seconds", c.ResyncInterval))
+	}
+
+	return nil
+}
+
+// Records of a single view, indexed for lookups
+type viewIndex struct {
+	byID   map[int]managementserver.ManagedDNSResourceRecord
+	byName map[string][]managementserver.ManagedDNSResourceRecord
+	byType map[managementserver.ManagedDNSRecordType]map[int]managementserver.ManagedDNSResourceRecord
+
+	// A and AAAA records by their canonical address, used to synthesize PTR records
+	byAddress map[string]map[int]managementserver.ManagedDNSResourceRecord
+
+	// Numbers of records owned by names below the name, making it exist
+	ancestors map[string]int
+	zones     []managementserver.Zone
+}
+
+func newViewIndex() *viewIndex {
+	return &viewIndex{
+		byID:      make(map[int]managementserver.ManagedDNSResourceRecord),
+		byName:    make(map[string][]managementserver.ManagedDNSResourceRecord),
+		byType:    make(map[managementserver.ManagedDNSRecordType]map[int]managementserver.ManagedDNSResourceRecord),
+		byAddress: make(map[string]map[int]managementserver.ManagedDNSResourceRecord),
+		ancestors: make(map[string]int),
+	}
+}
+
+func (v *viewIndex) add(rr managementserver.ManagedDNSResourceRecord) {
+
+	name := strings.ToLower(rr.Name)
+
+	v.byID[rr.ID] = rr
+	v.byName[name] = append(v.byName[name], rr)
+
+	if v.byType[rr.Type] == nil {
+		v.byType[rr.Type] = make(map[int]managementserver.ManagedDNSResourceRecord)
+	}
+	v.byType[rr.Type][rr.ID] = rr
+
+	if address, ok := addressKey(rr); ok {
+		if v.byAddress[address] == nil {
+			v.byAddress[address] = make(map[int]managementserver.ManagedDNSResourceRecord)
+		}
+		v.byAddress[address][rr.ID] = rr
+	}
+
+	for _, ancestor := range ancestorNames(name) {
+		v.ancestors[ancestor]++
+	}
+
+	if rr.Type == managementserver.ManagedDNSRecordType_SOA {
+		v.indexZones()
+	}
+}
+
+func (v *viewIndex) remove(rr managementserver.ManagedDNSResourceRecord) {
+
+	name := strings.ToLower(rr.Name)
+
+	delete(v.byID, rr.ID)
+
+	remaining := make([]managementserver.ManagedDNSResourceRecord, 0, len(v.byName[name]))
+	for _, owned := range v.byName[name] {
+		if owned.ID != rr.ID {
+			remaining = append(remaining, owned)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(v.byName, name)
+	} else {
+		v.byName[name] = remaining
+	}
+
+	delete(v.byType[rr.Type], rr.ID)
+
+	if address, ok := addressKey(rr); ok {
+		if delete(v.byAddress[address], rr.ID); len(v.byAddress[address]) == 0 {
+			delete(v.byAddress, address)
+		}
+	}
+
+	for _, ancestor := range ancestorNames(name) {
+		if v.ancestors[ancestor]--; v.ancestors[ancestor] <= 0 {
+			delete(v.ancestors, ancestor)
+		}
+	}
+
+	if rr.Type == managementserver.ManagedDNSRecordType_SOA {
+		v.indexZones()
+	}
+}
+
+// Rebuilds zones of the view from its SOA records
+func (v *viewIndex) indexZones() {
+
+	v.zones = make([]managementserver.Zone, 0, len(v.byType[managementserver.ManagedDNSRecordType_SOA]))
+	for _, soa := range sortedByID(v.byType[managementserver.ManagedDNSRecordType_SOA]) {
+		v.zones = append(v.zones, managementserver.NewZone(soa))
+	}
+}
+
+// Returns canonical address of A and AAAA records
+func addressKey(rr managementserver.ManagedDNSResourceRecord) (string, bool) {
+
+	if rr.Type != managementserver.ManagedDNSRecordType_A && rr.Type != managementserver.ManagedDNSRecordType_AAAA {
+		return "", false
+	}
+
+	address := net.ParseIP(rr.Data)
+	if address == nil {
+		return "", false
+	}
+
+	return address.String(), true
+}
+
+// Returns names enclosing the name, e.g. "example.com" and "com" for "www.example.com"
+func ancestorNames(name string) []string {
+
+	labels := strings.Split(name, ".")
+
+	ancestors := make([]string, 0, len(labels)-1)
+	for i := 1; i < len(labels); i++ {
+		ancestors = append(ancestors, strings.Join(labels[i:], "."))
+	}
+	return ancestors
+}
+
+// Returns records ordered by their IDs, in the order they were created
+func sortedByID(records map[int]managementserver.ManagedDNSResourceRecord) []managementserver.ManagedDNSResourceRecord {
+
+	sorted := make([]managementserver.ManagedDNSResourceRecord, 0, len(records))
+	for _, rr := range records {
+		sorted = append(sorted, rr)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	return sorted
+}
+
+// Copy of all records, updated in place when a record changes and replaced as a whole on reloads
+type recordsSnapshot struct {
+	records map[int]managementserver.ManagedDNSResourceRecord
+	views   map[string]*viewIndex
+}
+
+func newRecordsSnapshot(records map[int]managementserver.ManagedDNSResourceRecord) *recordsSnapshot {
+
+	snapshot := &recordsSnapshot{
+		records: make(map[int]managementserver.ManagedDNSResourceRecord, len(records)),
+		views:   make(map[string]*viewIndex),
+	}
+
+	for _, rr := range sortedByID(records) {
+		snapshot.add(rr)
+	}
+
+	return snapshot
+}
+
+func (s *recordsSnapshot) add(rr managementserver.ManagedDNSResourceRecord) {
+
+	// Record reported again replaces its previous version
+	s.remove(rr.ID)
+
+	index, ok := s.views[rr.View]
+	if !ok {
+		index = newViewIndex()
+		s.views[rr.View] = index
+	}
+
+	s.records[rr.ID] = rr
+	index.add(rr)
+}
+
+func (s *recordsSnapshot) remove(id int) {
+
+	rr, ok := s.records[id]
+	if !ok {
+		return
+	}
+
+	delete(s.records, id)
+	s.views[rr.View].remove(rr)
+}
+
+// Snapshot shared by the repository and its views, together with the repository it is loaded from
+type snapshotStore struct {
+	source managementserver.RecordsRepository
+
+	// Guards the snapshot against lookups while it is updated
+	lock     sync.RWMutex
+	snapshot *recordsSnapshot
+
+	// Serializes updates of the snapshot. Reloads hold it while reading the source,
+	// so changes reported in the meantime are applied on top of the reloaded records
+	update sync.Mutex
+}
+
+// Serves records from an in-memory snapshot of the source repository. The snapshot is updated
+// on changes reported by the source and reloaded periodically, when the source is unavailable
+// the last loaded snapshot keeps being served
+type SnapshotRecordsRepository struct {
+	*snapshotStore
+
+	// View the repository is limited to, nil for records of all views
+	view *string
+}
+
+func NewSnapshotRecordsRepository(source managementserver.RecordsRepository) *SnapshotRecordsRepository {
+	return &SnapshotRecordsRepository{snapshotStore: &snapshotStore{
+		source:   source,
+		snapshot: newRecordsSnapshot(make(map[int]managementserver.ManagedDNSResourceRecord)),
+	}}
+}
+
+// Replaces the snapshot with all records of the source
+func (r *SnapshotRecordsRepository) Resync(ctx context.Context) error {
+
+	r.update.Lock()
+	defer r.update.Unlock()
+
+	records, err := r.source.GetRecords(ctx)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[int]managementserver.ManagedDNSResourceRecord, len(records))
+	for _, rr := range records {
+		byID[rr.ID] = rr
+	}
+
+	snapshot := newRecordsSnapshot(byID)
+
+	r.lock.Lock()
+	r.snapshot = snapshot
+	r.lock.Unlock()
+
+	slog.Debug("Reloaded records snapshot", "records", len(byID))
+
+	return nil
+}
+
+// Applies change reported by the source to the snapshot
+func (r *SnapshotRecordsRepository) Apply(ctx context.Context, change managementserver.RecordsChange) error {
+
+	switch change.Operation {
+	case managementserver.RecordsChangeOperation_Create:
+		if change.Record == nil {
+			return r.Resync(ctx)
+		}
+	case managementserver.RecordsChangeOperation_Delete:
+	default:
+		return r.Resync(ctx)
+	}
+
+	r.update.Lock()
+	defer r.update.Unlock()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if change.Operation == managementserver.RecordsChangeOperation_Create {
+		r.snapshot.add(*change.Record)
+	} else {
+		r.snapshot.remove(change.ID)
+	}
+
+	slog.Debug("Applied change of records to snapshot", "op", change.Operation, "id", change.ID)
+
+	return nil
+}
+
+// Reloads the snapshot periodically and applies changes reported by the source until the context is done
+func (r *SnapshotRecordsRepository) Sync(ctx context.Context, resyncInterval time.Duration) {
+
+	var watchers sync.WaitGroup
+
+	if source, ok := r.source.(managementserver.RecordsChangesSource); ok {
+		watchers.Add(1)
+		go func() {
+			defer watchers.Done()
+			r.watch(ctx, source)
+		}()
+	}
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Resync(ctx); err != nil {
+				slog.Warn("Failed to reload records, serving the last snapshot", "err", err)
+			}
+
+		case <-ctx.Done():
+			watchers.Wait()
+			return
+		}
+	}
+}
+
+// Listens for changes, reconnecting with growing delays when the connection is lost
+func (r *SnapshotRecordsRepository) watch(ctx context.Context, source managementserver.RecordsChangesSource) {
+	watchWithBackoff(ctx, "changes of records", func(listening func()) error {
+		return source.WatchRecordsChanges(
+			ctx,
+			func() {
+				listening()
+
+				// Changes made while no one was listening are picked up by a full reload
+				if err := r.Resync(ctx); err != nil {
+					slog.Warn("Failed to reload records, serving the last snapshot", "err", err)
+				}
+			},
+			func(change managementserver.RecordsChange) {
+				if err := r.Apply(ctx, change); err != nil {
+					slog.Warn("Failed to apply change of records, serving the last snapshot", "op", change.Operation, "err", err)
+				}
+			},
+		)
+	})
+}
+
+// Calls watch until the context is done, retrying with growing delays whenever it returns.
+// Watch calls listening once it listens again, which resets the delay
+func watchWithBackoff(ctx context.Context, subject string, watch func(listening func()) error) {
+
+	backoff := time.Second
+
+	for {
+		err := watch(func() { backoff = time.Second })
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("Stopped listening for "+subject+", retrying", "err", err, "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff = min(backoff*2, MAX_WATCH_BACKOFF)
+	}
+}
+
+func (r *SnapshotRecordsRepository) ForView(view string) managementserver.RecordsRepository {
+	return &SnapshotRecordsRepository{snapshotStore: r.snapshotStore, view: &view}
+}
+
+// Calls read with indexes of the view of the repository, or of all views, while the snapshot can't change
+func (r *SnapshotRecordsRepository) read(read func(indexes []*viewIndex)) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.view != nil {
+		index, ok := r.snapshot.views[*r.view]
+		if !ok {
+			read(nil)
+			return
+		}
+		read([]*viewIndex{index})
+		return
+	}
+
+	names := make([]string, 0, len(r.snapshot.views))
+	for name := range r.snapshot.views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	indexes := make([]*viewIndex, 0, len(names))
+	for _, name := range names {
+		indexes = append(indexes, r.snapshot.views[name])
+	}
+	read(indexes)
+}
+
+// Collects records of the indexes, copying them so callers can't modify the snapshot
+func (r *SnapshotRecordsRepository) collect(get func(index *viewIndex) []managementserver.ManagedDNSResourceRecord) []managementserver.ManagedDNSResourceRecord {
+
+	records := make([]managementserver.ManagedDNSResourceRecord, 0)
+	r.read(func(indexes []*viewIndex) {
+		for _, index := range indexes {
+			records = append(records, get(index)...)
+		}
+	})
+	return records
+}
+
+// Checks whether the condition holds for any of the indexes
+func (r *SnapshotRecordsRepository) any(condition func(index *viewIndex) bool) bool {
+
+	found := false
+	r.read(func(indexes []*viewIndex) {
+		for _, index := range indexes {
+			if condition(index) {
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+func (r *SnapshotRecordsRepository) GetRecords(ctx context.Context) ([]managementserver.ManagedDNSResourceRecord, error) {
+	return r.collect(func(index *viewIndex) []managementserver.ManagedDNSResourceRecord {
+		return sortedByID(index.byID)
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) GetRecordsByName(ctx context.Context, name string) ([]managementserver.ManagedDNSResourceRecord, error) {
+	return r.collect(func(index *viewIndex) []managementserver.ManagedDNSResourceRecord {
+		return index.byName[strings.ToLower(name)]
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) GetRecordsByType(ctx context.Context, recordTypes ...managementserver.ManagedDNSRecordType) ([]managementserver.ManagedDNSResourceRecord, error) {
+	return r.collect(func(index *viewIndex) []managementserver.ManagedDNSResourceRecord {
+		records := make([]managementserver.ManagedDNSResourceRecord, 0)
+		for _, recordType := range recordTypes {
+			records = append(records, sortedByID(index.byType[recordType])...)
+		}
+		return records
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) GetRecordsByNameAndType(
+	ctx context.Context,
+	name string,
+	recordType managementserver.ManagedDNSRecordType,
+	class managementserver.ManagedDNSRecordClass,
+) ([]managementserver.ManagedDNSResourceRecord, error) {
+	return r.collect(func(index *viewIndex) []managementserver.ManagedDNSResourceRecord {
+		records := make([]managementserver.ManagedDNSResourceRecord, 0)
+		for _, rr := range index.byName[strings.ToLower(name)] {
+			if rr.Type == recordType && rr.Class == class {
+				records = append(records, rr)
+			}
+		}
+		return records
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) NameExists(ctx context.Context, name string) (bool, error) {
+	return r.any(func(index *viewIndex) bool {
+		return len(index.byName[strings.ToLower(name)]) > 0
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) HasDescendants(ctx context.Context, name string) (bool, error) {
+	return r.any(func(index *viewIndex) bool {
+		return index.ancestors[strings.ToLower(name)] > 0
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) GetRecordsByAddress(ctx context.Context, address net.IP) ([]managementserver.ManagedDNSResourceRecord, error) {
+	return r.collect(func(index *viewIndex) []managementserver.ManagedDNSResourceRecord {
+		return sortedByID(index.byAddress[address.String()])
+	}), nil
+}
+
+func (r *SnapshotRecordsRepository) GetZones(ctx context.Context) ([]managementserver.Zone, error) {
+
+	zones := make([]managementserver.Zone, 0)
+	r.read(func(indexes []*viewIndex) {
+		for _, index := range indexes {
+			zones = append(zones, index.zones...)
+		}
+	})
+	return zones, nil
+}
+
+// Records are created in the source, the snapshot picks them up once the source reports the change
+func (r *SnapshotRecordsRepository) CreateRecord(ctx context.Context, record *managementserver.ManagedDNSResourceRecord) error {
+
+	if r.view != nil {
+		return r.source.ForView(*r.view).CreateRecord(ctx, record)
+	}
+	return r.source.CreateRecord(ctx, record)
+}
+
+func (r *SnapshotRecordsRepository) DeleteRecord(ctx context.Context, id int) error {
+
+	if r.view != nil {
+		return r.source.ForView(*r.view).DeleteRecord(ctx, id)
+	}
+	return r.source.DeleteRecord(ctx, id)
+}
+
+// Returns the repository the snapshot is loaded from
+func (r *SnapshotRecordsRepository) Source() managementserver.RecordsRepository {
+	return r.source
+}
+
+func (r *SnapshotRecordsRepository) Close() error {
+	return r.source.Close()
+}
+
+// Loads snapshot of the source, failing when the source is unavailable
+func newSnapshot(ctx context.Context, source managementserver.RecordsRepository) (*SnapshotRecordsRepository, error) {
+
+	repository := NewSnapshotRecordsRepository(source)
+	if err := repository.Resync(ctx); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to load records snapshot: %s", err))
+	}
+
+	return repository, nil
+}
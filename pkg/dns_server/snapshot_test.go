@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Source of the snapshot which can be made unavailable and reports changes sent to it
+type testSnapshotSource struct {
+	*managementserver.MemoryRecordsRepository
+
+	unavailable atomic.Bool
+	changes     chan managementserver.RecordsChange
+
+	// Blocks reads of all records until closed, when set
+	blocked chan struct{}
+	reading chan struct{}
+}
+
+func newTestSnapshotSource(records []managementserver.ManagedDNSResourceRecord) *testSnapshotSource {
+	return &testSnapshotSource{
+		MemoryRecordsRepository: managementserver.NewMemoryRecordsRepository(records),
+		changes:                 make(chan managementserver.RecordsChange),
+	}
+}
+
+func (s *testSnapshotSource) GetRecords(ctx context.Context) ([]managementserver.ManagedDNSResourceRecord, error) {
+	if s.unavailable.Load() {
+		return nil, errors.New("database is down")
+	}
+
+	records, err := s.MemoryRecordsRepository.GetRecords(ctx)
+	if s.blocked != nil {
+		close(s.reading)
+		<-s.blocked
+	}
+	return records, err
+}
+
+func (s *testSnapshotSource) WatchRecordsChanges(ctx context.Context, listening func(), changed func(change managementserver.RecordsChange)) error {
+
+	listening()
+
+	for {
+		select {
+		case change := <-s.changes:
+			changed(change)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestSnapshotRecordsRepository_lookups(t *testing.T) {
+	ctx := context.Background()
+
+	records := append([]managementserver.ManagedDNSResourceRecord{
+		{Name: "www.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "10.0.0.2", Ttl: 300, View: "internal"},
+		{Name: "example.com", Type: managementserver.ManagedDNSRecordType_SOA, Class: managementserver.ManagedDNSRecordClass_IN, Data: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", Ttl: 3600, View: "internal"},
+	}, testRecords...)
+
+	source := managementserver.NewMemoryRecordsRepository(records)
+
+	snapshot, err := newSnapshot(ctx, source)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		lookup func(repository managementserver.RecordsRepository) (any, error)
+	}{
+		{
+			name: "All records",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.GetRecords(ctx)
+			},
+		},
+		{
+			name: "Records by name, ignoring case",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.GetRecordsByName(ctx, "WWW.example.com")
+			},
+		},
+		{
+			name: "Records by types",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.GetRecordsByType(ctx, managementserver.ManagedDNSRecordType_A, managementserver.ManagedDNSRecordType_NS)
+			},
+		},
+		{
+			name: "Records by name and type",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.GetRecordsByNameAndType(ctx, "mail.example.com", managementserver.ManagedDNSRecordType_AAAA, managementserver.ManagedDNSRecordClass_IN)
+			},
+		},
+		{
+			name: "Existing name",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.NameExists(ctx, "sip.example.com")
+			},
+		},
+		{
+			name: "Empty non-terminal",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.HasDescendants(ctx, "internal.customers.example.com")
+			},
+		},
+		{
+			name: "Name without descendants",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.HasDescendants(ctx, "www.example.com")
+			},
+		},
+		{
+			name: "Records by address",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.GetRecordsByAddress(ctx, net.ParseIP("192.168.1.2"))
+			},
+		},
+		{
+			name: "Zones",
+			lookup: func(repository managementserver.RecordsRepository) (any, error) {
+				return repository.GetZones(ctx)
+			},
+		},
+	}
+
+	repositories := []struct {
+		view     string
+		expected managementserver.RecordsRepository
+		actual   managementserver.RecordsRepository
+	}{
+		{view: "all views", expected: source, actual: snapshot},
+		{view: "default view", expected: source.ForView(managementserver.DEFAULT_VIEW), actual: snapshot.ForView(managementserver.DEFAULT_VIEW)},
+		{view: "internal view", expected: source.ForView("internal"), actual: snapshot.ForView("internal")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, repository := range repositories {
+				expected, err := tc.lookup(repository.expected)
+				assert.NoError(t, err)
+
+				actual, err := tc.lookup(repository.actual)
+				assert.NoError(t, err)
+
+				if reflect.TypeOf(expected).Kind() == reflect.Slice {
+					assert.ElementsMatch(t, expected, actual, repository.view)
+				} else {
+					assert.Equal(t, expected, actual, repository.view)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotRecordsRepository_Apply(t *testing.T) {
+	ctx := context.Background()
+	source := managementserver.NewMemoryRecordsRepository(testRecords)
+
+	snapshot, err := newSnapshot(ctx, source)
+	assert.NoError(t, err)
+
+	created := &managementserver.ManagedDNSResourceRecord{Name: "new.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.10", Ttl: 300}
+	assert.NoError(t, snapshot.CreateRecord(ctx, created))
+
+	exists, _ := snapshot.NameExists(ctx, "new.example.com")
+	assert.False(t, exists, "created record should be served once the change is reported")
+
+	assert.NoError(t, snapshot.Apply(ctx, managementserver.RecordsChange{Operation: managementserver.RecordsChangeOperation_Create, ID: created.ID, Record: created}))
+
+	records, _ := snapshot.GetRecordsByName(ctx, "new.example.com")
+	assert.Equal(t, []managementserver.ManagedDNSResourceRecord{*created}, records)
+
+	www, _ := snapshot.GetRecordsByName(ctx, "www.example.com")
+	assert.NoError(t, snapshot.DeleteRecord(ctx, www[0].ID))
+	assert.NoError(t, snapshot.Apply(ctx, managementserver.RecordsChange{Operation: managementserver.RecordsChangeOperation_Delete, ID: www[0].ID}))
+
+	records, _ = snapshot.GetRecordsByName(ctx, "www.example.com")
+	assert.Equal(t, www[1:], records)
+
+	// Changes the notification couldn't describe are picked up by reading all records
+	assert.NoError(t, source.DeleteRecord(ctx, created.ID))
+	assert.NoError(t, snapshot.Apply(ctx, managementserver.RecordsChange{Operation: managementserver.RecordsChangeOperation_Resync}))
+
+	exists, _ = snapshot.NameExists(ctx, "new.example.com")
+	assert.False(t, exists)
+}
+
+func TestSnapshotRecordsRepository_Resync_concurrentChange(t *testing.T) {
+	ctx := context.Background()
+	source := newTestSnapshotSource(testRecords)
+
+	snapshot, err := newSnapshot(ctx, source)
+	assert.NoError(t, err)
+
+	source.blocked = make(chan struct{})
+	source.reading = make(chan struct{})
+
+	resynced := make(chan error)
+	go func() { resynced <- snapshot.Resync(ctx) }()
+	<-source.reading
+
+	// Record created after the reload read the source, with its change reported before the reload finished
+	created := &managementserver.ManagedDNSResourceRecord{Name: "new.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.10", Ttl: 300}
+	assert.NoError(t, source.CreateRecord(ctx, created))
+
+	applied := make(chan error)
+	go func() {
+		applied <- snapshot.Apply(ctx, managementserver.RecordsChange{Operation: managementserver.RecordsChangeOperation_Create, ID: created.ID, Record: created})
+	}()
+
+	close(source.blocked)
+	assert.NoError(t, <-resynced)
+	assert.NoError(t, <-applied)
+
+	exists, _ := snapshot.NameExists(ctx, "new.example.com")
+	assert.True(t, exists, "change reported during the reload should not be lost")
+
+	// Indexes are updated in place, dropping names left without records
+	assert.NoError(t, source.DeleteRecord(ctx, created.ID))
+	assert.NoError(t, snapshot.Apply(ctx, managementserver.RecordsChange{Operation: managementserver.RecordsChangeOperation_Delete, ID: created.ID}))
+
+	exists, _ = snapshot.NameExists(ctx, "new.example.com")
+	assert.False(t, exists)
+
+	records, _ := snapshot.GetRecordsByType(ctx, managementserver.ManagedDNSRecordType_A)
+	expected, _ := source.GetRecordsByType(ctx, managementserver.ManagedDNSRecordType_A)
+	assert.ElementsMatch(t, expected, records)
+}
+
+func TestSnapshotRecordsRepository_Sync(t *testing.T) {
+	source := newTestSnapshotSource(testRecords)
+
+	snapshot, err := newSnapshot(context.Background(), source)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		snapshot.Sync(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	created := &managementserver.ManagedDNSResourceRecord{Name: "new.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.168.1.10", Ttl: 300}
+	assert.NoError(t, source.CreateRecord(context.Background(), created))
+	source.changes <- managementserver.RecordsChange{Operation: managementserver.RecordsChangeOperation_Create, ID: created.ID, Record: created}
+
+	assert.Eventually(t, func() bool {
+		exists, _ := snapshot.NameExists(context.Background(), "new.example.com")
+		return exists
+	}, time.Second, 5*time.Millisecond)
+
+	// Last snapshot is served while the database is down
+	source.unavailable.Store(true)
+	assert.Error(t, snapshot.Resync(context.Background()))
+	time.Sleep(30 * time.Millisecond)
+
+	records, err := snapshot.GetRecords(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, records, len(testRecords)+1)
+
+	// Records deleted while the database was down are dropped by the next resync
+	assert.NoError(t, source.DeleteRecord(context.Background(), created.ID))
+	source.unavailable.Store(false)
+
+	assert.Eventually(t, func() bool {
+		exists, _ := snapshot.NameExists(context.Background(), "new.example.com")
+		return !exists
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestNewSnapshot_unavailableSource(t *testing.T) {
+	source := newTestSnapshotSource(testRecords)
+	source.unavailable.Store(true)
+
+	_, err := newSnapshot(context.Background(), source)
+	assert.Error(t, err)
+}
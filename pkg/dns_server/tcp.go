@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+const (
+	// Seconds after which TCP connection without any requests is closed
+	TCP_IDLE_TIMEOUT_KEY = "DNS_TCP_IDLE_TIMEOUT"
+
+	// Maximum number of concurrent TCP connections from single client address
+	TCP_MAX_CONNECTIONS_PER_CLIENT_KEY = "DNS_TCP_MAX_CONNECTIONS_PER_CLIENT"
+)
+
+const (
+	DEFAULT_TCP_IDLE_TIMEOUT               = 10 * time.Second
+	DEFAULT_TCP_MAX_CONNECTIONS_PER_CLIENT = 10
+)
+
+// Maximum size of a DNS message sent over TCP, limited by its 2-byte length prefix (RFC 1035 §4.2.2)
+const MAX_TCP_MESSAGE_SIZE = 65535
+
+// Connection shared by requests pipelined by the client. Responses are written as soon
+// as they are ready, so they may be sent in different order than requests (RFC 7766 §6.2.1.1)
+type tcpConnection struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	inFlight  sync.WaitGroup
+}
+
+func (c *tcpConnection) Write(response []byte) error {
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	// Length and message are written at once, so they are not split into separate segments (RFC 7766 §8)
+	_, err := c.conn.Write(frameMessage(response))
+	return err
+}
+
+func (c *tcpConnection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Prefixes the message with its 2-byte length
+func frameMessage(msg []byte) []byte {
+	framed := make([]byte, 0, len(msg)+2)
+	framed = binary.BigEndian.AppendUint16(framed, uint16(len(msg)))
+	return append(framed, msg...)
+}
+
+// Reads single message prefixed with its 2-byte length
+func readTCPMessage(reader io.Reader) ([]byte, error) {
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(reader, length); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Converts idle timeout into edns-tcp-keepalive units of 100 milliseconds (RFC 7828 §3.1)
+func keepaliveTimeout(timeout time.Duration) uint16 {
+	return uint16(min(timeout/(100*time.Millisecond), MAX_TCP_MESSAGE_SIZE))
+}
+
+// Limits number of concurrent connections opened from single client address
+type connectionLimiter struct {
+	limit       int
+	lock        sync.Mutex
+	connections map[string]int
+}
+
+func newConnectionLimiter(limit int) *connectionLimiter {
+	return &connectionLimiter{
+		limit:       limit,
+		connections: make(map[string]int),
+	}
+}
+
+func clientKey(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Reserves connection slot for the client, returns false when the client reached the limit
+func (l *connectionLimiter) acquire(addr net.Addr) bool {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := clientKey(addr)
+	if l.connections[key] >= l.limit {
+		return false
+	}
+
+	l.connections[key]++
+	return true
+}
+
+func (l *connectionLimiter) release(addr net.Addr) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := clientKey(addr)
+	l.connections[key]--
+	if l.connections[key] <= 0 {
+		delete(l.connections, key)
+	}
+}
+
+func (s *Server) ListenTCP() {
+
+	if s.tcpListener == nil {
+		return
+	}
+
+	s.acceptStreams(s.tcpListener, TRANSPORT_TCP)
+}
+
+// Accepts connections carrying length-prefixed messages, shared by TCP and TLS listeners
+func (s *Server) acceptStreams(listener net.Listener, transport string) {
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			slog.Error("failed to accept connection", "transport", transport, "err", err.Error())
+			continue
+		}
+
+		if !s.tcpConnections.acquire(conn.RemoteAddr()) {
+			slog.Warn("Too many connections from client", "transport", transport, "addr", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		s.inFlight.Add(1)
+		go func() {
+			defer s.inFlight.Done()
+			s.serveStream(conn, transport)
+		}()
+	}
+}
+
+// Handles requests sent over the connection until the client closes it, it stays idle for too long or the server stops
+func (s *Server) serveStream(conn net.Conn, transport string) {
+
+	connection := &tcpConnection{conn: conn}
+
+	defer s.tcpConnections.release(conn.RemoteAddr())
+	defer conn.Close()
+
+	// Panic caused by the client closes only its own connection
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Recovered from panic while serving connection", "transport", transport, "addr", conn.RemoteAddr(), "panic", r)
+		}
+	}()
+
+	// Pipelined requests still have to be answered before the connection is closed
+	defer connection.inFlight.Wait()
+
+	// Stopping server interrupts reading the next request, while the ones already read are still answered
+	stopCtx := s.stopContext()
+	stop := context.AfterFunc(stopCtx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		if s.tcpIdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.tcpIdleTimeout))
+		}
+
+		// Deadline set above could replace the one set by the stopping server
+		if stopCtx.Err() != nil {
+			return
+		}
+
+		buf, err := readTCPMessage(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				slog.Error("failed to read message", "transport", transport, "err", err.Error())
+			}
+			return
+		}
+
+		req, err := NewRequest(buf, transport, connection)
+		if err != nil {
+			s.HandleFormattingError(&Request{
+				msg:       &message.Message{},
+				transport: transport,
+				writer:    connection,
+			})
+			continue
+		}
+
+		req.idleTimeout = s.tcpIdleTimeout
+
+		connection.inFlight.Add(1)
+		s.dispatch(req, connection.inFlight.Done)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts TCP listener of the server on loopback and returns its address
+func startTCPServer(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	srv.tcpListener = listener
+	if srv.tcpConnections == nil {
+		srv.tcpConnections = newConnectionLimiter(DEFAULT_TCP_MAX_CONNECTIONS_PER_CLIENT)
+	}
+
+	go srv.ListenTCP()
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String()
+}
+
+func readTestResponse(t *testing.T, conn net.Conn) *message.Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf, err := readTCPMessage(conn)
+	assert.NoError(t, err)
+
+	var response message.Message
+	assert.NoError(t, message.NewDecoder(buf).Decode(&response))
+
+	return &response
+}
+
+func TestServer_ListenTCP_pipelinedQueries(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	srv.tcpIdleTimeout = time.Second
+	addr := startTCPServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// All queries are sent before reading any response
+	queries := make([]byte, 0)
+	for id := range uint16(3) {
+		queries = append(queries, newTestQuery(id, []string{"www", "example", "com"}, record.ResourceRecordType__A, nil)...)
+	}
+	_, err = conn.Write(queries)
+	assert.NoError(t, err)
+
+	// Responses may arrive in any order
+	ids := make([]uint16, 0, 3)
+	for range 3 {
+		response := readTestResponse(t, conn)
+		assert.Equal(t, message.ResponseCode(message.ResponseCode__NoError), response.Header.Flags.ResponseCode)
+		ids = append(ids, response.Header.TransactionId)
+	}
+
+	assert.ElementsMatch(t, []uint16{0, 1, 2}, ids)
+}
+
+func TestServer_ListenTCP_truncatedQuestion(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	addr := startTCPServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Question for "com" without type and class
+	truncated := []byte{0x00, 0x11, 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 'c', 'o', 'm', 0x00}
+	_, err = conn.Write(truncated)
+	assert.NoError(t, err)
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__FormErr), readTestResponse(t, conn).Header.Flags.ResponseCode)
+
+	// Connection and the server keep answering afterwards
+	_, err = conn.Write(newTestQuery(1, []string{"www", "example", "com"}, record.ResourceRecordType__A, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, message.ResponseCode(message.ResponseCode__NoError), readTestResponse(t, conn).Header.Flags.ResponseCode)
+}
+
+func TestServer_ListenTCP_connectionLimit(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	srv.tcpIdleTimeout = time.Second
+	srv.tcpConnections = newConnectionLimiter(1)
+	addr := startTCPServer(t, srv)
+
+	first, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer first.Close()
+
+	// Make sure the first connection was accepted before opening the second one
+	_, err = first.Write(newTestQuery(1, []string{"www", "example", "com"}, record.ResourceRecordType__A, nil))
+	assert.NoError(t, err)
+	readTestResponse(t, first)
+
+	second, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_ListenTCP_idleTimeout(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	srv.tcpIdleTimeout = 100 * time.Millisecond
+	addr := startTCPServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_ListenTCP_keepalive(t *testing.T) {
+	testCases := []struct {
+		name              string
+		edns              *message.EDNS
+		expectedEDNS      bool
+		expectedKeepalive []byte
+	}{
+		{
+			name:         "Query without EDNS should get response without OPT record",
+			edns:         nil,
+			expectedEDNS: false,
+		},
+		{
+			name:         "Query without keepalive option should get no keepalive",
+			edns:         &message.EDNS{UDPPayloadSize: 1232},
+			expectedEDNS: true,
+		},
+		{
+			name: "Query with keepalive option should get idle timeout in 100ms units",
+			edns: &message.EDNS{
+				UDPPayloadSize: 1232,
+				Options:        []message.EDNSOption{{Code: message.EDNSOption__TCPKeepalive}},
+			},
+			expectedEDNS:      true,
+			expectedKeepalive: []byte{0x00, 0x32},
+		},
+	}
+
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	srv.tcpIdleTimeout = 5 * time.Second
+	addr := startTCPServer(t, srv)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			assert.NoError(t, err)
+			defer conn.Close()
+
+			_, err = conn.Write(newTestQuery(1, []string{"www", "example", "com"}, record.ResourceRecordType__A, tc.edns))
+			assert.NoError(t, err)
+
+			edns, err := readTestResponse(t, conn).EDNS()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedEDNS, edns != nil)
+
+			if edns == nil {
+				return
+			}
+
+			keepalive, ok := edns.Option(message.EDNSOption__TCPKeepalive)
+			assert.Equal(t, tc.expectedKeepalive != nil, ok)
+			if ok {
+				assert.Equal(t, tc.expectedKeepalive, keepalive.Data)
+			}
+		})
+	}
+}
+
+func TestServer_ListenTCP_fullAnyAnswer(t *testing.T) {
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords), fullAnyOverTCP: true})
+	srv.tcpIdleTimeout = time.Second
+	addr := startTCPServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(newTestQuery(1, []string{"www", "example", "com"}, record.ResourceRecordType__ANY, nil))
+	assert.NoError(t, err)
+
+	response := readTestResponse(t, conn)
+	assert.ElementsMatch(t,
+		[]record.ResourceRecordType{record.ResourceRecordType__A, record.ResourceRecordType__TXT},
+		sectionTypes(response.Body.Answers))
+}
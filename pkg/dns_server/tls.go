@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+)
+
+const (
+	// Paths to PEM encoded certificate and private key of encrypted listeners.
+	// DNS-over-TLS, DNS-over-HTTPS and DNS-over-QUIC are enabled only when both are set
+	TLS_CERT_FILE_KEY = "DNS_TLS_CERT_FILE"
+	TLS_KEY_FILE_KEY  = "DNS_TLS_KEY_FILE"
+)
+
+// Port of DNS-over-TLS listener (RFC 7858 §3.1)
+const DOT_ADDRESS = ":853"
+
+// ALPN protocol identifier of DNS-over-TLS
+const DOT_ALPN = "dot"
+
+// Responses sent over encrypted transports are padded to multiples of this size (RFC 8467 §4.1)
+const PADDING_BLOCK_SIZE = 468
+
+// Serves certificate loaded from files, reloading it whenever either of them is modified,
+// so renewed certificates are picked up without restarting the server
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	lock        sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	err = reloader.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Returns time of the most recent modification of certificate or key file
+func (r *certificateReloader) latestModTime() (time.Time, error) {
+
+	var latest time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *certificateReloader) load(modTime time.Time) error {
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to load certificate %s: %s", r.certFile, err.Error()))
+	}
+
+	r.certificate = &certificate
+	r.modTime = modTime
+
+	return nil
+}
+
+// Returns current certificate, used as tls.Config.GetCertificate. When the files can't be
+// read or contain invalid certificate, the last valid one is kept
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		slog.Warn("Failed to check certificate files, keeping current certificate", "err", err)
+		return r.certificate, nil
+	}
+
+	if modTime.Equal(r.modTime) {
+		return r.certificate, nil
+	}
+
+	err = r.load(modTime)
+	if err != nil {
+		slog.Warn("Failed to reload certificate, keeping current one", "err", err)
+		return r.certificate, nil
+	}
+
+	slog.Info("Reloaded certificate", "file", r.certFile)
+
+	return r.certificate, nil
+}
+
+// Returns TLS configuration shared by all encrypted listeners
+func newTLSConfig(reloader *certificateReloader, protocols ...string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     protocols,
+	}
+}
+
+func (s *Server) ListenTLS() {
+
+	if s.tlsListener == nil {
+		return
+	}
+
+	s.acceptStreams(s.tlsListener, TRANSPORT_TLS)
+}
+
+func isEncrypted(transport string) bool {
+	return transport == TRANSPORT_TLS || transport == TRANSPORT_HTTPS || transport == TRANSPORT_QUIC
+}
+
+// Returns length of the padding option data making the message a multiple of the block size,
+// accounting for 4 bytes of option code and length
+func paddingLength(messageLength int, blockSize int) int {
+	return (blockSize - (messageLength+4)%blockSize) % blockSize
+}
+
+// Pads the response when the client padded its query over an encrypted transport (RFC 7830 §4)
+func (r *Request) pad(encoded []byte, edns *message.EDNS) []byte {
+
+	if edns == nil || r.edns == nil || !isEncrypted(r.transport) {
+		return encoded
+	}
+
+	if _, ok := r.edns.Option(message.EDNSOption__Padding); !ok {
+		return encoded
+	}
+
+	length := paddingLength(len(encoded), PADDING_BLOCK_SIZE)
+	if len(encoded)+4+length > r.sizeLimit() {
+		return encoded
+	}
+
+	edns.AddOption(message.EDNSOption__Padding, make([]byte, length))
+	r.msg.SetEDNS(edns)
+
+	return message.NewEncoder().EncodeWithinLimit(r.msg, r.sizeLimit())
+}
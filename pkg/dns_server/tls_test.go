@@ -0,0 +1,205 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes self-signed certificate for 127.0.0.1 and its key into the directory
+func writeSelfSignedCertificate(t *testing.T, dir string, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "dns test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
+
+// Starts DNS-over-TLS listener of the server on loopback and returns its address
+func startTLSServer(t *testing.T, srv *Server, reloader *certificateReloader) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", newTLSConfig(reloader, DOT_ALPN))
+	assert.NoError(t, err)
+
+	srv.tlsListener = listener
+	if srv.tcpConnections == nil {
+		srv.tcpConnections = newConnectionLimiter(DEFAULT_TCP_MAX_CONNECTIONS_PER_CLIENT)
+	}
+
+	go srv.ListenTLS()
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String()
+}
+
+func dialTLS(t *testing.T, addr string) *tls.Conn {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{DOT_ALPN},
+	})
+	assert.NoError(t, err)
+
+	return conn
+}
+
+func TestServer_ListenTLS_padding(t *testing.T) {
+	testCases := []struct {
+		name           string
+		edns           *message.EDNS
+		expectedPadded bool
+	}{
+		{
+			name: "Padded query should get response padded to the block size",
+			edns: &message.EDNS{
+				UDPPayloadSize: 1232,
+				Options:        []message.EDNSOption{{Code: message.EDNSOption__Padding, Data: make([]byte, 8)}},
+			},
+			expectedPadded: true,
+		},
+		{
+			name:           "Query without padding should get unpadded response",
+			edns:           &message.EDNS{UDPPayloadSize: 1232},
+			expectedPadded: false,
+		},
+	}
+
+	certFile, keyFile := writeSelfSignedCertificate(t, t.TempDir(), 1)
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	srv.tcpIdleTimeout = time.Second
+	addr := startTLSServer(t, srv, reloader)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := dialTLS(t, addr)
+			defer conn.Close()
+
+			_, err := conn.Write(newTestQuery(1, []string{"www", "example", "com"}, record.ResourceRecordType__A, tc.edns))
+			assert.NoError(t, err)
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			buf, err := readTCPMessage(conn)
+			assert.NoError(t, err)
+
+			var response message.Message
+			assert.NoError(t, message.NewDecoder(buf).Decode(&response))
+			assert.Equal(t, []record.ResourceRecordType{record.ResourceRecordType__A}, sectionTypes(response.Body.Answers))
+
+			edns, err := response.EDNS()
+			assert.NoError(t, err)
+
+			_, padded := edns.Option(message.EDNSOption__Padding)
+			assert.Equal(t, tc.expectedPadded, padded)
+			if tc.expectedPadded {
+				assert.Equal(t, 0, len(buf)%PADDING_BLOCK_SIZE)
+			}
+		})
+	}
+}
+
+func TestCertificateReloader_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile := writeSelfSignedCertificate(t, dir, 1)
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(testRecords)})
+	srv.tcpIdleTimeout = time.Second
+	addr := startTLSServer(t, srv, reloader)
+
+	conn := dialTLS(t, addr)
+	assert.Equal(t, int64(1), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	conn.Close()
+
+	// Renewed certificate should be served to new connections
+	writeSelfSignedCertificate(t, dir, 2)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, future, future))
+
+	conn = dialTLS(t, addr)
+	assert.Equal(t, int64(2), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	conn.Close()
+
+	// Invalid certificate should not replace the last valid one
+	assert.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0600))
+	future = future.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, future, future))
+
+	conn = dialTLS(t, addr)
+	assert.Equal(t, int64(2), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	conn.Close()
+}
+
+func TestPaddingLength(t *testing.T) {
+	testCases := []struct {
+		name           string
+		messageLength  int
+		expectedLength int
+	}{
+		{
+			name:           "Short message should be padded to single block",
+			messageLength:  100,
+			expectedLength: 364,
+		},
+		{
+			name:           "Message filling the block with option header should not be padded",
+			messageLength:  464,
+			expectedLength: 0,
+		},
+		{
+			name:           "Message exceeding the block should be padded to the next one",
+			messageLength:  500,
+			expectedLength: 432,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedLength, paddingLength(tc.messageLength, PADDING_BLOCK_SIZE))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+)
+
+// Split-horizon view, serving its own set of records to clients from its sources
+type ViewConfig struct {
+	Name string `yaml:"name" toml:"name"`
+
+	// Client addresses or CIDRs the view is served to
+	Sources []string `yaml:"sources" toml:"sources"`
+}
+
+func validateViews(views []ViewConfig) error {
+
+	errs := make([]error, 0)
+	names := make(map[string]bool)
+
+	for i, view := range views {
+		if view.Name == managementserver.DEFAULT_VIEW || !managementserver.IsValidViewName(view.Name) {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid name of view %d: %q, expected letters, digits, \"-\" or \"_\"", i+1, view.Name)))
+		}
+
+		if names[view.Name] {
+			errs = append(errs, errors.New(fmt.Sprintf("Duplicate view: %s", view.Name)))
+		}
+		names[view.Name] = true
+
+		if len(view.Sources) == 0 {
+			errs = append(errs, errors.New(fmt.Sprintf("View %s has no sources", view.Name)))
+		}
+
+		if _, err := parsePrefixes(view.Sources); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid source of view %s: %s", view.Name, err)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type view struct {
+	name    string
+	sources []netip.Prefix
+}
+
+// Picks the view served to the client, the first one listing its address wins
+type viewSelector struct {
+	views []view
+}
+
+func newViewSelector(configs []ViewConfig) (*viewSelector, error) {
+
+	selector := &viewSelector{views: make([]view, 0, len(configs))}
+
+	for _, config := range configs {
+		sources, err := parsePrefixes(config.Sources)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid source of view %s: %s", config.Name, err))
+		}
+		selector.views = append(selector.views, view{name: config.Name, sources: sources})
+	}
+
+	return selector, nil
+}
+
+// Returns name of the view served to the client, the default view when it matches none
+func (s *viewSelector) selectView(remote net.Addr) string {
+
+	if s == nil {
+		return managementserver.DEFAULT_VIEW
+	}
+
+	addr, ok := clientAddr(remote)
+	if !ok {
+		return managementserver.DEFAULT_VIEW
+	}
+
+	for _, view := range s.views {
+		for _, source := range view.sources {
+			if source.Contains(addr) {
+				return view.name
+			}
+		}
+	}
+
+	return managementserver.DEFAULT_VIEW
+}
+
+type viewContextKey struct{}
+
+func withView(ctx context.Context, view string) context.Context {
+	return context.WithValue(ctx, viewContextKey{}, view)
+}
+
+// Returns the view selected for the request, the default view when none was
+func ViewFromContext(ctx context.Context) string {
+	view, _ := ctx.Value(viewContextKey{}).(string)
+	return view
+}
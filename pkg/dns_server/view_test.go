@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+	managementserver "github.com/XxRoloxX/dns/pkg/management_server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewSelector_selectView(t *testing.T) {
+	selector, err := newViewSelector([]ViewConfig{
+		{Name: "internal", Sources: []string{"10.0.0.0/8", "fd00::/8"}},
+		{Name: "office", Sources: []string{"10.1.0.0/16", "192.168.1.10"}},
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		addr     net.Addr
+		expected string
+	}{
+		{
+			name:     "Client should get the first view listing its address",
+			addr:     &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3)},
+			expected: "internal",
+		},
+		{
+			name:     "Single address should be accepted as source",
+			addr:     &net.TCPAddr{IP: net.IPv4(192, 168, 1, 10)},
+			expected: "office",
+		},
+		{
+			name:     "IPv6 client should be matched",
+			addr:     &net.UDPAddr{IP: net.ParseIP("fd00::1")},
+			expected: "internal",
+		},
+		{
+			name:     "Client matching no view should get the default view",
+			addr:     &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1)},
+			expected: managementserver.DEFAULT_VIEW,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, selector.selectView(tc.addr))
+		})
+	}
+}
+
+func TestServer_HandleRequest_view(t *testing.T) {
+	soa := "ns1.example.com. admin.example.com. 1 3600 600 86400 300"
+	records := []managementserver.ManagedDNSResourceRecord{
+		{Name: "example.com", Type: managementserver.ManagedDNSRecordType_SOA, Class: managementserver.ManagedDNSRecordClass_IN, Data: soa, Ttl: 300},
+		{Name: "www.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.0.2.1", Ttl: 300},
+		{Name: "example.com", Type: managementserver.ManagedDNSRecordType_SOA, Class: managementserver.ManagedDNSRecordClass_IN, Data: soa, Ttl: 300, View: "internal"},
+		{Name: "www.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "10.0.0.1", Ttl: 300, View: "internal"},
+		{Name: "intranet.example.com", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "10.0.0.2", Ttl: 300, View: "internal"},
+		{Name: "example.org", Type: managementserver.ManagedDNSRecordType_SOA, Class: managementserver.ManagedDNSRecordClass_IN, Data: soa, Ttl: 300},
+		{Name: "www.example.org", Type: managementserver.ManagedDNSRecordType_A, Class: managementserver.ManagedDNSRecordClass_IN, Data: "192.0.2.2", Ttl: 300},
+	}
+
+	testCases := []struct {
+		name         string
+		views        []ViewConfig
+		query        string
+		expectedCode message.ResponseCode
+		expectedData []byte
+	}{
+		{
+			name:         "Client of the view should get records of the view",
+			views:        []ViewConfig{{Name: "internal", Sources: []string{"127.0.0.0/8"}}},
+			query:        "www.example.com",
+			expectedData: []byte{10, 0, 0, 1},
+		},
+		{
+			name:         "Other clients should get records of the default view",
+			views:        []ViewConfig{{Name: "internal", Sources: []string{"10.0.0.0/8"}}},
+			query:        "www.example.com",
+			expectedData: []byte{192, 0, 2, 1},
+		},
+		{
+			name:         "Client of the view should get zones the view doesn't define from the default view",
+			views:        []ViewConfig{{Name: "internal", Sources: []string{"127.0.0.0/8"}}},
+			query:        "www.example.org",
+			expectedData: []byte{192, 0, 2, 2},
+		},
+		{
+			name:         "Names of other views should not exist in the default view",
+			views:        []ViewConfig{{Name: "internal", Sources: []string{"10.0.0.0/8"}}},
+			query:        "intranet.example.com",
+			expectedCode: message.ResponseCode__NxDomain,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := newViewSelector(tc.views)
+			assert.NoError(t, err)
+
+			srv := newTestServer(t, &Authoritative{repository: managementserver.NewMemoryRecordsRepository(records)})
+			srv.views = selector
+
+			response := exchange(t, srv, tc.query, record.ResourceRecordType__A)
+
+			assert.Equal(t, tc.expectedCode, response.Header.Flags.ResponseCode)
+			if tc.expectedData == nil {
+				assert.Empty(t, response.Body.Answers)
+				return
+			}
+
+			assert.Len(t, response.Body.Answers, 1)
+			assert.Equal(t, tc.expectedData, response.Body.Answers[0].RData)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package managementserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	message "github.com/XxRoloxX/dns/pkg/dns_message"
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+// Action taken on queries matching an ACL rule
+type ACLAction string
+
+const (
+	ACLAction_Allow    ACLAction = "allow"
+	ACLAction_Refuse   ACLAction = "refuse"
+	ACLAction_Drop     ACLAction = "drop"
+	ACLAction_NxDomain ACLAction = "nxdomain"
+)
+
+// Rule of the access control list of the DNS server. Empty fields match any query,
+// rules are evaluated by ascending priority and the first matching one decides
+type ManagedACLRule struct {
+	ID       int `gorm:"primaryKey;autoIncrement" json:"id" yaml:"-" toml:"-"`
+	Priority int `gorm:"not null;default:0" json:"priority" yaml:"priority" toml:"priority"`
+
+	// Client address or CIDR, e.g. "10.0.0.0/8"
+	Source string `json:"source" yaml:"source" toml:"source"`
+
+	// Transport of the query: udp, tcp, tls, https or quic
+	Transport string `json:"transport" yaml:"transport" toml:"transport"`
+
+	// Query names equal to or below the suffix, e.g. "example.com"
+	Name string `json:"name" yaml:"name" toml:"name"`
+
+	// Query type mnemonic or code, e.g. "ANY"
+	Type string `json:"type" yaml:"type" toml:"type"`
+
+	// Operation code: query, iquery, status, notify or update
+	OpCode string `json:"opcode" yaml:"opcode" toml:"opcode"`
+
+	Action ACLAction `gorm:"not null" json:"action" yaml:"action" toml:"action"`
+
+	// Number of queries the rule matched, updated by the DNS server
+	Hits uint64 `gorm:"not null;default:0" json:"hits" yaml:"-" toml:"-"`
+}
+
+func isValidACLAction(action ACLAction) bool {
+	switch action {
+	case ACLAction_Allow, ACLAction_Refuse, ACLAction_Drop, ACLAction_NxDomain:
+		return true
+	default:
+		return false
+	}
+}
+
+// Transports the DNS server receives queries over
+var aclTransports = []string{"udp", "tcp", "tls", "https", "quic"}
+
+func (r *ManagedACLRule) Validate() error {
+
+	if !isValidACLAction(r.Action) {
+		return errors.New(fmt.Sprintf("Invalid ACL action: %s, expected allow, refuse, drop or nxdomain", r.Action))
+	}
+
+	if r.Source != "" {
+		if _, err := r.SourcePrefix(); err != nil {
+			return err
+		}
+	}
+
+	if r.Transport != "" && !slices.Contains(aclTransports, strings.ToLower(r.Transport)) {
+		return errors.New(fmt.Sprintf("Invalid ACL transport: %s, expected one of %s", r.Transport, strings.Join(aclTransports, ", ")))
+	}
+
+	if r.Type != "" {
+		if _, err := record.ParseResourceRecordType(r.Type); err != nil {
+			return err
+		}
+	}
+
+	if r.OpCode != "" {
+		if _, err := message.ParseOperationCode(r.OpCode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns source of the rule as a prefix, a single address matching only itself
+func (r *ManagedACLRule) SourcePrefix() (netip.Prefix, error) {
+
+	if !strings.Contains(r.Source, "/") {
+		addr, err := netip.ParseAddr(r.Source)
+		if err != nil {
+			return netip.Prefix{}, errors.New(fmt.Sprintf("Invalid ACL source: %s, expected address or CIDR", r.Source))
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(r.Source)
+	if err != nil {
+		return netip.Prefix{}, errors.New(fmt.Sprintf("Invalid ACL source: %s, expected address or CIDR", r.Source))
+	}
+
+	return prefix.Masked(), nil
+}
+
+// Storage of ACL rules of the DNS server
+type ACLRepository interface {
+	GetACLRules(ctx context.Context) ([]ManagedACLRule, error)
+	CreateACLRule(ctx context.Context, rule *ManagedACLRule) error
+	DeleteACLRule(ctx context.Context, id int) error
+
+	// Adds numbers of matched queries to hit counters of rules, given by their IDs
+	AddACLRuleHits(ctx context.Context, hits map[int]uint64) error
+}
+
+type ACLService struct {
+	aclRepository ACLRepository
+}
+
+func NewACLService(aclRepository ACLRepository) *ACLService {
+	return &ACLService{
+		aclRepository: aclRepository,
+	}
+}
+
+func (s *ACLService) GetRules(ctx context.Context) ([]ManagedACLRule, error) {
+	return s.aclRepository.GetACLRules(ctx)
+}
+
+func (s *ACLService) CreateRule(ctx context.Context, rule *ManagedACLRule) error {
+	if rule == nil {
+		return errors.New("rule cannot be nil")
+	}
+
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	rule.Hits = 0
+	return s.aclRepository.CreateACLRule(ctx, rule)
+}
+
+func (s *ACLService) DeleteRule(ctx context.Context, id int) error {
+	if id <= 0 {
+		return errors.New("invalid rule ID")
+	}
+
+	return s.aclRepository.DeleteACLRule(ctx, id)
+}
@@ -0,0 +1,62 @@
+package managementserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagedACLRule_Validate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		rule  ManagedACLRule
+		valid bool
+	}{
+		{
+			name:  "Rule matching any query should be valid",
+			rule:  ManagedACLRule{Action: ACLAction_Refuse},
+			valid: true,
+		},
+		{
+			name:  "Rule with all conditions should be valid",
+			rule:  ManagedACLRule{Source: "2001:db8::/32", Transport: "TCP", Name: "example.com", Type: "any", OpCode: "update", Action: ACLAction_Drop},
+			valid: true,
+		},
+		{
+			name:  "Single address should be accepted as source",
+			rule:  ManagedACLRule{Source: "192.168.1.1", Action: ACLAction_Allow},
+			valid: true,
+		},
+		{
+			name: "Unknown action should be rejected",
+			rule: ManagedACLRule{Action: "reject"},
+		},
+		{
+			name: "Invalid source should be rejected",
+			rule: ManagedACLRule{Source: "10.0.0.0/33", Action: ACLAction_Allow},
+		},
+		{
+			name: "Unknown transport should be rejected",
+			rule: ManagedACLRule{Transport: "sctp", Action: ACLAction_Allow},
+		},
+		{
+			name: "Unknown type should be rejected",
+			rule: ManagedACLRule{Type: "BOGUS", Action: ACLAction_Allow},
+		},
+		{
+			name: "Unknown opcode should be rejected",
+			rule: ManagedACLRule{OpCode: "dso", Action: ACLAction_Allow},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package managementserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Postgres channel the management server notifies DNS servers about flushes of their caches on
+const CACHE_FLUSH_CHANNEL = "dns_cache_flush"
+
+// Removes cached responses to queries for the name, or for the name and all names below it
+type CacheFlush struct {
+	// Name of the query, "." with subtree flushes the whole cache
+	Name    string `json:"name"`
+	Subtree bool   `json:"subtree"`
+}
+
+func (f *CacheFlush) Validate() error {
+
+	if strings.TrimSpace(f.Name) == "" {
+		return errors.New("Name to flush is required, use . with subtree to flush the whole cache")
+	}
+
+	if strings.Contains(strings.Trim(f.Name, "."), "..") {
+		return errors.New(fmt.Sprintf("Invalid name to flush: %s", f.Name))
+	}
+
+	return nil
+}
+
+// Returns labels of the name to flush, empty for the root
+func (f *CacheFlush) NameLabels() []string {
+
+	name := strings.Trim(strings.ToLower(f.Name), ".")
+	if name == "" {
+		return []string{}
+	}
+
+	return strings.Split(name, ".")
+}
+
+// Delivers flushes of the cache to DNS servers
+type CacheFlusher interface {
+	FlushCache(ctx context.Context, flush CacheFlush) error
+}
+
+// Implemented by repositories able to report flushes of the cache requested by other processes
+type CacheFlushSource interface {
+	// Reports flushes until the context is done or the connection is lost. Listening is called once
+	// flushes are being listened for, flushes requested before that are not reported
+	WatchCacheFlushes(ctx context.Context, listening func(), flushed func(flush CacheFlush)) error
+}
+
+func (r *PostgresRecordsRepository) FlushCache(ctx context.Context, flush CacheFlush) error {
+
+	payload, err := json.Marshal(flush)
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", CACHE_FLUSH_CHANNEL, string(payload)).Error
+}
+
+func (r *PostgresRecordsRepository) WatchCacheFlushes(ctx context.Context, listening func(), flushed func(flush CacheFlush)) error {
+	return r.listen(ctx, CACHE_FLUSH_CHANNEL, listening, func(payload string) {
+
+		var flush CacheFlush
+		if err := json.Unmarshal([]byte(payload), &flush); err != nil {
+			// Flush which can't be read is taken as a flush of everything
+			flush = CacheFlush{Name: ".", Subtree: true}
+		}
+
+		flushed(flush)
+	})
+}
+
+type CacheService struct {
+	flusher CacheFlusher
+}
+
+func NewCacheService(flusher CacheFlusher) *CacheService {
+	return &CacheService{
+		flusher: flusher,
+	}
+}
+
+func (s *CacheService) Flush(ctx context.Context, flush CacheFlush) error {
+
+	if err := flush.Validate(); err != nil {
+		return err
+	}
+
+	return s.flusher.FlushCache(ctx, flush)
+}
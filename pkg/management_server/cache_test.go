@@ -0,0 +1,50 @@
+package managementserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheFlush_Validate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		flush CacheFlush
+		valid bool
+	}{
+		{
+			name:  "Flush of a name should be valid",
+			flush: CacheFlush{Name: "www.example.com."},
+			valid: true,
+		},
+		{
+			name:  "Flush of the root subtree should be valid",
+			flush: CacheFlush{Name: ".", Subtree: true},
+			valid: true,
+		},
+		{
+			name:  "Flush without name should be rejected",
+			flush: CacheFlush{Subtree: true},
+		},
+		{
+			name:  "Name with empty label should be rejected",
+			flush: CacheFlush{Name: "www..example.com"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.flush.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCacheFlush_NameLabels(t *testing.T) {
+	assert.Equal(t, []string{"www", "example", "com"}, (&CacheFlush{Name: "WWW.Example.com."}).NameLabels())
+	assert.Empty(t, (&CacheFlush{Name: "."}).NameLabels())
+}
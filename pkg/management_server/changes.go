@@ -0,0 +1,94 @@
+package managementserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// Postgres channel the management server notifies about changes of records on
+const RECORDS_CHANGES_CHANNEL = "dns_records_changes"
+
+// Largest payload Postgres accepts in a notification is 8000 bytes
+const MAX_NOTIFICATION_PAYLOAD_SIZE = 7999
+
+type RecordsChangeOperation string
+
+const (
+	RecordsChangeOperation_Create RecordsChangeOperation = "create"
+	RecordsChangeOperation_Delete RecordsChangeOperation = "delete"
+
+	// Records changed in a way not described by the notification, all of them have to be read again
+	RecordsChangeOperation_Resync RecordsChangeOperation = "resync"
+)
+
+// Change of records made through the management server
+type RecordsChange struct {
+	Operation RecordsChangeOperation    `json:"op"`
+	ID        int                       `json:"id,omitempty"`
+	Record    *ManagedDNSResourceRecord `json:"record,omitempty"`
+}
+
+// Implemented by repositories able to report changes of records made by other processes
+type RecordsChangesSource interface {
+	// Reports changes until the context is done or the connection is lost. Listening is called once
+	// changes are being listened for, changes made before that are not reported
+	WatchRecordsChanges(ctx context.Context, listening func(), changed func(change RecordsChange)) error
+}
+
+// Notifies listeners about the change once the transaction commits
+func notifyRecordsChange(tx *gorm.DB, change RecordsChange) error {
+
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	// Listeners read all records again when the change doesn't fit in the notification
+	if len(payload) > MAX_NOTIFICATION_PAYLOAD_SIZE {
+		payload, _ = json.Marshal(RecordsChange{Operation: RecordsChangeOperation_Resync})
+	}
+
+	return tx.Exec("SELECT pg_notify(?, ?)", RECORDS_CHANGES_CHANNEL, string(payload)).Error
+}
+
+func (r *PostgresRecordsRepository) WatchRecordsChanges(ctx context.Context, listening func(), changed func(change RecordsChange)) error {
+	return r.listen(ctx, RECORDS_CHANGES_CHANNEL, listening, func(payload string) {
+
+		var change RecordsChange
+		if err := json.Unmarshal([]byte(payload), &change); err != nil {
+			change = RecordsChange{Operation: RecordsChangeOperation_Resync}
+		}
+
+		changed(change)
+	})
+}
+
+// Passes payloads of notifications sent on the channel to the callback until the context is done or the connection is lost
+func (r *PostgresRecordsRepository) listen(ctx context.Context, channel string, listening func(), notified func(payload string)) error {
+
+	conn, err := pgx.Connect(ctx, r.config.connectionString())
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to connect to the database: %s", err))
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return errors.New(fmt.Sprintf("Failed to listen on %s: %s", channel, err))
+	}
+
+	listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		notified(notification.Payload)
+	}
+}
@@ -1,6 +1,7 @@
 package managementserver
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -20,6 +21,10 @@ type GetRecordsController struct {
 	service *RecordsService
 }
 
+type GetSynthesizedPTRRecordsController struct {
+	service *RecordsService
+}
+
 func NewNewRecordController(service *RecordsService) *NewRecordController {
 	return &NewRecordController{
 		service: service,
@@ -38,11 +43,22 @@ func NewGetRecordsController(service *RecordsService) *GetRecordsController {
 	}
 }
 
+func NewGetSynthesizedPTRRecordsController(service *RecordsService) *GetSynthesizedPTRRecordsController {
+	return &GetSynthesizedPTRRecordsController{
+		service: service,
+	}
+}
+
 type NewRecordParams struct {
 	Name  string                `json:"name" form:"name" xml:"name" binding:"required"`
 	Type  ManagedDNSRecordType  `json:"type"`
 	Class ManagedDNSRecordClass `json:"class"`
 	Data  string                `json:"data" form:"data" xml:"data" binding:"required"`
+	Ttl   uint32                `json:"ttl"`
+	View  string                `json:"view"`
+
+	// Allowed on SOA records only, enables PTR synthesis within the zone
+	SynthesizePTR bool `json:"synthesize_ptr"`
 }
 
 func (c *NewRecordController) Handle(g *gin.Context) {
@@ -66,12 +82,26 @@ func (c *NewRecordController) Handle(g *gin.Context) {
 		return
 	}
 
-	if err := c.service.CreateRecord(&ManagedDNSResourceRecord{
+	if !IsValidViewName(record.View) {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "Invalid view name"})
+		return
+	}
+
+	if err := c.service.CreateRecord(g.Request.Context(), &ManagedDNSResourceRecord{
 		Name:  record.Name,
 		Type:  record.Type,
 		Class: record.Class,
 		Data:  record.Data,
+		Ttl:   record.Ttl,
+		View:  record.View,
+
+		SynthesizePTR: record.SynthesizePTR,
 	}); err != nil {
+		if errors.Is(err, ErrRecordConflict) {
+			g.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
 		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -88,7 +118,7 @@ func (c *DeleteRecordController) Handle(g *gin.Context) {
 		return
 	}
 
-	if err := c.service.DeleteRecord(id); err != nil {
+	if err := c.service.DeleteRecord(g.Request.Context(), id); err != nil {
 		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -98,7 +128,24 @@ func (c *DeleteRecordController) Handle(g *gin.Context) {
 
 func (c *GetRecordsController) Handle(g *gin.Context) {
 
-	records, err := c.service.GetRecords()
+	// Records of all views are returned unless the view is given, "?view=" selects the default view
+	var view *string
+	if value, ok := g.GetQuery("view"); ok {
+		view = &value
+	}
+
+	records, err := c.service.GetRecords(g.Request.Context(), view)
+	if err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusOK, records)
+}
+
+func (c *GetSynthesizedPTRRecordsController) Handle(g *gin.Context) {
+
+	records, err := c.service.GetSynthesizedPTRRecords(g.Request.Context(), g.Query("view"))
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -116,6 +163,8 @@ func isValidRecordType(recordType ManagedDNSRecordType) bool {
 		ManagedDNSRecordType_CNAME: true,
 		ManagedDNSRecordType_NS:    true,
 		ManagedDNSRecordType_SOA:   true,
+		ManagedDNSRecordType_PTR:   true,
+		ManagedDNSRecordType_SRV:   true,
 	}
 
 	return validRecordTypes[recordType]
@@ -131,3 +180,234 @@ func isValidRecordClass(recordClass ManagedDNSRecordClass) bool {
 
 	return validRecordClasses[recordClass]
 }
+
+type GetACLRulesController struct {
+	service *ACLService
+}
+
+type NewACLRuleController struct {
+	service *ACLService
+}
+
+type DeleteACLRuleController struct {
+	service *ACLService
+}
+
+func NewGetACLRulesController(service *ACLService) *GetACLRulesController {
+	return &GetACLRulesController{
+		service: service,
+	}
+}
+
+func NewNewACLRuleController(service *ACLService) *NewACLRuleController {
+	return &NewACLRuleController{
+		service: service,
+	}
+}
+
+func NewDeleteACLRuleController(service *ACLService) *DeleteACLRuleController {
+	return &DeleteACLRuleController{
+		service: service,
+	}
+}
+
+type NewACLRuleParams struct {
+	Priority  int       `json:"priority"`
+	Source    string    `json:"source"`
+	Transport string    `json:"transport"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	OpCode    string    `json:"opcode"`
+	Action    ACLAction `json:"action" binding:"required"`
+}
+
+func (c *GetACLRulesController) Handle(g *gin.Context) {
+
+	rules, err := c.service.GetRules(g.Request.Context())
+	if err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusOK, rules)
+}
+
+func (c *NewACLRuleController) Handle(g *gin.Context) {
+
+	var params NewACLRuleParams
+
+	if err := g.ShouldBindJSON(&params); err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := &ManagedACLRule{
+		Priority:  params.Priority,
+		Source:    params.Source,
+		Transport: params.Transport,
+		Name:      params.Name,
+		Type:      params.Type,
+		OpCode:    params.OpCode,
+		Action:    params.Action,
+	}
+
+	if err := rule.Validate(); err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.CreateRule(g.Request.Context(), rule); err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusCreated, rule)
+}
+
+func (c *DeleteACLRuleController) Handle(g *gin.Context) {
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil || id <= 0 {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if err := c.service.DeleteRule(g.Request.Context(), id); err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+}
+
+type GetForwardRulesController struct {
+	service *ForwardService
+}
+
+type NewForwardRuleController struct {
+	service *ForwardService
+}
+
+type DeleteForwardRuleController struct {
+	service *ForwardService
+}
+
+func NewGetForwardRulesController(service *ForwardService) *GetForwardRulesController {
+	return &GetForwardRulesController{
+		service: service,
+	}
+}
+
+func NewNewForwardRuleController(service *ForwardService) *NewForwardRuleController {
+	return &NewForwardRuleController{
+		service: service,
+	}
+}
+
+func NewDeleteForwardRuleController(service *ForwardService) *DeleteForwardRuleController {
+	return &DeleteForwardRuleController{
+		service: service,
+	}
+}
+
+type NewForwardRuleParams struct {
+	Domain    string   `json:"domain" binding:"required"`
+	Upstreams []string `json:"upstreams" binding:"required"`
+	NoCache   bool     `json:"no_cache"`
+	StripECS  bool     `json:"strip_ecs"`
+}
+
+func (c *GetForwardRulesController) Handle(g *gin.Context) {
+
+	rules, err := c.service.GetRules(g.Request.Context())
+	if err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusOK, rules)
+}
+
+func (c *NewForwardRuleController) Handle(g *gin.Context) {
+
+	var params NewForwardRuleParams
+
+	if err := g.ShouldBindJSON(&params); err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := &ManagedForwardRule{
+		Domain:    params.Domain,
+		Upstreams: params.Upstreams,
+		NoCache:   params.NoCache,
+		StripECS:  params.StripECS,
+	}
+
+	if err := rule.Validate(); err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.CreateRule(g.Request.Context(), rule); err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusCreated, rule)
+}
+
+func (c *DeleteForwardRuleController) Handle(g *gin.Context) {
+
+	id, err := strconv.Atoi(g.Param("id"))
+	if err != nil || id <= 0 {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if err := c.service.DeleteRule(g.Request.Context(), id); err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+}
+
+type FlushCacheController struct {
+	service *CacheService
+}
+
+func NewFlushCacheController(service *CacheService) *FlushCacheController {
+	return &FlushCacheController{
+		service: service,
+	}
+}
+
+type FlushCacheParams struct {
+	Name    string `json:"name" binding:"required"`
+	Subtree bool   `json:"subtree"`
+}
+
+func (c *FlushCacheController) Handle(g *gin.Context) {
+
+	var params FlushCacheParams
+
+	if err := g.ShouldBindJSON(&params); err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	flush := CacheFlush{Name: params.Name, Subtree: params.Subtree}
+
+	if err := flush.Validate(); err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.Flush(g.Request.Context(), flush); err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"message": "Cache flushed successfully"})
+}
@@ -0,0 +1,125 @@
+package managementserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	dnsaddress "github.com/XxRoloxX/dns/pkg/dns_address"
+	"gorm.io/gorm"
+)
+
+// Postgres channel the management server notifies DNS servers about changes of forward rules on
+const FORWARD_RULES_CHANGES_CHANNEL = "dns_forward_rules_changes"
+
+// Rule of conditional forwarding of the DNS server. Queries for names equal to or below the domain
+// are forwarded to the upstreams of the rule, the rule with the longest matching domain wins
+type ManagedForwardRule struct {
+	ID int `gorm:"primaryKey;autoIncrement" json:"id" yaml:"-" toml:"-"`
+
+	// Domain suffix, e.g. "corp.internal", or "." for every name
+	Domain string `gorm:"not null" json:"domain" yaml:"domain" toml:"domain"`
+
+	// Addresses of the upstreams, e.g. "10.0.0.53" or "tls://1.1.1.1#cloudflare-dns.com"
+	Upstreams []string `gorm:"serializer:json;not null" json:"upstreams" yaml:"upstreams" toml:"upstreams"`
+
+	// Responses are not stored in caches of the DNS server and HTTP caches
+	NoCache bool `gorm:"not null;default:false" json:"no_cache" yaml:"no_cache" toml:"no_cache"`
+
+	// EDNS Client Subnet option of the client is not passed on to the upstreams
+	StripECS bool `gorm:"not null;default:false" json:"strip_ecs" yaml:"strip_ecs" toml:"strip_ecs"`
+}
+
+func (r *ManagedForwardRule) Validate() error {
+
+	if strings.TrimSpace(r.Domain) == "" {
+		return errors.New("Forward rule domain is required, use . to match every name")
+	}
+
+	if strings.Contains(strings.Trim(r.Domain, "."), "..") {
+		return errors.New(fmt.Sprintf("Invalid forward rule domain: %s", r.Domain))
+	}
+
+	if len(r.Upstreams) == 0 {
+		return errors.New("Forward rule requires at least one upstream")
+	}
+
+	for _, upstream := range r.Upstreams {
+		if _, err := dnsaddress.ParseUpstream(upstream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns labels of the domain of the rule, empty for the root
+func (r *ManagedForwardRule) DomainLabels() []string {
+
+	domain := strings.Trim(strings.ToLower(r.Domain), ".")
+	if domain == "" {
+		return []string{}
+	}
+
+	return strings.Split(domain, ".")
+}
+
+// Storage of conditional forwarding rules of the DNS server
+type ForwardRepository interface {
+	GetForwardRules(ctx context.Context) ([]ManagedForwardRule, error)
+	CreateForwardRule(ctx context.Context, rule *ManagedForwardRule) error
+	DeleteForwardRule(ctx context.Context, id int) error
+}
+
+// Implemented by repositories able to report changes of forward rules made by other processes
+type ForwardRulesChangesSource interface {
+	// Reports changes until the context is done or the connection is lost. Listening is called once
+	// changes are being listened for, changes made before that are not reported
+	WatchForwardRulesChanges(ctx context.Context, listening func(), changed func()) error
+}
+
+// Notifies listeners that forward rules changed once the transaction commits
+func notifyForwardRulesChange(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_notify(?, ?)", FORWARD_RULES_CHANGES_CHANNEL, "").Error
+}
+
+func (r *PostgresRecordsRepository) WatchForwardRulesChanges(ctx context.Context, listening func(), changed func()) error {
+	return r.listen(ctx, FORWARD_RULES_CHANGES_CHANNEL, listening, func(payload string) {
+		changed()
+	})
+}
+
+type ForwardService struct {
+	forwardRepository ForwardRepository
+}
+
+func NewForwardService(forwardRepository ForwardRepository) *ForwardService {
+	return &ForwardService{
+		forwardRepository: forwardRepository,
+	}
+}
+
+func (s *ForwardService) GetRules(ctx context.Context) ([]ManagedForwardRule, error) {
+	return s.forwardRepository.GetForwardRules(ctx)
+}
+
+func (s *ForwardService) CreateRule(ctx context.Context, rule *ManagedForwardRule) error {
+	if rule == nil {
+		return errors.New("rule cannot be nil")
+	}
+
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	return s.forwardRepository.CreateForwardRule(ctx, rule)
+}
+
+func (s *ForwardService) DeleteRule(ctx context.Context, id int) error {
+	if id <= 0 {
+		return errors.New("invalid rule ID")
+	}
+
+	return s.forwardRepository.DeleteForwardRule(ctx, id)
+}
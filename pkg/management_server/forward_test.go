@@ -0,0 +1,58 @@
+package managementserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagedForwardRule_Validate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		rule  ManagedForwardRule
+		valid bool
+	}{
+		{
+			name:  "Rule with domain and upstreams should be valid",
+			rule:  ManagedForwardRule{Domain: "corp.internal.", Upstreams: []string{"10.0.0.53", "tls://10.0.0.54#dc.corp.internal"}, NoCache: true},
+			valid: true,
+		},
+		{
+			name:  "Root domain should match every name",
+			rule:  ManagedForwardRule{Domain: ".", Upstreams: []string{"https://dns.google/dns-query"}},
+			valid: true,
+		},
+		{
+			name: "Rule without domain should be rejected",
+			rule: ManagedForwardRule{Upstreams: []string{"10.0.0.53"}},
+		},
+		{
+			name: "Domain with empty label should be rejected",
+			rule: ManagedForwardRule{Domain: "corp..internal", Upstreams: []string{"10.0.0.53"}},
+		},
+		{
+			name: "Rule without upstreams should be rejected",
+			rule: ManagedForwardRule{Domain: "corp.internal"},
+		},
+		{
+			name: "Upstream with unknown scheme should be rejected",
+			rule: ManagedForwardRule{Domain: "corp.internal", Upstreams: []string{"ftp://10.0.0.53"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestManagedForwardRule_DomainLabels(t *testing.T) {
+	assert.Equal(t, []string{"svc", "cluster", "local"}, (&ManagedForwardRule{Domain: "SVC.Cluster.local."}).DomainLabels())
+	assert.Empty(t, (&ManagedForwardRule{Domain: "."}).DomainLabels())
+}
@@ -0,0 +1,244 @@
+package managementserver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Records and rules shared by the repository and its views
+type memoryStore struct {
+	lock    sync.RWMutex
+	records []ManagedDNSResourceRecord
+	nextID  int
+
+	rules      []ManagedACLRule
+	nextRuleID int
+
+	forwardRules      []ManagedForwardRule
+	nextForwardRuleID int
+}
+
+// Keeps records in memory, used for zones defined in the configuration instead of the database
+type MemoryRecordsRepository struct {
+	*memoryStore
+
+	// View the repository is limited to, nil for records of all views
+	view *string
+}
+
+func NewMemoryRecordsRepository(records []ManagedDNSResourceRecord) *MemoryRecordsRepository {
+
+	repository := &MemoryRecordsRepository{memoryStore: &memoryStore{nextID: 1, nextRuleID: 1, nextForwardRuleID: 1}}
+	for _, rr := range records {
+		repository.CreateRecord(context.Background(), &rr)
+	}
+
+	return repository
+}
+
+func (r *MemoryRecordsRepository) ForView(view string) RecordsRepository {
+	return &MemoryRecordsRepository{memoryStore: r.memoryStore, view: &view}
+}
+
+func (r *MemoryRecordsRepository) inView(rr *ManagedDNSResourceRecord) bool {
+	return r.view == nil || rr.View == *r.view
+}
+
+// Returns records of the view matching the filter
+func (r *MemoryRecordsRepository) filter(match func(rr *ManagedDNSResourceRecord) bool) []ManagedDNSResourceRecord {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	records := make([]ManagedDNSResourceRecord, 0)
+	for i := range r.records {
+		if r.inView(&r.records[i]) && match(&r.records[i]) {
+			records = append(records, r.records[i])
+		}
+	}
+
+	return records
+}
+
+func (r *MemoryRecordsRepository) GetRecords(ctx context.Context) ([]ManagedDNSResourceRecord, error) {
+	return r.filter(func(rr *ManagedDNSResourceRecord) bool { return true }), nil
+}
+
+func (r *MemoryRecordsRepository) GetRecordsByName(ctx context.Context, name string) ([]ManagedDNSResourceRecord, error) {
+	return r.filter(func(rr *ManagedDNSResourceRecord) bool {
+		return strings.EqualFold(rr.Name, name)
+	}), nil
+}
+
+func (r *MemoryRecordsRepository) GetRecordsByType(ctx context.Context, recordTypes ...ManagedDNSRecordType) ([]ManagedDNSResourceRecord, error) {
+	return r.filter(func(rr *ManagedDNSResourceRecord) bool {
+		for _, recordType := range recordTypes {
+			if rr.Type == recordType {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (r *MemoryRecordsRepository) GetRecordsByNameAndType(
+	ctx context.Context,
+	name string,
+	recordType ManagedDNSRecordType,
+	class ManagedDNSRecordClass,
+) ([]ManagedDNSResourceRecord, error) {
+	return r.filter(func(rr *ManagedDNSResourceRecord) bool {
+		return strings.EqualFold(rr.Name, name) && rr.Type == recordType && rr.Class == class
+	}), nil
+}
+
+func (r *MemoryRecordsRepository) NameExists(ctx context.Context, name string) (bool, error) {
+	records, err := r.GetRecordsByName(ctx, name)
+	return len(records) > 0, err
+}
+
+func (r *MemoryRecordsRepository) HasDescendants(ctx context.Context, name string) (bool, error) {
+	suffix := "." + strings.ToLower(name)
+	records := r.filter(func(rr *ManagedDNSResourceRecord) bool {
+		return strings.HasSuffix(strings.ToLower(rr.Name), suffix)
+	})
+	return len(records) > 0, nil
+}
+
+func (r *MemoryRecordsRepository) GetRecordsByAddress(ctx context.Context, address net.IP) ([]ManagedDNSResourceRecord, error) {
+	return r.filter(func(rr *ManagedDNSResourceRecord) bool {
+		return (rr.Type == ManagedDNSRecordType_A || rr.Type == ManagedDNSRecordType_AAAA) && address.Equal(net.ParseIP(rr.Data))
+	}), nil
+}
+
+func (r *MemoryRecordsRepository) GetZones(ctx context.Context) ([]Zone, error) {
+	records, err := r.GetRecordsByType(ctx, ManagedDNSRecordType_SOA)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make([]Zone, 0, len(records))
+	for _, soa := range records {
+		zones = append(zones, NewZone(soa))
+	}
+	return zones, nil
+}
+
+func (r *MemoryRecordsRepository) CreateRecord(ctx context.Context, record *ManagedDNSResourceRecord) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.view != nil {
+		record.View = *r.view
+	}
+
+	record.ID = r.nextID
+	r.nextID++
+
+	r.records = append(r.records, *record)
+	return nil
+}
+
+func (r *MemoryRecordsRepository) DeleteRecord(ctx context.Context, id int) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.records {
+		if r.records[i].ID == id && r.inView(&r.records[i]) {
+			r.records = append(r.records[:i], r.records[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRecordsRepository) GetACLRules(ctx context.Context) ([]ManagedACLRule, error) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	rules := append([]ManagedACLRule{}, r.rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+
+	return rules, nil
+}
+
+func (r *MemoryRecordsRepository) CreateACLRule(ctx context.Context, rule *ManagedACLRule) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	rule.ID = r.nextRuleID
+	r.nextRuleID++
+
+	r.rules = append(r.rules, *rule)
+	return nil
+}
+
+func (r *MemoryRecordsRepository) DeleteACLRule(ctx context.Context, id int) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.rules {
+		if r.rules[i].ID == id {
+			r.rules = append(r.rules[:i], r.rules[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRecordsRepository) AddACLRuleHits(ctx context.Context, hits map[int]uint64) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.rules {
+		r.rules[i].Hits += hits[r.rules[i].ID]
+	}
+	return nil
+}
+
+func (r *MemoryRecordsRepository) GetForwardRules(ctx context.Context) ([]ManagedForwardRule, error) {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return append([]ManagedForwardRule{}, r.forwardRules...), nil
+}
+
+func (r *MemoryRecordsRepository) CreateForwardRule(ctx context.Context, rule *ManagedForwardRule) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	rule.ID = r.nextForwardRuleID
+	r.nextForwardRuleID++
+
+	r.forwardRules = append(r.forwardRules, *rule)
+	return nil
+}
+
+func (r *MemoryRecordsRepository) DeleteForwardRule(ctx context.Context, id int) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.forwardRules {
+		if r.forwardRules[i].ID == id {
+			r.forwardRules = append(r.forwardRules[:i], r.forwardRules[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRecordsRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,54 @@
+package managementserver
+
+import (
+	"net"
+	"strings"
+
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+// Builds PTR records pointing reverse names of A and AAAA records back to their owners.
+// Reverse names having explicit PTR records are skipped, as explicit records take precedence.
+func SynthesizePTRRecords(records []ManagedDNSResourceRecord) []ManagedDNSResourceRecord {
+
+	explicit := make(map[string]bool)
+	for _, r := range records {
+		if r.Type == ManagedDNSRecordType_PTR {
+			explicit[strings.ToLower(strings.TrimSuffix(r.Name, "."))] = true
+		}
+	}
+
+	synthesized := make([]ManagedDNSResourceRecord, 0)
+	seen := make(map[string]bool)
+
+	for _, r := range records {
+		if r.Type != ManagedDNSRecordType_A && r.Type != ManagedDNSRecordType_AAAA {
+			continue
+		}
+
+		address := net.ParseIP(r.Data)
+		if address == nil {
+			continue
+		}
+
+		reverseName := strings.Join(record.ReverseName(address), ".")
+
+		// Several names can share an address, each of them gets its own PTR record
+		key := reverseName + " " + strings.ToLower(r.Name)
+		if explicit[reverseName] || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		synthesized = append(synthesized, ManagedDNSResourceRecord{
+			Name:  reverseName,
+			Type:  ManagedDNSRecordType_PTR,
+			Class: r.Class,
+			Data:  r.Name,
+			Ttl:   r.Ttl,
+			View:  r.View,
+		})
+	}
+
+	return synthesized
+}
@@ -0,0 +1,36 @@
+package managementserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSynthesizePTRRecords(t *testing.T) {
+	records := []ManagedDNSResourceRecord{
+		{Name: "host.example.com", Type: ManagedDNSRecordType_A, Class: ManagedDNSRecordClass_IN, Data: "192.168.1.10", Ttl: 300},
+		{Name: "host.example.com", Type: ManagedDNSRecordType_AAAA, Class: ManagedDNSRecordClass_IN, Data: "2001:db8::1", Ttl: 300},
+		{Name: "mail.example.com", Type: ManagedDNSRecordType_A, Class: ManagedDNSRecordClass_IN, Data: "192.168.1.20", Ttl: 300},
+		{Name: "20.1.168.192.in-addr.arpa", Type: ManagedDNSRecordType_PTR, Class: ManagedDNSRecordClass_IN, Data: "smtp.example.com"},
+		{Name: "host.example.com", Type: ManagedDNSRecordType_TXT, Class: ManagedDNSRecordClass_IN, Data: "text"},
+	}
+
+	synthesized := SynthesizePTRRecords(records)
+
+	assert.Equal(t, []ManagedDNSResourceRecord{
+		{
+			Name:  "10.1.168.192.in-addr.arpa",
+			Type:  ManagedDNSRecordType_PTR,
+			Class: ManagedDNSRecordClass_IN,
+			Data:  "host.example.com",
+			Ttl:   300,
+		},
+		{
+			Name:  "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+			Type:  ManagedDNSRecordType_PTR,
+			Class: ManagedDNSRecordClass_IN,
+			Data:  "host.example.com",
+			Ttl:   300,
+		},
+	}, synthesized)
+}
@@ -1,10 +1,12 @@
 package managementserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	record "github.com/XxRoloxX/dns/pkg/dns_record"
@@ -31,6 +33,8 @@ const (
 	ManagedDNSRecordType_TXT   ManagedDNSRecordType = "TXT"
 	ManagedDNSRecordType_NS    ManagedDNSRecordType = "NS"
 	ManagedDNSRecordType_SOA   ManagedDNSRecordType = "SOA"
+	ManagedDNSRecordType_PTR   ManagedDNSRecordType = "PTR"
+	ManagedDNSRecordType_SRV   ManagedDNSRecordType = "SRV"
 )
 
 func ConvertRecordTypeToCode(recordType ManagedDNSRecordType) (uint16, error) {
@@ -43,11 +47,46 @@ func ConvertRecordTypeToCode(recordType ManagedDNSRecordType) (uint16, error) {
 		return record.ResourceRecordType__MX, nil
 	case ManagedDNSRecordType_TXT:
 		return record.ResourceRecordType__TXT, nil
+	case ManagedDNSRecordType_PTR:
+		return record.ResourceRecordType__PTR, nil
+	case ManagedDNSRecordType_CNAME:
+		return record.ResourceRecordType__CNAME, nil
+	case ManagedDNSRecordType_NS:
+		return record.ResourceRecordType__NS, nil
+	case ManagedDNSRecordType_SOA:
+		return record.ResourceRecordType__SOA, nil
+	case ManagedDNSRecordType_SRV:
+		return record.ResourceRecordType__SRV, nil
 	default:
 		return 0, errors.New("invalid RecordType")
 	}
 }
 
+func ConvertCodeToRecordType(code record.ResourceRecordType) (ManagedDNSRecordType, error) {
+	switch code {
+	case record.ResourceRecordType__A:
+		return ManagedDNSRecordType_A, nil
+	case record.ResourceRecordType__AAAA:
+		return ManagedDNSRecordType_AAAA, nil
+	case record.ResourceRecordType__MX:
+		return ManagedDNSRecordType_MX, nil
+	case record.ResourceRecordType__TXT:
+		return ManagedDNSRecordType_TXT, nil
+	case record.ResourceRecordType__PTR:
+		return ManagedDNSRecordType_PTR, nil
+	case record.ResourceRecordType__CNAME:
+		return ManagedDNSRecordType_CNAME, nil
+	case record.ResourceRecordType__NS:
+		return ManagedDNSRecordType_NS, nil
+	case record.ResourceRecordType__SOA:
+		return ManagedDNSRecordType_SOA, nil
+	case record.ResourceRecordType__SRV:
+		return ManagedDNSRecordType_SRV, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported resource record type code: %d", code))
+	}
+}
+
 // DNS Record Class Enums
 type ManagedDNSRecordClass string
 
@@ -55,9 +94,12 @@ func (c *ManagedDNSRecordClass) ConvertToResourceRecordClass() (record.ResourceR
 	switch *c {
 	case ManagedDNSRecordClass_IN:
 		return record.ResourceRecordClass__In, nil
-
+	case ManagedDNSRecordClass_CS:
+		return record.ResourceRecordClass__Cs, nil
 	case ManagedDNSRecordClass_CH:
 		return record.ResourceRecordClass__Ch, nil
+	case ManagedDNSRecordClass_HS:
+		return record.ResourceRecordClass__Hs, nil
 	case ManagedDNSRecordClass_REVIEW:
 		return record.ResourceRecordClass__Review, nil
 	default:
@@ -65,6 +107,23 @@ func (c *ManagedDNSRecordClass) ConvertToResourceRecordClass() (record.ResourceR
 	}
 }
 
+func ConvertCodeToRecordClass(code record.ResourceRecordClass) (ManagedDNSRecordClass, error) {
+	switch code {
+	case record.ResourceRecordClass__In:
+		return ManagedDNSRecordClass_IN, nil
+	case record.ResourceRecordClass__Cs:
+		return ManagedDNSRecordClass_CS, nil
+	case record.ResourceRecordClass__Ch:
+		return ManagedDNSRecordClass_CH, nil
+	case record.ResourceRecordClass__Hs:
+		return ManagedDNSRecordClass_HS, nil
+	case record.ResourceRecordClass__Review:
+		return ManagedDNSRecordClass_REVIEW, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported resource record class code: %d", code))
+	}
+}
+
 const (
 	ManagedDNSRecordClass_IN     ManagedDNSRecordClass = "IN"     // Internet
 	ManagedDNSRecordClass_CS     ManagedDNSRecordClass = "CS"     // CSNET
@@ -79,6 +138,34 @@ type ManagedDNSResourceRecord struct {
 	Type  ManagedDNSRecordType  `gorm:"not null" json:"type"`
 	Class ManagedDNSRecordClass `gorm:"not null" json:"class"`
 	Data  string                `gorm:"not null" json:"data"`
+
+	// Default keeps migrations of tables created before the column working, it has to match record.DEFAULT_TTL
+	Ttl uint32 `gorm:"not null;default:1080" json:"ttl"`
+
+	// Split-horizon view the record is served in, empty for the default view
+	View string `gorm:"not null;default:'';index" json:"view"`
+
+	// Set on SOA records, answers PTR queries within the zone with records synthesized from A/AAAA records
+	SynthesizePTR bool `gorm:"not null;default:false" json:"synthesize_ptr,omitempty"`
+}
+
+// Name of the view served to clients matching no other view
+const DEFAULT_VIEW = ""
+
+// View names consist of letters, digits, "-" and "_", the empty name stands for the default view
+func IsValidViewName(view string) bool {
+
+	if len(view) > 63 {
+		return false
+	}
+
+	for _, c := range view {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (r *ManagedDNSResourceRecord) ConvertToResourceRecord() (record.ResourceRecord, error) {
@@ -106,91 +193,418 @@ func (r *ManagedDNSResourceRecord) ConvertToResourceRecord() (record.ResourceRec
 		return record.NewAAAARecord(names, class, address), nil
 
 	case ManagedDNSRecordType_CNAME:
-		domain := strings.Split(r.Data, ".") // Assuming the domain is comma-separated
-		return record.NewCNAMERecord(names, class, domain), nil
+		return record.NewCNAMERecord(names, class, splitDomainName(r.Data)), nil
 
 	case ManagedDNSRecordType_TXT:
 		return record.NewTXTRecord(names, class, []byte(r.Data)), nil
 
 	case ManagedDNSRecordType_MX:
-		return record.NewMXRecord(names, class, []byte(r.Data)), nil
+		return parseMXRecord(names, class, r.Data)
+
+	case ManagedDNSRecordType_SRV:
+		return parseSRVRecord(names, class, r.Data)
+
+	case ManagedDNSRecordType_PTR:
+		return record.NewPTRRecord(names, class, splitDomainName(r.Data)), nil
+
+	case ManagedDNSRecordType_SOA:
+		return parseSOARecord(names, class, r.Data)
+
+	case ManagedDNSRecordType_NS:
+		return record.NewNSRecord(names, class, splitDomainName(r.Data)), nil
 
 	default:
 		return nil, errors.New("unsupported record type")
 	}
 }
 
+// Rewrites address of A and AAAA records to its canonical form, e.g. "2001:db8::1" for "2001:0DB8:0:0::1",
+// so records can be looked up by address
+func (r *ManagedDNSResourceRecord) NormalizeAddress() {
+
+	if r.Type != ManagedDNSRecordType_A && r.Type != ManagedDNSRecordType_AAAA {
+		return
+	}
+
+	if address := net.ParseIP(r.Data); address != nil {
+		r.Data = address.String()
+	}
+}
+
+// Returns name the record points to, for record types referring to other names
+func (r *ManagedDNSResourceRecord) Target() (string, bool) {
+	switch r.Type {
+	case ManagedDNSRecordType_NS, ManagedDNSRecordType_CNAME, ManagedDNSRecordType_PTR:
+		return strings.TrimSuffix(r.Data, "."), true
+	case ManagedDNSRecordType_MX, ManagedDNSRecordType_SRV:
+		fields := strings.Fields(r.Data)
+		if len(fields) == 0 {
+			return "", false
+		}
+		return strings.TrimSuffix(fields[len(fields)-1], "."), true
+	default:
+		return "", false
+	}
+}
+
+// Splits domain name into labels, ignoring trailing dot of fully qualified names
+func splitDomainName(name string) []string {
+	return strings.Split(strings.TrimSuffix(name, "."), ".")
+}
+
+// Parses SOA data in the zone file format: "mname rname serial refresh retry expire minimum"
+func parseSOARecord(names []string, class record.ResourceRecordClass, data string) (*record.SOARecord, error) {
+
+	fields := strings.Fields(data)
+	if len(fields) != 7 {
+		return nil, errors.New(fmt.Sprintf("invalid SOA data, expected 7 fields, got %d", len(fields)))
+	}
+
+	values := make([]uint32, 0, 5)
+	for _, field := range fields[2:] {
+		value, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid SOA timer value: %s", field))
+		}
+		values = append(values, uint32(value))
+	}
+
+	return record.NewSOARecord(
+		names,
+		class,
+		splitDomainName(fields[0]),
+		splitDomainName(fields[1]),
+		values[0], values[1], values[2], values[3], values[4],
+	), nil
+}
+
+// Parses MX data in the zone file format: "preference exchange"
+func parseMXRecord(names []string, class record.ResourceRecordClass, data string) (*record.MXRecord, error) {
+
+	fields := strings.Fields(data)
+	if len(fields) != 2 {
+		return nil, errors.New(fmt.Sprintf("invalid MX data, expected 2 fields, got %d", len(fields)))
+	}
+
+	preference, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid MX preference: %s", fields[0]))
+	}
+
+	return record.NewMXRecord(names, class, uint16(preference), splitDomainName(fields[1])), nil
+}
+
+// Parses SRV data in the zone file format: "priority weight port target"
+func parseSRVRecord(names []string, class record.ResourceRecordClass, data string) (*record.SRVRecord, error) {
+
+	fields := strings.Fields(data)
+	if len(fields) != 4 {
+		return nil, errors.New(fmt.Sprintf("invalid SRV data, expected 4 fields, got %d", len(fields)))
+	}
+
+	values := make([]uint16, 0, 3)
+	for _, field := range fields[:3] {
+		value, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid SRV value: %s", field))
+		}
+		values = append(values, uint16(value))
+	}
+
+	return record.NewSRVRecord(names, class, values[0], values[1], values[2], splitDomainName(fields[3])), nil
+}
+
+// Storage of the records. Calls are cancelled together with the context
 type RecordsRepository interface {
-	GetRecords() ([]ManagedDNSResourceRecord, error)
-	GetRecordsByName(name string) ([]ManagedDNSResourceRecord, error)
-	CreateRecord(record *ManagedDNSResourceRecord) error
-	DeleteRecord(id int) error
+	GetRecords(ctx context.Context) ([]ManagedDNSResourceRecord, error)
+	GetRecordsByName(ctx context.Context, name string) ([]ManagedDNSResourceRecord, error)
+	GetRecordsByType(ctx context.Context, recordTypes ...ManagedDNSRecordType) ([]ManagedDNSResourceRecord, error)
+	GetRecordsByNameAndType(ctx context.Context, name string, recordType ManagedDNSRecordType, class ManagedDNSRecordClass) ([]ManagedDNSResourceRecord, error)
+	NameExists(ctx context.Context, name string) (bool, error)
+	HasDescendants(ctx context.Context, name string) (bool, error)
+
+	// Returns A and AAAA records pointing to the address
+	GetRecordsByAddress(ctx context.Context, address net.IP) ([]ManagedDNSResourceRecord, error)
+
+	GetZones(ctx context.Context) ([]Zone, error)
+	CreateRecord(ctx context.Context, record *ManagedDNSResourceRecord) error
+	DeleteRecord(ctx context.Context, id int) error
+
+	// Returns repository limited to records of the view, records created through it are placed in the view
+	ForView(view string) RecordsRepository
+
+	// Releases connections held by the repository
+	Close() error
 }
 
 type PostgresRecordsRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	config PostgresConfig
+
+	// View the repository is limited to, nil for records of all views
+	view *string
+}
+
+func (r *PostgresRecordsRepository) ForView(view string) RecordsRepository {
+	return &PostgresRecordsRepository{db: r.db, config: r.config, view: &view}
+}
+
+// Returns query of records, limited to the view of the repository
+func (r *PostgresRecordsRepository) records(ctx context.Context) *gorm.DB {
+	return r.scope(r.db.WithContext(ctx))
+}
+
+func (r *PostgresRecordsRepository) scope(db *gorm.DB) *gorm.DB {
+	query := db.Model(&ManagedDNSResourceRecord{})
+	if r.view != nil {
+		query = query.Where("view = ?", *r.view)
+	}
+	return query
 }
 
-func (r *PostgresRecordsRepository) GetRecords() ([]ManagedDNSResourceRecord, error) {
+func (r *PostgresRecordsRepository) GetRecords(ctx context.Context) ([]ManagedDNSResourceRecord, error) {
 	var records []ManagedDNSResourceRecord
-	if err := r.db.Find(&records).Error; err != nil {
+	if err := r.records(ctx).Find(&records).Error; err != nil {
 		return nil, err
 	}
 	return records, nil
 }
 
-func (r *PostgresRecordsRepository) GetRecordsByName(name string) ([]ManagedDNSResourceRecord, error) {
+func (r *PostgresRecordsRepository) GetRecordsByName(ctx context.Context, name string) ([]ManagedDNSResourceRecord, error) {
 	var records []ManagedDNSResourceRecord
-	if err := r.db.Find(&records, ManagedDNSResourceRecord{
-		Name: name,
-	}).Error; err != nil {
+	if err := r.records(ctx).Where("LOWER(name) = LOWER(?)", name).Find(&records).Error; err != nil {
 		return nil, err
 	}
 	return records, nil
 }
 
-func (r *PostgresRecordsRepository) CreateRecord(record *ManagedDNSResourceRecord) error {
-	if err := r.db.Create(record).Error; err != nil {
-		return err
+func (r *PostgresRecordsRepository) GetRecordsByType(ctx context.Context, recordTypes ...ManagedDNSRecordType) ([]ManagedDNSResourceRecord, error) {
+	var records []ManagedDNSResourceRecord
+	if err := r.records(ctx).Where("type IN ?", recordTypes).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *PostgresRecordsRepository) GetRecordsByNameAndType(
+	ctx context.Context,
+	name string,
+	recordType ManagedDNSRecordType,
+	class ManagedDNSRecordClass,
+) ([]ManagedDNSResourceRecord, error) {
+	var records []ManagedDNSResourceRecord
+	if err := r.records(ctx).
+		Where("LOWER(name) = LOWER(?) AND type = ? AND class = ?", name, recordType, class).
+		Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Checks whether any record, regardless of its type, is owned by the name
+func (r *PostgresRecordsRepository) NameExists(ctx context.Context, name string) (bool, error) {
+	var count int64
+	if err := r.records(ctx).
+		Where("LOWER(name) = LOWER(?)", name).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Checks whether any record is owned by a name below the given one,
+// which makes the name exist even when it owns no records (empty non-terminal)
+func (r *PostgresRecordsRepository) HasDescendants(ctx context.Context, name string) (bool, error) {
+	var count int64
+	if err := r.records(ctx).
+		Where("LOWER(name) LIKE ?", "%."+escapeLikePattern(strings.ToLower(name))).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Addresses are stored in their canonical form, see NormalizeAddress
+func (r *PostgresRecordsRepository) GetRecordsByAddress(ctx context.Context, address net.IP) ([]ManagedDNSResourceRecord, error) {
+	var records []ManagedDNSResourceRecord
+	if err := r.records(ctx).
+		Where("type IN ? AND data = ?", []ManagedDNSRecordType{ManagedDNSRecordType_A, ManagedDNSRecordType_AAAA}, address.String()).
+		Find(&records).Error; err != nil {
+		return nil, err
 	}
-	return nil
+	return records, nil
+}
+
+// Escapes characters having special meaning in LIKE patterns, e.g. "_" used in "_dmarc"
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
 }
 
-func (r *PostgresRecordsRepository) DeleteRecord(id int) error {
-	if err := r.db.Delete(&ManagedDNSResourceRecord{}, id).Error; err != nil {
+// Returns zones the server is authoritative for, one per SOA record
+func (r *PostgresRecordsRepository) GetZones(ctx context.Context) ([]Zone, error) {
+	records, err := r.GetRecordsByType(ctx, ManagedDNSRecordType_SOA)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make([]Zone, 0, len(records))
+	for _, soa := range records {
+		zones = append(zones, NewZone(soa))
+	}
+	return zones, nil
+}
+
+// Creates the record, notifying the DNS servers about it
+func (r *PostgresRecordsRepository) CreateRecord(ctx context.Context, record *ManagedDNSResourceRecord) error {
+	if r.view != nil {
+		record.View = *r.view
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return notifyRecordsChange(tx, RecordsChange{Operation: RecordsChangeOperation_Create, ID: record.ID, Record: record})
+	})
+}
+
+// Deletes the record, notifying the DNS servers about it
+func (r *PostgresRecordsRepository) DeleteRecord(ctx context.Context, id int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := r.scope(tx).Delete(&ManagedDNSResourceRecord{}, id)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return notifyRecordsChange(tx, RecordsChange{Operation: RecordsChangeOperation_Delete, ID: id})
+	})
+}
+
+func (r *PostgresRecordsRepository) GetACLRules(ctx context.Context) ([]ManagedACLRule, error) {
+	var rules []ManagedACLRule
+	if err := r.db.WithContext(ctx).Order("priority, id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *PostgresRecordsRepository) CreateACLRule(ctx context.Context, rule *ManagedACLRule) error {
+	return r.db.WithContext(ctx).Create(rule).Error
+}
+
+func (r *PostgresRecordsRepository) DeleteACLRule(ctx context.Context, id int) error {
+	return r.db.WithContext(ctx).Delete(&ManagedACLRule{}, id).Error
+}
+
+func (r *PostgresRecordsRepository) AddACLRuleHits(ctx context.Context, hits map[int]uint64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for id, count := range hits {
+			if err := tx.Model(&ManagedACLRule{}).
+				Where("id = ?", id).
+				Update("hits", gorm.Expr("hits + ?", count)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgresRecordsRepository) GetForwardRules(ctx context.Context) ([]ManagedForwardRule, error) {
+	var rules []ManagedForwardRule
+	if err := r.db.WithContext(ctx).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Creates the rule, notifying the DNS servers about it
+func (r *PostgresRecordsRepository) CreateForwardRule(ctx context.Context, rule *ManagedForwardRule) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rule).Error; err != nil {
+			return err
+		}
+		return notifyForwardRulesChange(tx)
+	})
+}
+
+// Deletes the rule, notifying the DNS servers about it
+func (r *PostgresRecordsRepository) DeleteForwardRule(ctx context.Context, id int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&ManagedForwardRule{}, id).Error; err != nil {
+			return err
+		}
+		return notifyForwardRulesChange(tx)
+	})
+}
+
+func (r *PostgresRecordsRepository) Close() error {
+	db, err := r.db.DB()
+	if err != nil {
 		return err
 	}
-	return nil
+	return db.Close()
 }
 
-func createConnectionString() string {
-	host := os.Getenv(DB_HOST_KEY)
-	user := os.Getenv(DB_USER_KEY)
-	password := os.Getenv(DB_PASSWORD_KEY)
-	dbname := os.Getenv(DB_NAME_KEY)
-	port := os.Getenv(DB_PORT_KEY)
+// Connection parameters of the Postgres database
+type PostgresConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	Port     string `yaml:"port" toml:"port"`
+}
+
+// Reads connection parameters from DB_* variables, keeping values of the config for unset ones
+func (c *PostgresConfig) ApplyEnv() {
+	for key, value := range map[string]*string{
+		DB_HOST_KEY:     &c.Host,
+		DB_USER_KEY:     &c.User,
+		DB_PASSWORD_KEY: &c.Password,
+		DB_NAME_KEY:     &c.Name,
+		DB_PORT_KEY:     &c.Port,
+	} {
+		if env := os.Getenv(key); env != "" {
+			*value = env
+		}
+	}
+}
 
+func (c *PostgresConfig) connectionString() string {
 	return fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		host, user, password, dbname, port,
+		c.Host, c.User, c.Password, c.Name, c.Port,
 	)
 }
 
-func NewPostgresRecordsRepository() *PostgresRecordsRepository {
+// Connects to the database and migrates its schema
+func OpenPostgresRecordsRepository(config PostgresConfig) (*PostgresRecordsRepository, error) {
 	db, err := gorm.Open(
-		postgres.Open(createConnectionString()),
+		postgres.Open(config.connectionString()),
 		&gorm.Config{},
 	)
 
 	if err != nil {
-		panic(fmt.Sprintf("Failed to connect to the database: %s", err))
+		return nil, errors.New(fmt.Sprintf("Failed to connect to the database at %s:%s: %s", config.Host, config.Port, err))
 	}
 
-	if err := db.AutoMigrate(&ManagedDNSResourceRecord{}); err != nil {
-		panic(fmt.Sprintf("Failed to migrate database schema: %s", err))
+	if err := db.AutoMigrate(&ManagedDNSResourceRecord{}, &ManagedACLRule{}, &ManagedForwardRule{}); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to migrate database schema: %s", err))
 	}
 
 	return &PostgresRecordsRepository{
-		db: db,
+		db:     db,
+		config: config,
+	}, nil
+}
+
+func NewPostgresRecordsRepository() *PostgresRecordsRepository {
+
+	var config PostgresConfig
+	config.ApplyEnv()
+
+	repository, err := OpenPostgresRecordsRepository(config)
+	if err != nil {
+		panic(err.Error())
 	}
+
+	return repository
 }
@@ -11,6 +11,8 @@ type RecordsRouterParams struct {
 	NewRecordController    Controller
 	DeleteRecordController Controller
 	GetRecordsController   Controller
+
+	GetSynthesizedPTRRecordsController Controller
 }
 
 func NewRecordsRouter(params *RecordsRouterParams) *gin.RouterGroup {
@@ -19,7 +21,58 @@ func NewRecordsRouter(params *RecordsRouterParams) *gin.RouterGroup {
 
 	router.POST("", params.NewRecordController.Handle)
 	router.GET("", params.GetRecordsController.Handle)
+	router.GET("/ptr", params.GetSynthesizedPTRRecordsController.Handle)
 	router.DELETE("/:id", params.DeleteRecordController.Handle)
 
 	return router
 }
+
+type ACLRouterParams struct {
+	Engine                  *gin.Engine
+	GetACLRulesController   Controller
+	NewACLRuleController    Controller
+	DeleteACLRuleController Controller
+}
+
+func NewACLRouter(params *ACLRouterParams) *gin.RouterGroup {
+
+	router := params.Engine.Group("/acl")
+
+	router.GET("", params.GetACLRulesController.Handle)
+	router.POST("", params.NewACLRuleController.Handle)
+	router.DELETE("/:id", params.DeleteACLRuleController.Handle)
+
+	return router
+}
+
+type ForwardRouterParams struct {
+	Engine                      *gin.Engine
+	GetForwardRulesController   Controller
+	NewForwardRuleController    Controller
+	DeleteForwardRuleController Controller
+}
+
+func NewForwardRouter(params *ForwardRouterParams) *gin.RouterGroup {
+
+	router := params.Engine.Group("/forward")
+
+	router.GET("", params.GetForwardRulesController.Handle)
+	router.POST("", params.NewForwardRuleController.Handle)
+	router.DELETE("/:id", params.DeleteForwardRuleController.Handle)
+
+	return router
+}
+
+type CacheRouterParams struct {
+	Engine               *gin.Engine
+	FlushCacheController Controller
+}
+
+func NewCacheRouter(params *CacheRouterParams) *gin.RouterGroup {
+
+	router := params.Engine.Group("/cache")
+
+	router.POST("/flush", params.FlushCacheController.Handle)
+
+	return router
+}
@@ -22,6 +22,31 @@ func (s *Server) Start() {
 		NewRecordController:    NewNewRecordController(service),
 		GetRecordsController:   NewGetRecordsController(service),
 		DeleteRecordController: NewDeleteRecordController(service),
+
+		GetSynthesizedPTRRecordsController: NewGetSynthesizedPTRRecordsController(service),
+	})
+
+	aclService := NewACLService(repository)
+
+	_ = NewACLRouter(&ACLRouterParams{
+		Engine:                  s.engine,
+		GetACLRulesController:   NewGetACLRulesController(aclService),
+		NewACLRuleController:    NewNewACLRuleController(aclService),
+		DeleteACLRuleController: NewDeleteACLRuleController(aclService),
+	})
+
+	forwardService := NewForwardService(repository)
+
+	_ = NewForwardRouter(&ForwardRouterParams{
+		Engine:                      s.engine,
+		GetForwardRulesController:   NewGetForwardRulesController(forwardService),
+		NewForwardRuleController:    NewNewForwardRuleController(forwardService),
+		DeleteForwardRuleController: NewDeleteForwardRuleController(forwardService),
+	})
+
+	_ = NewCacheRouter(&CacheRouterParams{
+		Engine:               s.engine,
+		FlushCacheController: NewFlushCacheController(NewCacheService(repository)),
 	})
 
 	s.engine.Run(":8080")
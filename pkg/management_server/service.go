@@ -1,6 +1,15 @@
 package managementserver
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	dnsrecord "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+// Returned when record can't coexist with records already owned by the name
+var ErrRecordConflict = errors.New("record conflicts with existing records")
 
 type RecordsService struct {
 	recordsRepository RecordsRepository
@@ -12,27 +21,91 @@ func NewRecordsService(recordsRepository RecordsRepository) *RecordsService {
 	}
 }
 
-func (s *RecordsService) GetRecords() ([]ManagedDNSResourceRecord, error) {
+// Returns repository limited to the view, or covering all views when none is given
+func (s *RecordsService) repository(view *string) RecordsRepository {
+	if view == nil {
+		return s.recordsRepository
+	}
+	return s.recordsRepository.ForView(*view)
+}
+
+// Returns records of the view, or of all views when none is given
+func (s *RecordsService) GetRecords(ctx context.Context, view *string) ([]ManagedDNSResourceRecord, error) {
 
-	records, err := s.recordsRepository.GetRecords()
+	records, err := s.repository(view).GetRecords(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return records, nil
 }
 
-func (s *RecordsService) CreateRecord(record *ManagedDNSResourceRecord) error {
+func (s *RecordsService) CreateRecord(ctx context.Context, record *ManagedDNSResourceRecord) error {
 	if record == nil {
 		return errors.New("record cannot be nil")
 	}
 
-	return s.recordsRepository.CreateRecord(record)
+	if record.Ttl == 0 {
+		record.Ttl = dnsrecord.DEFAULT_TTL
+	}
+
+	if record.SynthesizePTR && record.Type != ManagedDNSRecordType_SOA {
+		return errors.New("PTR synthesis can only be enabled on SOA records")
+	}
+
+	record.NormalizeAddress()
+
+	// Views have separate record sets, so records conflict only with ones of the same view
+	repository := s.recordsRepository.ForView(record.View)
+
+	existing, err := repository.GetRecordsByName(ctx, record.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := checkCNAMEExclusivity(record, existing); err != nil {
+		return err
+	}
+
+	return repository.CreateRecord(ctx, record)
 }
 
-func (s *RecordsService) DeleteRecord(id int) error {
+func (s *RecordsService) DeleteRecord(ctx context.Context, id int) error {
 	if id <= 0 {
 		return errors.New("invalid record ID")
 	}
 
-	return s.recordsRepository.DeleteRecord(id)
+	return s.recordsRepository.DeleteRecord(ctx, id)
+}
+
+// Returns PTR records the DNS server would synthesize from A and AAAA records of the view
+func (s *RecordsService) GetSynthesizedPTRRecords(ctx context.Context, view string) ([]ManagedDNSResourceRecord, error) {
+
+	records, err := s.recordsRepository.ForView(view).GetRecordsByType(
+		ctx,
+		ManagedDNSRecordType_A,
+		ManagedDNSRecordType_AAAA,
+		ManagedDNSRecordType_PTR,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return SynthesizePTRRecords(records), nil
+}
+
+// Name owning a CNAME can't own any other records (RFC 1034 §3.6.2),
+// including another CNAME, as the alias can point to a single name only
+func checkCNAMEExclusivity(record *ManagedDNSResourceRecord, existing []ManagedDNSResourceRecord) error {
+
+	for _, r := range existing {
+		if r.Class != record.Class {
+			continue
+		}
+
+		if r.Type == ManagedDNSRecordType_CNAME || record.Type == ManagedDNSRecordType_CNAME {
+			return fmt.Errorf("%w: %s already owns %s record", ErrRecordConflict, r.Name, r.Type)
+		}
+	}
+
+	return nil
 }
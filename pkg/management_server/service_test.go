@@ -0,0 +1,120 @@
+package managementserver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	dnsrecord "github.com/XxRoloxX/dns/pkg/dns_record"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCNAMEExclusivity(t *testing.T) {
+	testCases := []struct {
+		name        string
+		record      ManagedDNSResourceRecord
+		existing    []ManagedDNSResourceRecord
+		expectedErr error
+	}{
+		{
+			name:   "CNAME should be rejected when name owns other records",
+			record: ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_CNAME, Class: ManagedDNSRecordClass_IN},
+			existing: []ManagedDNSResourceRecord{
+				{Name: "www.example.com", Type: ManagedDNSRecordType_A, Class: ManagedDNSRecordClass_IN},
+			},
+			expectedErr: ErrRecordConflict,
+		},
+		{
+			name:   "Record should be rejected when name owns CNAME",
+			record: ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_TXT, Class: ManagedDNSRecordClass_IN},
+			existing: []ManagedDNSResourceRecord{
+				{Name: "www.example.com", Type: ManagedDNSRecordType_CNAME, Class: ManagedDNSRecordClass_IN},
+			},
+			expectedErr: ErrRecordConflict,
+		},
+		{
+			name:   "Second CNAME should be rejected",
+			record: ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_CNAME, Class: ManagedDNSRecordClass_IN},
+			existing: []ManagedDNSResourceRecord{
+				{Name: "www.example.com", Type: ManagedDNSRecordType_CNAME, Class: ManagedDNSRecordClass_IN},
+			},
+			expectedErr: ErrRecordConflict,
+		},
+		{
+			name:   "Records of other types should coexist",
+			record: ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_TXT, Class: ManagedDNSRecordClass_IN},
+			existing: []ManagedDNSResourceRecord{
+				{Name: "www.example.com", Type: ManagedDNSRecordType_A, Class: ManagedDNSRecordClass_IN},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkCNAMEExclusivity(&tc.record, tc.existing)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRecordsService_views(t *testing.T) {
+	ctx := context.Background()
+	service := NewRecordsService(NewMemoryRecordsRepository(nil))
+
+	cname := &ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_CNAME, Class: ManagedDNSRecordClass_IN, Data: "web.example.com"}
+	assert.NoError(t, service.CreateRecord(ctx, cname))
+
+	// Views have separate record sets, so the CNAME of the default view doesn't conflict
+	internal := &ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_A, Class: ManagedDNSRecordClass_IN, Data: "10.0.0.1", View: "internal"}
+	assert.NoError(t, service.CreateRecord(ctx, internal))
+
+	all, err := service.GetRecords(ctx, nil)
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	view := "internal"
+	records, err := service.GetRecords(ctx, &view)
+	assert.NoError(t, err)
+	assert.Equal(t, []ManagedDNSResourceRecord{*internal}, records)
+
+	defaultView := DEFAULT_VIEW
+	records, err = service.GetRecords(ctx, &defaultView)
+	assert.NoError(t, err)
+	assert.Equal(t, []ManagedDNSResourceRecord{*cname}, records)
+
+	ptr, err := service.GetSynthesizedPTRRecords(ctx, "internal")
+	assert.NoError(t, err)
+	assert.Len(t, ptr, 1)
+	assert.Equal(t, "internal", ptr[0].View)
+}
+
+func TestRecordsService_CreateRecord_synthesizePTR(t *testing.T) {
+	ctx := context.Background()
+	service := NewRecordsService(NewMemoryRecordsRepository(nil))
+
+	soa := &ManagedDNSResourceRecord{Name: "8.b.d.0.1.0.0.2.ip6.arpa", Type: ManagedDNSRecordType_SOA, Class: ManagedDNSRecordClass_IN, Data: "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", SynthesizePTR: true}
+	assert.NoError(t, service.CreateRecord(ctx, soa))
+
+	address := &ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_A, Class: ManagedDNSRecordClass_IN, Data: "10.0.0.1", SynthesizePTR: true}
+	assert.Error(t, service.CreateRecord(ctx, address), "PTR synthesis should be enabled on SOA records only")
+
+	// Addresses are stored in canonical form, so records can be found by address
+	aaaa := &ManagedDNSResourceRecord{Name: "www.example.com", Type: ManagedDNSRecordType_AAAA, Class: ManagedDNSRecordClass_IN, Data: "2001:0DB8:0:0::1"}
+	assert.NoError(t, service.CreateRecord(ctx, aaaa))
+	assert.Equal(t, "2001:db8::1", aaaa.Data)
+}
+
+func TestManagedDNSResourceRecord_defaultTtl(t *testing.T) {
+
+	field, ok := reflect.TypeOf(ManagedDNSResourceRecord{}).FieldByName("Ttl")
+	assert.True(t, ok)
+
+	// Records of tables migrated from before the column get the TTL of records created without one
+	assert.Contains(t, field.Tag.Get("gorm"), fmt.Sprintf("default:%d", dnsrecord.DEFAULT_TTL))
+}
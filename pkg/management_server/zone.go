@@ -0,0 +1,68 @@
+package managementserver
+
+import (
+	"errors"
+	"strings"
+
+	record "github.com/XxRoloxX/dns/pkg/dns_record"
+)
+
+// Zone of authority, defined by the SOA record at its apex
+type Zone struct {
+	Name string
+	SOA  ManagedDNSResourceRecord
+}
+
+func NewZone(soa ManagedDNSResourceRecord) Zone {
+	return Zone{
+		Name: strings.ToLower(strings.TrimSuffix(soa.Name, ".")),
+		SOA:  soa,
+	}
+}
+
+// Checks whether the name is the apex of the zone or lies below it
+func (z *Zone) Contains(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return name == z.Name || strings.HasSuffix(name, "."+z.Name)
+}
+
+// Returns SOA RRset placed in the authority section of negative responses.
+// Its TTL is the smaller of SOA TTL and SOA minimum (RFC 2308 §3)
+func (z *Zone) NegativeSOA() (*record.RRSet, error) {
+
+	rr, err := z.SOA.ConvertToResourceRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	soa, ok := rr.(*record.SOARecord)
+	if !ok {
+		return nil, errors.New("zone apex record is not an SOA record")
+	}
+
+	ttl := min(z.SOA.Ttl, soa.Minimum())
+
+	set := record.NewRRSet(soa.Name(), soa.Type(), soa.Class(), ttl)
+	if err := set.Add(soa, ttl); err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// Returns the zone most closely enclosing the name, or nil if the name is outside all of them
+func FindZone(zones []Zone, name string) *Zone {
+
+	var closest *Zone
+	for i := range zones {
+		if !zones[i].Contains(name) {
+			continue
+		}
+
+		if closest == nil || len(zones[i].Name) > len(closest.Name) {
+			closest = &zones[i]
+		}
+	}
+
+	return closest
+}
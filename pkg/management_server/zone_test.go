@@ -0,0 +1,64 @@
+package managementserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindZone(t *testing.T) {
+	zones := []Zone{
+		NewZone(ManagedDNSResourceRecord{Name: "example.com", Type: ManagedDNSRecordType_SOA}),
+		NewZone(ManagedDNSResourceRecord{Name: "sub.example.com.", Type: ManagedDNSRecordType_SOA}),
+	}
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedZone string
+	}{
+		{
+			name:         "Zone apex should belong to the zone",
+			query:        "example.com",
+			expectedZone: "example.com",
+		},
+		{
+			name:         "Name should belong to the closest enclosing zone",
+			query:        "www.SUB.example.com",
+			expectedZone: "sub.example.com",
+		},
+		{
+			name:         "Name sharing only a suffix should not belong to the zone",
+			query:        "notexample.com",
+			expectedZone: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			zone := FindZone(zones, tc.query)
+			if tc.expectedZone == "" {
+				assert.Nil(t, zone)
+				return
+			}
+
+			assert.Equal(t, tc.expectedZone, zone.Name)
+		})
+	}
+}
+
+func TestZone_NegativeSOA(t *testing.T) {
+	zone := NewZone(ManagedDNSResourceRecord{
+		Name:  "example.com",
+		Type:  ManagedDNSRecordType_SOA,
+		Class: ManagedDNSRecordClass_IN,
+		Data:  "ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		Ttl:   3600,
+	})
+
+	soa, err := zone.NegativeSOA()
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(300), soa.Ttl())
+	assert.Equal(t, 1, soa.Len())
+}